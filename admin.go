@@ -0,0 +1,154 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// AdminAPI is a module which exposes the state of the function executors
+// via Caddy's admin endpoint.
+type AdminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.lambda",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the admin routes of the plugin.
+func (a AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/lambda/stats",
+			Handler: caddy.AdminHandlerFunc(a.handleStats),
+		},
+		{
+			Pattern: "/lambda/functions",
+			Handler: caddy.AdminHandlerFunc(a.handleFunctions),
+		},
+		{
+			Pattern: "/lambda/",
+			Handler: caddy.AdminHandlerFunc(a.handleFunction),
+		},
+	}
+}
+
+// handleStats writes the worker counts of the function executors.
+func (AdminAPI) handleStats(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(registry.getStats())
+}
+
+// handleFunctions writes the configuration of the function executors.
+func (AdminAPI) handleFunctions(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(registry.getFunctions())
+}
+
+// handleFunction serves the /lambda/<name>/<action> paths of the function
+// executors with the name.
+func (a AdminAPI) handleFunction(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/lambda/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("resource not found: %s", r.URL.Path),
+		}
+	}
+	name, action := parts[0], parts[1]
+
+	var method string
+	var handle func(http.ResponseWriter, []*FunctionExecutor) error
+	switch action {
+	case "restart":
+		method, handle = http.MethodPost, a.handleRestart
+	case "health":
+		method, handle = http.MethodGet, a.handleHealth
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("resource not found: %s", r.URL.Path),
+		}
+	}
+	if r.Method != method {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	executors := registry.getExecutors(name)
+	if len(executors) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("lambda function %s not found", name),
+		}
+	}
+	return handle(w, executors)
+}
+
+// handleRestart replaces the workers of the function executors, e.g. to
+// reload the handler code after a deploy.
+func (AdminAPI) handleRestart(w http.ResponseWriter, executors []*FunctionExecutor) error {
+	for _, fex := range executors {
+		fex.restart()
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// handleHealth writes the readiness of the function executors. It responds
+// with 503 Service Unavailable when any of them is not ready, e.g. when its
+// health function reports that a dependency is down.
+func (AdminAPI) handleHealth(w http.ResponseWriter, executors []*FunctionExecutor) error {
+	statusCode := http.StatusOK
+	statuses := make([]*healthStatus, 0, len(executors))
+	for _, fex := range executors {
+		status := fex.checkHealth()
+		if !status.Ready {
+			statusCode = http.StatusServiceUnavailable
+		}
+		statuses = append(statuses, status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(statuses)
+}
+
+// Interface guard
+var _ caddy.AdminRouter = (*AdminAPI)(nil)
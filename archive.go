@@ -0,0 +1,128 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveExt is the extension of the archives holding the entrypoint and
+// its dependencies, e.g. bundle.zip/app/index.py.
+const archiveExt = ".zip"
+
+// checkHandlerScript imports the module and exits with an error when the
+// handler is not found in it.
+const checkHandlerScript = `import importlib, sys
+m = importlib.import_module(sys.argv[1])
+if not callable(getattr(m, sys.argv[2], None)):
+    sys.stderr.write("handler %s not found in %s" % (sys.argv[2], sys.argv[1]))
+    sys.exit(1)`
+
+// checkHandlerTimeout is the max time the import of the module by
+// checkHandlerScript takes.
+const checkHandlerTimeout = 30 * time.Second
+
+// splitEntrypointArchive splits the entrypoint in the archive, e.g.
+// bundle.zip/app/index.py, into the path to the archive and the path of
+// the entrypoint in the archive.
+func splitEntrypointArchive(s string) (string, string, bool) {
+	i := strings.Index(s, archiveExt+"/")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i+len(archiveExt)], s[i+len(archiveExt)+1:], true
+}
+
+// checkEntrypointArchive returns the absolute path to the archive, and an
+// error when the archive is not a valid zip file or it has no entrypoint.
+func checkEntrypointArchive(archivePath, name string) (string, error) {
+	fp, err := filepath.Abs(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve archive %s: %v", archivePath, err)
+	}
+	r, err := zip.OpenReader(fp)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive %s: %v", archivePath, err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name == name {
+			return fp, nil
+		}
+	}
+	return "", fmt.Errorf("entrypoint %s not found in archive %s", name, archivePath)
+}
+
+// provisionArchives validates the archives of the entrypoints and the
+// handlers in them. The archives are prepended to the module search path
+// of the workers.
+func (fex *FunctionExecutor) provisionArchives() error {
+	type entrypoint struct {
+		path    string
+		handler string
+	}
+	entrypoints := []entrypoint{{fex.EntrypointPath, fex.EntrypointHandler}}
+	if fex.FallbackEntrypointHandler != "" {
+		entrypoints = append(entrypoints, entrypoint{fex.FallbackEntrypointPath, fex.FallbackEntrypointHandler})
+	}
+	for _, step := range fex.Pipeline {
+		entrypoints = append(entrypoints, entrypoint{step.EntrypointPath, step.EntrypointHandler})
+	}
+
+	var archived []entrypoint
+	for _, ep := range entrypoints {
+		archivePath, name, found := splitEntrypointArchive(ep.path)
+		if !found {
+			continue
+		}
+		fp, err := checkEntrypointArchive(archivePath, name)
+		if err != nil {
+			return err
+		}
+		if len(fex.archivePaths) == 0 || fex.archivePaths[len(fex.archivePaths)-1] != fp {
+			fex.archivePaths = append(fex.archivePaths, fp)
+		}
+		archived = append(archived, ep)
+	}
+
+	// The handlers are checked once all the archives are in the search path.
+	for _, ep := range archived {
+		if err := fex.checkHandler(getEntrypointImport(ep.path), ep.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHandler imports the module with the python executable and returns
+// an error when the handler is not found in it.
+func (fex *FunctionExecutor) checkHandler(importPath, handlerName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkHandlerTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, fex.PythonExecutable, "-c", checkHandlerScript, importPath, handlerName)
+	cmd.Env = fex.getWorkerEnv()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		return fmt.Errorf("failed to import handler %s from %s: %s", handlerName, importPath, lines[len(lines)-1])
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap/zapcore"
+)
+
+// writeTestArchive writes the content of the directory to a zip archive,
+// including the directory entries, as zip -r does.
+func writeTestArchive(t *testing.T, dir string) string {
+	fp := filepath.Join(t.TempDir(), "bundle.zip")
+	f, err := os.Create(fp)
+	if err != nil {
+		t.Fatalf("failed creating archive: %v", err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+		name = filepath.ToSlash(name)
+		if fi.IsDir() {
+			_, err := zw.Create(name + "/")
+			return err
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(w, src)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed writing archive: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed writing archive: %v", err)
+	}
+	return fp
+}
+
+func TestSplitEntrypointArchive(t *testing.T) {
+	for i, tc := range []struct {
+		entrypoint  string
+		archivePath string
+		name        string
+		found       bool
+	}{
+		{entrypoint: "assets/bundle.zip/app/index.py", archivePath: "assets/bundle.zip", name: "app/index.py", found: true},
+		{entrypoint: "bundle.zip/index.py", archivePath: "bundle.zip", name: "index.py", found: true},
+		{entrypoint: "assets/scripts/api/app/index.py"},
+		{entrypoint: "assets/bundle.zip"},
+	} {
+		archivePath, name, found := splitEntrypointArchive(tc.entrypoint)
+		if archivePath != tc.archivePath || name != tc.name || found != tc.found {
+			t.Fatalf("unexpected split of %s: got %q %q %t", tc.entrypoint, archivePath, name, found)
+		}
+		t.Logf("PASS: Test %d", i)
+	}
+}
+
+func TestFunctionExecutorArchive(t *testing.T) {
+	archivePath := writeTestArchive(t, "assets/scripts/api/archive")
+	invalidPath := filepath.Join(t.TempDir(), "invalid.zip")
+	if err := os.WriteFile(invalidPath, []byte("not a zip"), 0o600); err != nil {
+		t.Fatalf("failed writing file: %v", err)
+	}
+
+	for i, tc := range []struct {
+		name       string
+		entrypoint string
+		function   string
+		err        string
+	}{
+		{
+			name:       "test handler imported from archive with its dependencies",
+			entrypoint: archivePath + "/app/index.py",
+			function:   "handler",
+		},
+		{
+			name:       "test entrypoint missing in archive fails provisioning",
+			entrypoint: archivePath + "/app/missing.py",
+			function:   "handler",
+			err:        "entrypoint app/missing.py not found in archive",
+		},
+		{
+			name:       "test handler missing in archive fails provisioning",
+			entrypoint: archivePath + "/app/index.py",
+			function:   "missing",
+			err:        "handler missing not found in app.index",
+		},
+		{
+			name:       "test invalid archive fails provisioning",
+			entrypoint: invalidPath + "/app/index.py",
+			function:   "handler",
+			err:        "failed to open archive",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name archive
+				runtime python
+				python_executable python
+				entrypoint ` + tc.entrypoint + `
+				function ` + tc.function + `
+			}`
+			fex := &FunctionExecutor{}
+			fex.logger = initLogger(zapcore.DebugLevel)
+			if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)); err != nil {
+				t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+			}
+			err := fex.Provision(caddy.Context{Context: context.Background()})
+			defer fex.Cleanup()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("unexpected Provision() error: got %v, want %q", err, tc.err)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected Provision() error: %v", err)
+			}
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != http.StatusOK || string(resp.body) != "hello archive!" {
+				t.Fatalf("unexpected response: %d %s", resp.statusCode, resp.body)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
@@ -0,0 +1,27 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import base64
+import hashlib
+import json
+
+def handler(event: dict) -> dict:
+    body = event["body"]
+    if event["is_base64_encoded"]:
+        body = base64.b64decode(body)
+    response = {
+        "body": json.dumps({"size": len(body), "sha256": hashlib.sha256(body).hexdigest()}),
+        "status_code": 200,
+    }
+    return response
@@ -0,0 +1,37 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import json
+import os
+import tempfile
+
+def handler(event: dict) -> dict:
+    fd, path = tempfile.mkstemp(suffix=".json")
+    with os.fdopen(fd, "w") as f:
+        json.dump({"items": [{"id": i, "name": "item %d" % i} for i in range(100000)]}, f)
+    return {
+        "body_file": path,
+        "status_code": 200,
+        "headers": {"X-Body-File": path},
+    }
+
+def dir_handler(event: dict) -> dict:
+    fd, path = tempfile.mkstemp(suffix=".txt", dir=event["query_params"]["dir"])
+    with os.fdopen(fd, "w") as f:
+        f.write("hello world!")
+    return {
+        "body_file": path,
+        "status_code": 200,
+        "headers": {"X-Body-File": path},
+    }
@@ -0,0 +1,46 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+import json
+
+def handler(event: dict) -> dict:
+    return {
+        "body": json.dumps({"method": event["method"], "path": event["path"]}),
+        "status_code": 200,
+    }
+
+def unpacked_handler(method, path, headers, body, query_params=None) -> dict:
+    return {
+        "body": json.dumps({"method": method, "path": path, "body": body, "query_params": query_params}),
+        "status_code": 200,
+    }
+
+def kwargs_handler(method, **kwargs) -> dict:
+    return {
+        "body": json.dumps({"method": method, "request_id": "request_id" in kwargs}),
+        "status_code": 200,
+    }
+
+def context_handler(path, context) -> dict:
+    return {
+        "body": json.dumps({"path": path, "function_name": context.function_name}),
+        "status_code": 200,
+    }
+
+def unknown_field_handler(method, user) -> dict:
+    return {
+        "body": json.dumps({"method": method, "user": user}),
+        "status_code": 200,
+    }
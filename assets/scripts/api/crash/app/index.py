@@ -0,0 +1,31 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import os
+
+def handler(event: dict) -> dict:
+    return {
+        "body": "ok",
+        "status_code": 200,
+    }
+
+def crash_once_handler(event: dict) -> dict:
+    flag = event["query_params"]["flag"]
+    if not os.path.exists(flag):
+        open(flag, "w").close()
+        os._exit(1)
+    return handler(event)
+
+def crash_handler(event: dict) -> dict:
+    os._exit(1)
@@ -0,0 +1,26 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+from caddy_lambda import LambdaHTTPError
+
+def handler(event: dict) -> dict:
+    if event["headers"].get("X-Api-Key") != "secret":
+        raise LambdaHTTPError(403, "forbidden")
+    return {"status_code": 200, "body": "ok"}
+
+def retry_handler(event: dict) -> dict:
+    raise LambdaHTTPError(503, headers={"Retry-After": "30"})
+
+def malformed_handler(event: dict) -> dict:
+    raise LambdaHTTPError("teapot", "short and stout")
@@ -0,0 +1,28 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+import json
+
+def log(level: str, msg: str, **fields):
+    print("CMD_LOG=" + json.dumps(dict(fields, level=level, msg=msg)))
+
+def handler(event: dict) -> dict:
+    log("info", "created user", user_id=42, tags=["new"])
+    log("critical", "quota exceeded", quota="disk")
+    print("CMD_LOG=not json")
+    return {
+        "body": "ok",
+        "status_code": 200,
+    }
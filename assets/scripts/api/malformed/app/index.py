@@ -0,0 +1,33 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import json
+import sys
+
+def handler(event: dict) -> dict:
+    return {
+        "body": json.dumps({"message": "status code is missing"}),
+    }
+
+def string_handler(event: dict) -> dict:
+    return "hello world!"
+
+def none_handler(event: dict) -> dict:
+    return None
+
+def unterminated_handler(event: dict) -> dict:
+    # The output without the trailing newline is joined with the line of
+    # the output marker printed next, so the plugin finds no markers.
+    sys.stdout.write("done")
+    return {"status_code": 200, "body": "hello world!"}
@@ -0,0 +1,34 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+import json
+import time
+
+def handler(event: dict):
+    yield {"body": b"\xff\xd8frame-1", "content_type": "image/jpeg"}
+    yield {"body": "frame-2", "content_type": "text/plain", "headers": {"X-Frame": "2"}}
+
+def infinite_handler(event: dict):
+    n = 0
+    while True:
+        yield {"body": "frame-%d" % n, "content_type": "text/plain"}
+        n += 1
+        time.sleep(0.05)
+
+def plain_handler(event: dict) -> dict:
+    return {
+        "body": json.dumps({"message": "not a stream"}),
+        "status_code": 200,
+    }
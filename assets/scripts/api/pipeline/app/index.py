@@ -0,0 +1,34 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+import json
+
+def parse(event: dict) -> dict:
+    data = json.loads(event["body"] or "{}")
+    if not data.get("name"):
+        return {"status_code": 400, "body": "name is required"}
+    return {
+        "status_code": 200,
+        "headers": {"Content-Type": "application/json"},
+        "body": json.dumps({"name": data["name"].strip().title()}),
+    }
+
+def render(event: dict) -> dict:
+    data = json.loads(event["body"])
+    return {
+        "status_code": 200,
+        "headers": {"X-Pipeline-Input": event["response"]["headers"]["Content-Type"]},
+        "body": "Hello, " + data["name"] + "!",
+    }
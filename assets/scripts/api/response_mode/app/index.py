@@ -0,0 +1,69 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import time
+
+def large_handler(event: dict) -> dict:
+    # The body consists of 1KB lines.
+    size = int(event["query_params"].get("size", 1 << 20))
+    return {
+        "body": ("x" * 1023 + "\n") * (size // 1024),
+        "status_code": 200,
+        "headers": {"Content-Type": "text/plain", "X-Mode": "large"},
+    }
+
+def chunks_handler(event: dict) -> dict:
+    def chunks():
+        yield "first\n"
+        time.sleep(0.2)
+        yield b"second\n"
+    return {
+        "body": chunks(),
+        "status_code": 201,
+        "headers": {"Content-Type": "text/plain"},
+    }
+
+def echo_handler(event: dict) -> dict:
+    return {
+        "body": event["body"],
+        "status_code": 200,
+    }
+
+def no_content_handler(event: dict) -> dict:
+    return {
+        "body": "",
+        "status_code": 204,
+    }
+
+def invalid_status_handler(event: dict) -> dict:
+    return {
+        "body": "invalid",
+        "status_code": 99,
+    }
+
+def etag_handler(event: dict) -> dict:
+    return {
+        "body": "tagged",
+        "status_code": 200,
+        "etag": "v1",
+    }
+
+def failing_chunks_handler(event: dict) -> dict:
+    def chunks():
+        yield "partial\n"
+        raise ValueError("boom")
+    return {
+        "body": chunks(),
+        "status_code": 200,
+    }
@@ -0,0 +1,28 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import os
+import time
+
+def handler(event: dict) -> dict:
+    return {"body": "ok", "status_code": 200}
+
+def on_shutdown():
+    # The marker shows the function ran before the process exited.
+    path = os.path.join(os.environ["SHUTDOWN_MARKER_DIR"], str(os.getpid()))
+    with open(path, "w") as f:
+        f.write("flushed")
+
+def slow_shutdown():
+    time.sleep(10)
@@ -0,0 +1,45 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import json
+import time
+
+def handler(event: dict) -> dict:
+    return {
+        "body": json.dumps({"request_id": event["request_id"]}),
+        "status_code": 200,
+    }
+
+def aws_handler(event: dict, context) -> dict:
+    return {
+        "body": json.dumps({
+            "request_id": context.aws_request_id,
+            "function_name": context.function_name,
+            "has_time_remaining": context.get_remaining_time_in_millis() > 0,
+        }),
+        "status_code": 200,
+    }
+
+def deadline_handler(event: dict, context) -> dict:
+    before = context.time_remaining()
+    time.sleep(0.2)
+    after = context.time_remaining()
+    return {
+        "body": json.dumps({
+            "request_id": context.request_id,
+            "before": before,
+            "after": after,
+        }),
+        "status_code": 200,
+    }
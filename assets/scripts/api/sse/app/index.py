@@ -0,0 +1,38 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import json
+import time
+
+def handler(event: dict):
+    yield "hello"
+    yield {"event": "update", "id": "2", "data": {"n": 2}}
+    yield "line1\nline2"
+
+def infinite_handler(event: dict):
+    n = 0
+    while True:
+        yield {"id": str(n), "data": "tick"}
+        n += 1
+        time.sleep(0.05)
+
+def large_handler(event: dict):
+    for n in range(4):
+        yield {"id": str(n), "data": "x" * 1000}
+
+def plain_handler(event: dict) -> dict:
+    return {
+        "body": json.dumps({"message": "not a stream"}),
+        "status_code": 200,
+    }
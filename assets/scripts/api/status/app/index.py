@@ -0,0 +1,27 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+def not_found_handler(event: dict) -> dict:
+    return {
+        "body": "page not found: " + event["path"],
+        "status_code": 404,
+    }
+
+def status_handler(event: dict) -> dict:
+    status_code = int(event["query_params"]["code"])
+    return {
+        "body": "status %d" % status_code,
+        "status_code": status_code,
+        "headers": {"Link": "</style.css>; rel=preload; as=style"},
+    }
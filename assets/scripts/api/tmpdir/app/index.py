@@ -0,0 +1,32 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import json
+import os
+import tempfile
+
+def handler(event: dict) -> dict:
+    tmpdir = event.get("tmpdir")
+    with tempfile.NamedTemporaryFile(delete=False) as f:
+        f.write(b"scratch")
+    response = {
+        "body": json.dumps({
+            "tmpdir": tmpdir,
+            "exists": tmpdir is not None and os.path.isdir(tmpdir),
+            "env": os.environ.get("TMPDIR"),
+            "file_dir": os.path.dirname(f.name),
+        }),
+        "status_code": 200,
+    }
+    return response
@@ -0,0 +1,26 @@
+# Copyright 2024 Paul Greenberg @greenpau
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import time
+
+def handler(event: dict) -> dict:
+    # Mimic the worker which starts the output, but never ends it.
+    print("CMD_OUTPUT_START=%s;" % event["request_id"])
+    print("CMD_STATUS_CODE=200;")
+    print("CMD_OUTPUT_BODY=partial")
+    time.sleep(5)
+    return {
+        "body": "ok",
+        "status_code": 200,
+    }
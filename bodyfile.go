@@ -0,0 +1,85 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readBodyFile replaces the response body with the content of the file
+// returned by the handler via body_file. The body files are served only
+// when body_file_dir is set. The file must be a regular file within the
+// body_file_dir directory. The file is removed once read, even when reading
+// fails.
+func (fex *FunctionExecutor) readBodyFile(r *workerResponse) error {
+	fp, err := fex.getBodyFilePath(r.BodyFile)
+	if err != nil {
+		r.StatusCode = http.StatusInternalServerError
+		return err
+	}
+	defer os.Remove(fp)
+
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		r.StatusCode = http.StatusInternalServerError
+		return fmt.Errorf("%w: %v", errBodyFile, err)
+	}
+	r.Body = b
+	r.BodyFile = ""
+	return nil
+}
+
+// getBodyFilePath returns the absolute path to the body file, or an error
+// when the file is not a regular file within the body_file_dir directory.
+// The symlinks are resolved first, so that neither the file nor any of its
+// parent directories link outside of the directory.
+func (fex *FunctionExecutor) getBodyFilePath(s string) (string, error) {
+	if fex.BodyFileDir == "" {
+		return "", errBodyFileDisabled
+	}
+	dir, err := resolvePath(fex.BodyFileDir)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errBodyFile, err)
+	}
+	fp, err := resolvePath(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errBodyFile, err)
+	}
+	rel, err := filepath.Rel(dir, fp)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is not within %s", errBodyFile, s, dir)
+	}
+	fi, err := os.Lstat(fp)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errBodyFile, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("%w: %s is not a regular file", errBodyFile, s)
+	}
+	return fp, nil
+}
+
+// resolvePath returns the absolute path with the symlinks resolved.
+func resolvePath(s string) (string, error) {
+	fp, err := filepath.Abs(s)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(fp)
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFunctionExecutorBodyFile(t *testing.T) {
+	// The handler creates the body files in the temp directory.
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	// The symlinked directory within body_file_dir links outside of it.
+	linkedDir := t.TempDir()
+	bodyFileDir := t.TempDir()
+	if err := os.Symlink(linkedDir, filepath.Join(bodyFileDir, "linked")); err != nil {
+		t.Fatalf("unexpected Symlink() error: %v", err)
+	}
+
+	for i, tc := range []struct {
+		name       string
+		dir        string
+		function   string
+		uri        string
+		statusCode int
+	}{
+		{
+			name:       "test body file in body_file_dir is served",
+			dir:        tmpDir,
+			function:   "handler",
+			uri:        "/",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test body file is rejected without body_file_dir",
+			function:   "handler",
+			uri:        "/",
+			statusCode: http.StatusInternalServerError,
+		},
+		{
+			name:       "test body file outside of body_file_dir is rejected",
+			dir:        t.TempDir(),
+			function:   "handler",
+			uri:        "/",
+			statusCode: http.StatusInternalServerError,
+		},
+		{
+			name:       "test body file in symlinked directory is rejected",
+			dir:        bodyFileDir,
+			function:   "dir_handler",
+			uri:        "/?dir=" + url.QueryEscape(filepath.Join(bodyFileDir, "linked")),
+			statusCode: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name body_file
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/body_file/app/index.py
+				function ` + tc.function
+			if tc.dir != "" {
+				config += `
+				body_file_dir ` + tc.dir
+			}
+			config += `
+			}`
+			fex := newTestFunctionExecutor(t, config)
+			defer fex.Cleanup()
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", tc.uri)); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			if tc.statusCode != http.StatusOK {
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+
+			var m struct {
+				Items []map[string]interface{} `json:"items"`
+			}
+			if err := json.Unmarshal(resp.body, &m); err != nil {
+				t.Fatalf("unexpected body: %v", err)
+			}
+			if len(m.Items) != 100000 {
+				t.Fatalf("unexpected item count: got %d, want 100000", len(m.Items))
+			}
+			fp := resp.Header().Get("X-Body-File")
+			if _, err := os.Stat(fp); !os.IsNotExist(err) {
+				t.Fatalf("body file %q was not removed: %v", fp, err)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+
+	// The rejected files are left in place.
+	entries, err := os.ReadDir(linkedDir)
+	if err != nil {
+		t.Fatalf("unexpected ReadDir() error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("unexpected file count in symlinked directory: got %d, want 1", len(entries))
+	}
+}
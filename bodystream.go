@@ -0,0 +1,148 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// responseMode values control how the response of the handler is written.
+const (
+	// responseModeBuffer reads the whole response from the worker before
+	// writing it.
+	responseModeBuffer = "buffer"
+	// responseModeStream writes the body in chunks as they arrive from the
+	// worker, so that the first byte of a large body is sent sooner.
+	responseModeStream = "stream"
+)
+
+// bodyStreamHead is the status code and the headers of the response
+// written in the stream response mode, which precede the body chunks.
+type bodyStreamHead struct {
+	StatusCode int             `json:"status_code"`
+	Headers    json.RawMessage `json:"headers"`
+}
+
+// bodyWriter writes the body of an ordinary response to the client in
+// chunks as it arrives from the worker, when response_mode is stream. The
+// body is not known upfront, so the response has no Content-Length, ETag,
+// and ranges.
+type bodyWriter struct {
+	resp       http.ResponseWriter
+	started    bool
+	statusCode int
+	// discard is true when the body is not written, e.g. the response to
+	// a HEAD request.
+	discard bool
+	// writeHeader writes the status code and the headers of the response.
+	// It returns the status code written, and false when the body must not
+	// be written.
+	writeHeader func(statusCode int, headers http.Header) (int, bool)
+}
+
+func (bw *bodyWriter) mode() string         { return "body" }
+func (bw *bodyWriter) startMarker() string  { return "CMD_BODY_START=" }
+func (bw *bodyWriter) recordMarker() string { return "CMD_BODY_CHUNK=" }
+func (bw *bodyWriter) isStarted() bool      { return bw.started }
+
+// start writes the status code and the headers returned by the handler.
+func (bw *bodyWriter) start(s string) error {
+	head := &bodyStreamHead{}
+	if err := json.Unmarshal([]byte(s), head); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedStreamRecord, err)
+	}
+	headers, err := parseHeaders(string(head.Headers))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformedStreamRecord, err)
+	}
+	var ok bool
+	bw.statusCode, ok = bw.writeHeader(head.StatusCode, headers)
+	bw.discard = !ok
+	bw.started = true
+	return bw.flush()
+}
+
+// writeRecord writes the base64 encoded chunk of the body.
+func (bw *bodyWriter) writeRecord(s string) error {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformedStreamRecord, err)
+	}
+	if bw.discard {
+		return nil
+	}
+	if _, err := bw.resp.Write(b); err != nil {
+		return err
+	}
+	return bw.flush()
+}
+
+// writeKeepAlive does nothing, because the body has no room for comments.
+func (bw *bodyWriter) writeKeepAlive() error {
+	return nil
+}
+
+// end does nothing, because the body ends with the response.
+func (bw *bodyWriter) end() error {
+	return nil
+}
+
+func (bw *bodyWriter) flush() error {
+	return http.NewResponseController(bw.resp).Flush()
+}
+
+// newBodyWriter returns the writer of the body of the response to the
+// request in the stream response mode. The headers returned by the handler
+// are subject to the same rules as the ones of the buffered responses.
+func (fex *FunctionExecutor) newBodyWriter(resp http.ResponseWriter, req *http.Request, requestID string) *bodyWriter {
+	startedAt := time.Now()
+	return &bodyWriter{
+		resp: resp,
+		writeHeader: func(statusCode int, headers http.Header) (int, bool) {
+			if !fex.isStatusCodeAllowed(statusCode) {
+				fex.logger.Warn(
+					"failed executing lambda function",
+					zap.String("lambda_name", fex.Name),
+					zap.String("request_id", requestID),
+					zap.Error(fmt.Errorf("%w: %d", errInvalidStatusCode, statusCode)),
+				)
+				fex.writeSecurityHeaders(resp)
+				fex.writeError(resp, requestID, http.StatusBadGateway)
+				return http.StatusBadGateway, false
+			}
+			r := &workerResponse{StatusCode: statusCode, Headers: headers, Duration: time.Since(startedAt)}
+			fex.writeResponseHeaders(resp, requestID, headers)
+			fex.writeSecurityHeaders(resp)
+			fex.applyResponseTransforms(req, r, resp.Header())
+			resp.Header().Del("Content-Length")
+			if isInformationalStatus(statusCode) {
+				// The interim response is followed by the final response.
+				resp.WriteHeader(statusCode)
+				statusCode = http.StatusOK
+			}
+			resp.WriteHeader(statusCode)
+			if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+				return statusCode, false
+			}
+			return statusCode, req.Method != http.MethodHead
+		},
+	}
+}
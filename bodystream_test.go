@@ -0,0 +1,215 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFunctionExecutorResponseModeStream(t *testing.T) {
+	for i, tc := range []struct {
+		name          string
+		function      string
+		method        string
+		body          string
+		statusCode    int
+		want          string
+		contentLength string
+		header        string
+	}{
+		{
+			name:       "test large body is streamed",
+			function:   "large_handler",
+			statusCode: http.StatusOK,
+			want:       strings.Repeat(strings.Repeat("x", 1023)+"\n", 1<<10),
+			header:     "large",
+		},
+		{
+			name:       "test body iterator is streamed",
+			function:   "chunks_handler",
+			statusCode: http.StatusCreated,
+			want:       "first\nsecond\n",
+		},
+		{
+			name:       "test request body is passed",
+			function:   "echo_handler",
+			method:     "POST",
+			body:       "hello",
+			statusCode: http.StatusOK,
+			want:       "hello",
+		},
+		{
+			name:       "test head request has no body",
+			function:   "large_handler",
+			method:     "HEAD",
+			statusCode: http.StatusOK,
+			header:     "large",
+		},
+		{
+			name:       "test no content response",
+			function:   "no_content_handler",
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:          "test invalid status code",
+			function:      "invalid_status_handler",
+			statusCode:    http.StatusBadGateway,
+			want:          "Bad Gateway",
+			contentLength: "11",
+		},
+		{
+			name:          "test response with etag is buffered",
+			function:      "etag_handler",
+			statusCode:    http.StatusOK,
+			want:          "tagged",
+			contentLength: "6",
+		},
+		{
+			name:       "test failing iterator truncates body",
+			function:   "failing_chunks_handler",
+			statusCode: http.StatusOK,
+			want:       "partial\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, srv := newTestServer(t, `
+			lambda {
+				name response_mode
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/response_mode/app/index.py
+				function `+tc.function+`
+				body_type str
+				response_mode stream
+			}`)
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req, err := http.NewRequest(method, srv.URL, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("error creating request: %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected request error: %v", err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("unexpected read error: %v", err)
+			}
+			if resp.StatusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.StatusCode, tc.statusCode)
+			}
+			if string(b) != tc.want {
+				t.Fatalf("unexpected body: got %d bytes %.64q, want %d bytes %.64q", len(b), b, len(tc.want), tc.want)
+			}
+			if got := resp.Header.Get("Content-Length"); got != tc.contentLength {
+				t.Fatalf("unexpected Content-Length header: got %q, want %q", got, tc.contentLength)
+			}
+			if got := resp.Header.Get("X-Mode"); got != tc.header {
+				t.Fatalf("unexpected X-Mode header: got %q, want %q", got, tc.header)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorResponseModeStreamFirstChunk(t *testing.T) {
+	_, srv := newTestServer(t, `
+	lambda {
+		name response_mode
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/response_mode/app/index.py
+		function chunks_handler
+		body_type str
+		response_mode stream
+	}`)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	defer resp.Body.Close()
+	// The first chunk is received before the handler yields the second one.
+	b := make([]byte, len("first\n"))
+	start := time.Now()
+	if _, err := io.ReadFull(resp.Body, b); err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if string(b) != "first\n" {
+		t.Fatalf("unexpected first chunk: %q", b)
+	}
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if string(rest) != "second\n" {
+		t.Fatalf("unexpected second chunk: %q", rest)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("first chunk was not written before the second one, elapsed %v", elapsed)
+	}
+}
+
+func BenchmarkResponseModeFirstByte(b *testing.B) {
+	const size = 16 << 20
+	for _, mode := range []string{responseModeBuffer, responseModeStream} {
+		b.Run(mode, func(b *testing.B) {
+			_, srv := newTestServer(b, `
+			lambda {
+				name response_mode
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/response_mode/app/index.py
+				function large_handler
+				body_type str
+				response_mode `+mode+`
+			}`)
+			url := srv.URL + "/?size=" + strconv.Itoa(size)
+
+			var firstByte time.Duration
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var start time.Time
+				trace := &httptrace.ClientTrace{
+					GotFirstResponseByte: func() { firstByte += time.Since(start) },
+				}
+				req, _ := http.NewRequest("GET", url, nil)
+				req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+				start = time.Now()
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					b.Fatalf("unexpected request error: %v", err)
+				}
+				n, _ := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if n != size {
+					b.Fatalf("unexpected body size: %d", n)
+				}
+			}
+			b.ReportMetric(float64(firstByte.Microseconds())/float64(b.N), "us/first-byte")
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// The defaults of the circuit breaker.
+const (
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 30 * time.Second
+)
+
+// CircuitBreaker holds the config of the circuit breaker of the function.
+// After the number of consecutive failures reaches the threshold, the
+// circuit opens and the requests fail fast with the status code for the
+// open duration. Then, a single probe request is let through. The circuit
+// closes when the probe succeeds, and opens again when it fails.
+type CircuitBreaker struct {
+	FailureThreshold uint           `json:"failure_threshold,omitempty"`
+	OpenDuration     caddy.Duration `json:"open_duration,omitempty"`
+	StatusCode       int            `json:"status_code,omitempty"`
+}
+
+func (cfg *CircuitBreaker) setDefaults() {
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = caddy.Duration(defaultOpenDuration)
+	}
+	if cfg.StatusCode == 0 {
+		cfg.StatusCode = http.StatusServiceUnavailable
+	}
+}
+
+// breakerState is the state of the circuit breaker.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker tracks the failures of the function invocations.
+type circuitBreaker struct {
+	mu           sync.Mutex
+	name         string
+	threshold    uint
+	openDuration time.Duration
+	state        breakerState
+	failures     uint
+	openedAt     time.Time
+	// probing is true when the probe request of the half-open circuit is
+	// in flight.
+	probing bool
+	now     func() time.Time
+}
+
+func newCircuitBreaker(name string, cfg *CircuitBreaker) *circuitBreaker {
+	cb := &circuitBreaker{
+		name:         name,
+		threshold:    cfg.FailureThreshold,
+		openDuration: time.Duration(cfg.OpenDuration),
+		now:          time.Now,
+	}
+	observeBreakerState(name, breakerClosed)
+	return cb
+}
+
+// allow returns true when the request may be invoked. When the circuit is
+// open, it returns the time until the probe request is let through.
+func (cb *circuitBreaker) allow() (bool, time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if wait := cb.openDuration - cb.now().Sub(cb.openedAt); wait > 0 {
+			return false, wait
+		}
+		cb.setState(breakerHalfOpen)
+		cb.probing = true
+		return true, 0
+	case breakerHalfOpen:
+		if cb.probing {
+			return false, 0
+		}
+		cb.probing = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// record updates the state of the circuit with the outcome of the allowed
+// request. The errors unrelated to the health of the function, e.g. the
+// full queue, do not change the state.
+func (cb *circuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	failed := isCircuitFailure(err)
+	if cb.state == breakerHalfOpen {
+		cb.probing = false
+		switch {
+		case failed:
+			cb.open()
+		case err == nil:
+			cb.failures = 0
+			cb.setState(breakerClosed)
+		}
+		return
+	}
+	if !failed {
+		if err == nil {
+			cb.failures = 0
+		}
+		return
+	}
+	cb.failures++
+	if cb.state == breakerClosed && cb.failures >= cb.threshold {
+		cb.open()
+	}
+}
+
+func (cb *circuitBreaker) open() {
+	cb.openedAt = cb.now()
+	cb.setState(breakerOpen)
+}
+
+func (cb *circuitBreaker) setState(state breakerState) {
+	cb.state = state
+	observeBreakerState(cb.name, state)
+}
+
+// getState returns the state of the circuit.
+func (cb *circuitBreaker) getState() breakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// isCircuitFailure returns true when the error counts as a failure of the
+// function, i.e. the handler failed, timed out, or crashed the worker.
+func isCircuitFailure(err error) bool {
+	return errors.Is(err, errHandlerFailed) || errors.Is(err, errWorkerTimeout) || errors.Is(err, errWorkerExited) ||
+		errors.Is(err, errWorkerTruncated) || errors.Is(err, errWorkerBrokenPipe)
+}
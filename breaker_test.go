@@ -0,0 +1,157 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker("test_breaker", &CircuitBreaker{
+		FailureThreshold: 2,
+		OpenDuration:     caddy.Duration(30 * time.Second),
+	})
+	cb.now = func() time.Time { return now }
+
+	for i, tc := range []struct {
+		name    string
+		advance time.Duration
+		err     error
+		allowed bool
+		state   breakerState
+	}{
+		{name: "test failure below threshold keeps circuit closed", err: errHandlerFailed, allowed: true, state: breakerClosed},
+		{name: "test success resets failures", allowed: true, state: breakerClosed},
+		{name: "test failure after reset keeps circuit closed", err: errWorkerTimeout, allowed: true, state: breakerClosed},
+		{name: "test unrelated error is not counted", err: errQueueFull, allowed: true, state: breakerClosed},
+		{name: "test failure at threshold opens circuit", err: errWorkerExited, allowed: true, state: breakerOpen},
+		{name: "test open circuit rejects request", advance: 10 * time.Second, allowed: false, state: breakerOpen},
+		{name: "test failed probe opens circuit again", advance: 20 * time.Second, err: errHandlerFailed, allowed: true, state: breakerOpen},
+		{name: "test reopened circuit rejects request", advance: 29 * time.Second, allowed: false, state: breakerOpen},
+		{name: "test successful probe closes circuit", advance: time.Second, allowed: true, state: breakerClosed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			now = now.Add(tc.advance)
+			allowed, _ := cb.allow()
+			if allowed != tc.allowed {
+				t.Fatalf("unexpected allow(): got %t, want %t", allowed, tc.allowed)
+			}
+			if allowed {
+				cb.record(tc.err)
+			}
+			if state := cb.getState(); state != tc.state {
+				t.Fatalf("unexpected state: got %s, want %s", state, tc.state)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker("test_breaker", &CircuitBreaker{
+		FailureThreshold: 1,
+		OpenDuration:     caddy.Duration(30 * time.Second),
+	})
+	cb.now = func() time.Time { return now }
+
+	cb.allow()
+	cb.record(errHandlerFailed)
+	if allowed, wait := cb.allow(); allowed || wait != 30*time.Second {
+		t.Fatalf("unexpected allow() of open circuit: %t %s", allowed, wait)
+	}
+
+	now = now.Add(30 * time.Second)
+	if allowed, _ := cb.allow(); !allowed {
+		t.Fatalf("unexpected probe request rejected")
+	}
+	if state := cb.getState(); state != breakerHalfOpen {
+		t.Fatalf("unexpected state: got %s, want %s", state, breakerHalfOpen)
+	}
+	// Only the probe request is let through while it is in flight.
+	if allowed, _ := cb.allow(); allowed {
+		t.Fatalf("unexpected request allowed while probing")
+	}
+	// The probe rejected for capacity does not change the state.
+	cb.record(errQueueFull)
+	if allowed, _ := cb.allow(); !allowed {
+		t.Fatalf("unexpected probe request rejected")
+	}
+	cb.record(nil)
+	if state := cb.getState(); state != breakerClosed {
+		t.Fatalf("unexpected state: got %s, want %s", state, breakerClosed)
+	}
+}
+
+func TestFunctionExecutorCircuitBreaker(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name breaker
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/breaker/app/index.py
+		function handler
+		circuit_breaker {
+			failure_threshold 2
+			open_duration 300ms
+			status_code 503
+		}
+	}`)
+	defer fex.Cleanup()
+
+	for i, tc := range []struct {
+		name       string
+		uri        string
+		wait       time.Duration
+		statusCode int
+		retryAfter string
+		state      breakerState
+	}{
+		{name: "test handler failure", uri: "/?fail=1", statusCode: http.StatusInternalServerError, state: breakerClosed},
+		{name: "test handler failure opens circuit", uri: "/?fail=1", statusCode: http.StatusInternalServerError, state: breakerOpen},
+		{name: "test open circuit fails fast", uri: "/", statusCode: http.StatusServiceUnavailable, retryAfter: "1", state: breakerOpen},
+		{name: "test probe closes circuit", uri: "/", wait: 300 * time.Millisecond, statusCode: http.StatusOK, state: breakerClosed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			time.Sleep(tc.wait)
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", tc.uri)); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			if got := resp.Header().Get("Retry-After"); got != tc.retryAfter {
+				t.Fatalf("unexpected Retry-After: got %q, want %q", got, tc.retryAfter)
+			}
+			if state := fex.breaker.getState(); state != tc.state {
+				t.Fatalf("unexpected state: got %s, want %s", state, tc.state)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+
+	stats := registry.getStats()
+	for _, entry := range stats.Executors {
+		if entry.Name == "breaker" && entry.CircuitBreaker != breakerClosed.String() {
+			t.Fatalf("unexpected circuit breaker stats: %q", entry.CircuitBreaker)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// checkCallStyleScript imports the module and exits with an error when the
+// handler has required parameters which are not among the keyword
+// arguments passed to it. The handlers whose signature cannot be inspected
+// are not checked.
+const checkCallStyleScript = `import importlib, inspect, json, sys
+m = importlib.import_module(sys.argv[1])
+fn = getattr(m, sys.argv[2], None)
+if not callable(fn):
+    sys.stderr.write("handler %s not found in %s" % (sys.argv[2], sys.argv[1]))
+    sys.exit(1)
+try:
+    params = inspect.signature(fn).parameters.values()
+except (TypeError, ValueError):
+    sys.exit(0)
+fields = set(json.loads(sys.argv[3]))
+for p in params:
+    if p.kind == p.POSITIONAL_ONLY:
+        sys.stderr.write("handler %s parameter %s is positional-only" % (sys.argv[2], p.name))
+        sys.exit(1)
+    if p.kind in (p.POSITIONAL_OR_KEYWORD, p.KEYWORD_ONLY) and p.default is p.empty and p.name not in fields:
+        sys.stderr.write("handler %s parameter %s is not a request field" % (sys.argv[2], p.name))
+        sys.exit(1)`
+
+// requestFieldKeys maps the request fields, as in the include directive, to
+// the keys of the request data they add.
+var requestFieldKeys = map[string][]string{
+	"method":           {"method"},
+	"path":             {"path"},
+	"proto":            {"proto", "proto_major", "proto_minor", "http2", "http3"},
+	"host":             {"host"},
+	"server_name":      {"server_name"},
+	"client_cert":      {"client_cert"},
+	"request_uri":      {"request_uri"},
+	"remote_addr_port": {"remote_addr_port"},
+	"remote_ip":        {"remote_ip"},
+	"remote_port":      {"remote_port"},
+	"cookies":          {"cookies"},
+	"query_params":     {"query_params"},
+	"query_string":     {"query_string"},
+	"headers":          {"headers"},
+	"body":             {"body", "is_base64_encoded"},
+}
+
+// getCallFields returns the names of the keyword arguments passed to the
+// handlers with the unpacked call style, in the configured field style.
+func (fex *FunctionExecutor) getCallFields(extra ...string) []string {
+	data := map[string]interface{}{"request_id": nil}
+	for field, keys := range requestFieldKeys {
+		if !fex.isFieldIncluded(field) {
+			continue
+		}
+		for _, k := range keys {
+			data[k] = nil
+		}
+	}
+	if len(fex.Secrets) > 0 {
+		data["secrets"] = nil
+	}
+	if len(fex.Vars) > 0 {
+		data["vars"] = nil
+	}
+	for _, k := range extra {
+		data[k] = nil
+	}
+	var fields []string
+	for k := range fex.formatRequestData(data) {
+		fields = append(fields, k)
+	}
+	if fex.HandlerSignature == handlerSignatureEventContext {
+		fields = append(fields, "context")
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// checkCallStyle validates that the handlers can be called with the
+// request fields as keyword arguments, when call_style is unpacked.
+func (fex *FunctionExecutor) checkCallStyle() error {
+	if fex.CallStyle != callStyleUnpacked {
+		return nil
+	}
+	fields := fex.getCallFields()
+	if err := fex.checkHandlerParams(fex.entrypointImport, fex.EntrypointHandler, fields); err != nil {
+		return err
+	}
+	if fex.FallbackEntrypointHandler != "" {
+		if err := fex.checkHandlerParams(getEntrypointImport(fex.FallbackEntrypointPath), fex.FallbackEntrypointHandler, fields); err != nil {
+			return err
+		}
+	}
+	if fex.AfterEntrypointHandler != "" {
+		if err := fex.checkHandlerParams(fex.entrypointImport, fex.AfterEntrypointHandler, fex.getCallFields("response")); err != nil {
+			return err
+		}
+	}
+	for _, step := range fex.Pipeline {
+		// The steps receive the body of the previous response, even if the
+		// request body is not included.
+		if err := fex.checkHandlerParams(getEntrypointImport(step.EntrypointPath), step.EntrypointHandler, fex.getCallFields("body", "is_base64_encoded", "response")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHandlerParams imports the module with the python executable and
+// returns an error when the parameters of the handler are not satisfied by
+// the fields.
+func (fex *FunctionExecutor) checkHandlerParams(importPath, handlerName string, fields []string) error {
+	b, _ := json.Marshal(fields)
+	ctx, cancel := context.WithTimeout(context.Background(), checkHandlerTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, fex.PythonExecutable, "-c", checkCallStyleScript, importPath, handlerName, string(b))
+	cmd.Env = fex.getWorkerEnv()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		return fmt.Errorf("failed to check handler %s from %s: %s", handlerName, importPath, lines[len(lines)-1])
+	}
+	return nil
+}
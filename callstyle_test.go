@@ -0,0 +1,139 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFunctionExecutorCallStyle(t *testing.T) {
+	for i, tc := range []struct {
+		name   string
+		config string
+		uri    string
+		want   string
+	}{
+		{
+			name:   "test dict call style",
+			config: "call_style dict\n function handler",
+			uri:    "/foo",
+			want:   `{"method": "GET", "path": "/foo"}`,
+		},
+		{
+			name:   "test unpacked call style",
+			config: "call_style unpacked\n function unpacked_handler",
+			uri:    "/foo?bar=baz",
+			want:   `{"method": "GET", "path": "/foo", "body": "", "query_params": {"bar": "baz"}}`,
+		},
+		{
+			name:   "test unpacked call style with kwargs",
+			config: "call_style unpacked\n function kwargs_handler",
+			uri:    "/foo",
+			want:   `{"method": "GET", "request_id": true}`,
+		},
+		{
+			name:   "test unpacked call style with context",
+			config: "call_style unpacked\n handler_signature event_context\n function context_handler",
+			uri:    "/foo",
+			want:   `{"path": "/foo", "function_name": "call_style"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name call_style
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/call_style/app/index.py
+				`+tc.config+`
+			}`)
+			defer fex.Cleanup()
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", tc.uri)); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != http.StatusOK {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusOK)
+			}
+			if diff := cmp.Diff(tc.want, string(resp.body)); diff != "" {
+				t.Fatalf("unexpected body mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorCallStyleUnknownField(t *testing.T) {
+	fex := &FunctionExecutor{}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`
+	lambda {
+		name call_style
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/call_style/app/index.py
+		function unknown_field_handler
+		call_style unpacked
+	}`)); err != nil {
+		t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+	}
+	err := fex.Provision(caddy.Context{Context: context.Background()})
+	fex.Cleanup()
+	if err == nil {
+		t.Fatalf("expected Provision() error, got none")
+	}
+	if !strings.Contains(err.Error(), "handler unknown_field_handler parameter user is not a request field") {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+}
+
+func TestGetCallFields(t *testing.T) {
+	for i, tc := range []struct {
+		name string
+		fex  FunctionExecutor
+		want []string
+	}{
+		{
+			name: "test included fields",
+			fex:  FunctionExecutor{IncludeFields: []string{"method", "body"}},
+			want: []string{"body", "is_base64_encoded", "method", "request_id"},
+		},
+		{
+			name: "test aws field style with context",
+			fex: FunctionExecutor{
+				IncludeFields:    []string{"method", "query_params"},
+				FieldStyle:       "aws",
+				HandlerSignature: handlerSignatureEventContext,
+			},
+			want: []string{"context", "httpMethod", "queryStringParameters", "requestContext", "requestId"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, tc.fex.getCallFields()); diff != "" {
+				t.Fatalf("unexpected fields mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
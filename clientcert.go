@@ -0,0 +1,40 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// getClientCert returns the details of the TLS client certificate of the
+// request, or nil when the client did not present one. The verified key is
+// true when the certificate was verified against the client CAs, e.g. with
+// the client_auth mode of the Caddy TLS connection policy.
+func getClientCert(req *http.Request) map[string]interface{} {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := req.TLS.PeerCertificates[0]
+	fingerprint := sha256.Sum256(cert.Raw)
+	return map[string]interface{}{
+		"subject":     cert.Subject.String(),
+		"issuer":      cert.Issuer.String(),
+		"serial":      cert.SerialNumber.Text(16),
+		"fingerprint": hex.EncodeToString(fingerprint[:]),
+		"verified":    len(req.TLS.VerifiedChains) > 0,
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestClientCert(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1f2e3d),
+		Subject:      pkix.Name{CommonName: "client.example.com", Organization: []string{"Acme"}},
+		Issuer:       pkix.Name{CommonName: "client.example.com", Organization: []string{"Acme"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return cert
+}
+
+func TestBuildRequestDataClientCert(t *testing.T) {
+	cert := newTestClientCert(t)
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	for i, tc := range []struct {
+		name string
+		tls  *tls.ConnectionState
+		want map[string]interface{}
+	}{
+		{
+			name: "test plain http request",
+			want: map[string]interface{}{},
+		},
+		{
+			name: "test tls request without client certificate",
+			tls:  &tls.ConnectionState{},
+			want: map[string]interface{}{},
+		},
+		{
+			name: "test tls request with verified client certificate",
+			tls: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			},
+			want: map[string]interface{}{
+				"client_cert": map[string]interface{}{
+					"subject":     "CN=client.example.com,O=Acme",
+					"issuer":      "CN=client.example.com,O=Acme",
+					"serial":      "1f2e3d",
+					"fingerprint": hex.EncodeToString(fingerprint[:]),
+					"verified":    true,
+				},
+			},
+		},
+		{
+			name: "test tls request with unverified client certificate",
+			tls: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+			},
+			want: map[string]interface{}{
+				"client_cert": map[string]interface{}{
+					"subject":     "CN=client.example.com,O=Acme",
+					"issuer":      "CN=client.example.com,O=Acme",
+					"serial":      "1f2e3d",
+					"fingerprint": hex.EncodeToString(fingerprint[:]),
+					"verified":    false,
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{IncludeFields: []string{"client_cert"}}
+			req := newRequest(t, "GET", "/")
+			req.TLS = tc.tls
+			data := fex.buildRequestData(req, "test-request-id")
+			delete(data, "request_id")
+			if diff := cmp.Diff(tc.want, data); diff != "" {
+				t.Fatalf("unexpected data mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// computeETag returns a strong ETag derived from the response body.
+func computeETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setETag sets the ETag header computed from the response body, when the
+// etag directive is enabled and the handler did not return an ETag.
+func (fex *FunctionExecutor) setETag(resp http.ResponseWriter, r *workerResponse) {
+	if !fex.ETag || resp.Header().Get("ETag") != "" {
+		return
+	}
+	resp.Header().Set("ETag", computeETag(r.Body))
+}
+
+// isNotModified returns true when the conditional request matches the
+// ETag or the Last-Modified header of the response, i.e. the client has
+// the current representation. If-None-Match takes precedence over
+// If-Modified-Since.
+func isNotModified(req *http.Request, header http.Header) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, s := range strings.Split(inm, ",") {
+			s = strings.TrimSpace(s)
+			if s == "*" || trimWeakETag(s) == trimWeakETag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+	ims := req.Header.Get("If-Modified-Since")
+	lm := header.Get("Last-Modified")
+	if ims == "" || lm == "" {
+		return false
+	}
+	imsTime, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	lmTime, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+	return !lmTime.Truncate(time.Second).After(imsTime)
+}
+
+// trimWeakETag removes the weak validator prefix for weak comparison.
+func trimWeakETag(s string) string {
+	return strings.TrimPrefix(s, "W/")
+}
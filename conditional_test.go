@@ -0,0 +1,160 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsNotModified(t *testing.T) {
+	header := http.Header{
+		"Etag":          []string{`"v1"`},
+		"Last-Modified": []string{"Mon, 02 Jan 2006 15:04:05 GMT"},
+	}
+	for i, tc := range []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{
+			name:    "test matching if-none-match",
+			headers: map[string]string{"If-None-Match": `"v0", "v1"`},
+			want:    true,
+		},
+		{
+			name:    "test matching weak if-none-match",
+			headers: map[string]string{"If-None-Match": `W/"v1"`},
+			want:    true,
+		},
+		{
+			name:    "test wildcard if-none-match",
+			headers: map[string]string{"If-None-Match": "*"},
+			want:    true,
+		},
+		{
+			name:    "test non-matching if-none-match",
+			headers: map[string]string{"If-None-Match": `"v2"`},
+		},
+		{
+			name: "test if-none-match takes precedence over if-modified-since",
+			headers: map[string]string{
+				"If-None-Match":     `"v2"`,
+				"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT",
+			},
+		},
+		{
+			name:    "test if-modified-since not modified",
+			headers: map[string]string{"If-Modified-Since": "Tue, 03 Jan 2006 15:04:05 GMT"},
+			want:    true,
+		},
+		{
+			name:    "test if-modified-since modified",
+			headers: map[string]string{"If-Modified-Since": "Sun, 01 Jan 2006 15:04:05 GMT"},
+		},
+		{
+			name:    "test conditional post request",
+			method:  "POST",
+			headers: map[string]string{"If-None-Match": `"v1"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := newRequest(t, method, "/")
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			if got := isNotModified(req, header); got != tc.want {
+				t.Fatalf("unexpected isNotModified(): got %t, want %t", got, tc.want)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorConditionalRequest(t *testing.T) {
+	for i, tc := range []struct {
+		name        string
+		function    string
+		etag        bool
+		ifNoneMatch string
+		statusCode  int
+	}{
+		{
+			name:        "test handler etag matches",
+			function:    "handler",
+			ifNoneMatch: `"v1"`,
+			statusCode:  http.StatusNotModified,
+		},
+		{
+			name:        "test handler etag does not match",
+			function:    "handler",
+			ifNoneMatch: `"v0"`,
+			statusCode:  http.StatusOK,
+		},
+		{
+			name:        "test computed etag matches",
+			function:    "plain_handler",
+			etag:        true,
+			ifNoneMatch: computeETag([]byte("ok")),
+			statusCode:  http.StatusNotModified,
+		},
+		{
+			name:        "test etag is not computed by default",
+			function:    "plain_handler",
+			ifNoneMatch: computeETag([]byte("ok")),
+			statusCode:  http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name conditional
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/etag/app/index.py
+				function ` + tc.function
+			if tc.etag {
+				config += `
+				etag`
+			}
+			config += `
+			}`
+			fex := newTestFunctionExecutor(t, config)
+			defer fex.Cleanup()
+
+			req := newRequest(t, "GET", "/")
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, req); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			if tc.statusCode == http.StatusNotModified && resp.body != nil {
+				t.Fatalf("unexpected body in not modified response: %s", resp.body)
+			}
+			if tc.statusCode == http.StatusOK && resp.body == nil {
+				t.Fatalf("unexpected empty body")
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
@@ -15,11 +15,15 @@
 package lambda
 
 import (
+	"mime"
 	"strconv"
+	"strings"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
 	"go.uber.org/zap"
 )
 
@@ -27,6 +31,14 @@ const (
 	pluginName = "lambda"
 )
 
+var supportedRuntimes = []string{"python"}
+
+var supportedRequestFields = []string{
+	"method", "path", "proto", "host", "server_name", "client_cert", "request_uri",
+	"remote_addr_port", "remote_ip", "remote_port", "cookies", "headers", "query_params",
+	"query_string", "body",
+}
+
 func init() {
 	httpcaddyfile.RegisterHandlerDirective(pluginName, parseCaddyfile)
 }
@@ -39,38 +51,152 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 	return fex, err
 }
 
-func ensureArgsCount(d *caddyfile.Dispenser, args []string, count int) error {
-	if len(args) != count {
-		return d.Errf("too many args %q, expected %d", args, count)
+// ensureArgsCount returns an error when the directive does not have exactly
+// count arguments. The error names the directive and the first unexpected
+// argument, if any.
+func ensureArgsCount(d *caddyfile.Dispenser, name string, args []string, count int) error {
+	switch {
+	case len(args) > count:
+		return d.Errf("too many arguments for %s: expected %d, got %d, unexpected %q", name, count, len(args), args[count])
+	case len(args) < count:
+		return d.Errf("too few arguments for %s: expected %d, got %d", name, count, len(args))
 	}
 	return nil
 }
 
-func ensureArgUint(d *caddyfile.Dispenser, name, arg string) (uint, error) {
-	n, err := strconv.Atoi(arg)
-    if err != nil {
-		return 0, d.Errf("failed to convert %s %s: %v", name, arg, err)
-    }
-	ns := strconv.Itoa(n)
-	if ns != arg {
-		return 0, d.Errf("failed to convert %s %s, resolved %s", name, arg, ns)
+// ensureArgsMin returns an error when the directive has less than min
+// arguments.
+func ensureArgsMin(d *caddyfile.Dispenser, name string, args []string, min int) error {
+	if len(args) < min {
+		return d.Errf("too few arguments for %s: expected at least %d, got %d", name, min, len(args))
 	}
-	if n < 0 {
-		return 0, d.Errf("%s %s must be greater or equal to zero", name, arg)
+	return nil
+}
+
+func ensureRuntime(d *caddyfile.Dispenser, runtime string) error {
+	for _, s := range supportedRuntimes {
+		if s == runtime {
+			return nil
+		}
 	}
+	return d.Errf("unsupported lambda runtime %q, supported runtimes: %s", runtime, strings.Join(supportedRuntimes, ", "))
+}
 
+func ensureRequestField(d *caddyfile.Dispenser, field string) error {
+	for _, s := range supportedRequestFields {
+		if s == field {
+			return nil
+		}
+	}
+	return d.Errf("unsupported include field %q, supported fields: %s", field, strings.Join(supportedRequestFields, ", "))
+}
+
+// ensureArgUint returns the argument of the directive as a non-negative
+// integer.
+func ensureArgUint(d *caddyfile.Dispenser, name, arg string) (uint, error) {
+	n, err := strconv.ParseUint(arg, 10, 0)
+	if err != nil {
+		return 0, d.Errf("invalid %s argument %q: expected a non-negative integer", name, arg)
+	}
 	return uint(n), nil
 }
 
 // UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
 //
 //	lambda [<matcher>] {
+//      config_file <path>
 //      name <name>
 //      runtime <name>
+//      python_executable <path> [<path> ...]
+//      python_path <path> [<path> ...]
+//      io_encoding <encoding>
 //      entrypoint <path>
 //      function <name>
+//      handler_signature <single|event_context>
+//      call_style <dict|unpacked>
+//      fallback_entrypoint <path>
+//      fallback_function <name>
+//      after_function <name>
+//      health_function <name>
+//      health_timeout <duration>
+//      shutdown_function <name>
+//      shutdown_timeout <duration>
+//      validate_on_start
+//      selftest
+//      websocket
+//      websocket_cross_origin
+//      sse
+//      multipart_stream
+//      response_rate_limit <size>
+//      response_mode <buffer|stream>
+//      field_style <snake|aws>
+//      escape_html
+//      status_key <key>
+//      body_key <key>
+//      headers_key <key>
+//      auto_json
+//      require_output
+//      pass_through
+//      capture_stdout
+//      passthrough_status
+//      partial_on_timeout
+//      response_header_allowlist <name> [<name> ...]
+//      response_header_denylist <name> [<name> ...]
+//      security_headers {
+//        content_type_options <value|off>
+//        frame_options <value|off>
+//        content_security_policy <value|off>
+//      }
+//      circuit_breaker {
+//        failure_threshold <count>
+//        open_duration <duration>
+//        status_code <code>
+//      }
+//      rate_limit <requests> <window>
+//      crash_loop <restarts> <window>
+//      match {
+//        method <method> [<method> ...]
+//        header <name> [<value>]
+//      }
+//      pipeline {
+//        step [<path>] <function>
+//      }
+//      secrets <key> [<key> ...]
+//      vars <placeholder> [<placeholder> ...]
+//      secrets_ttl <duration>
+//      etag
+//      body_file_dir <path>
+//      redirect_signing_key <key>
+//      body_transport <json|fd>
+//      max_body_size <size>
+//      body_type <bytes|str|auto>
+//      accept_content_types <type> [<type> ...]
+//      decompress_request
+//      isolation <shared|per_request>
+//      sticky_header <name>
+//      fairness_key <header>
+//      max_total_workers <count>
+//      max_retries <count>
+//      force_retry
+//      dispatch_timeout <duration>
+//      max_queue <count>
+//      import_timeout <duration>
+//      write_timeout <duration>
+//      max_worker_age <duration>
+//      max_total_duration <duration>
+//      process_priority <nice>
+//      log_sample <rate>
+//      max_concurrency <count>
+//      queue_timeout <duration>
+//      pass_cookie_header
+//      error_format <json|text>
+//      request_id_format <uuid|hex|ulid>
+//      include <field> [<field> ...]
+//      request_transform <name> [<arg> ...]
+//      response_transform <name> [<arg> ...]
 //	}
 func (fex *FunctionExecutor) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	var fileConfig *FunctionExecutor
 	for d.Next() {
 		args := d.RemainingArgs()
 		if len(args) > 0 {
@@ -79,44 +205,570 @@ func (fex *FunctionExecutor) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 
 		for d.NextBlock(0) {
 			switch d.Val() {
+			case "config_file":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "config_file", args, 1)
+				if err != nil {
+					return err
+				}
+				fileConfig, err = loadConfigFile(args[0])
+				if err != nil {
+					return d.Errf("failed loading config_file %s: %v", args[0], err)
+				}
 			case "name":
 				args = d.RemainingArgs()
-				err := ensureArgsCount(d, args, 1)
+				err := ensureArgsCount(d, "name", args, 1)
 				if err != nil {
 					return err
 				}				
 				fex.Name = args[0]
 			case "runtime":
 				args = d.RemainingArgs()
-				err := ensureArgsCount(d, args, 1)
+				err := ensureArgsCount(d, "runtime", args, 1)
 				if err != nil {
 					return err
 				}				
+				if err := ensureRuntime(d, args[0]); err != nil {
+					return err
+				}
 				fex.Runtime = args[0]
 			case "python_executable":
 				args = d.RemainingArgs()
-				err := ensureArgsCount(d, args, 1)
+				switch len(args) {
+				case 0:
+					return ensureArgsMin(d, "python_executable", args, 1)
+				case 1:
+					fex.PythonExecutable = args[0]
+				default:
+					fex.PythonExecutableCandidates = args
+				}
+			case "python_path":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "python_path", args, 1); err != nil {
+					return err
+				}
+				fex.PythonPath = append(fex.PythonPath, args...)
+			case "io_encoding":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "io_encoding", args, 1)
 				if err != nil {
 					return err
-				}				
-				fex.PythonExecutable = args[0]
+				}
+				fex.IOEncoding = args[0]
 			case "entrypoint":
 				args = d.RemainingArgs()
-				err := ensureArgsCount(d, args, 1)
+				err := ensureArgsCount(d, "entrypoint", args, 1)
 				if err != nil {
 					return err
 				}				
 				fex.EntrypointPath = args[0]
 			case "function":
 				args = d.RemainingArgs()
-				err := ensureArgsCount(d, args, 1)
+				err := ensureArgsCount(d, "function", args, 1)
 				if err != nil {
 					return err
 				}				
 				fex.EntrypointHandler = args[0]
+			case "handler_signature":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "handler_signature", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case handlerSignatureSingle, handlerSignatureEventContext:
+				default:
+					return d.Errf("unsupported handler_signature %q, supported signatures: single, event_context", args[0])
+				}
+				fex.HandlerSignature = args[0]
+			case "call_style":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "call_style", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case callStyleDict, callStyleUnpacked:
+				default:
+					return d.Errf("unsupported call_style %q, supported styles: dict, unpacked", args[0])
+				}
+				fex.CallStyle = args[0]
+			case "fallback_entrypoint":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "fallback_entrypoint", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.FallbackEntrypointPath = args[0]
+			case "fallback_function":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "fallback_function", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.FallbackEntrypointHandler = args[0]
+			case "after_function":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "after_function", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.AfterEntrypointHandler = args[0]
+			case "health_function":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "health_function", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.HealthEntrypointHandler = args[0]
+			case "health_timeout":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "health_timeout", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil {
+					return d.Errf("invalid health_timeout %s: %v", args[0], err)
+				}
+				fex.HealthTimeout = caddy.Duration(dur)
+			case "shutdown_function":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "shutdown_function", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.ShutdownEntrypointHandler = args[0]
+			case "shutdown_timeout":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "shutdown_timeout", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil {
+					return d.Errf("invalid shutdown_timeout %s: %v", args[0], err)
+				}
+				fex.ShutdownTimeout = caddy.Duration(dur)
+			case "validate_on_start":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "validate_on_start", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.ValidateOnStart = true
+			case "selftest":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "selftest", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.Selftest = true
+			case "websocket":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "websocket", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.WebSocket = true
+			case "websocket_cross_origin":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "websocket_cross_origin", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.WebSocketCrossOrigin = true
+			case "sse":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "sse", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.SSE = true
+			case "multipart_stream":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "multipart_stream", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.MultipartStream = true
+			case "response_mode":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "response_mode", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case responseModeBuffer, responseModeStream:
+				default:
+					return d.Errf("unsupported response_mode %q, supported modes: buffer, stream", args[0])
+				}
+				fex.ResponseMode = args[0]
+			case "response_rate_limit":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "response_rate_limit", args, 1)
+				if err != nil {
+					return err
+				}
+				rate, err := humanize.ParseBytes(strings.TrimSuffix(args[0], "/s"))
+				if err != nil {
+					return d.Errf("failed to parse response_rate_limit %s: %v", args[0], err)
+				}
+				if rate == 0 {
+					return d.Errf("response_rate_limit must be greater than zero")
+				}
+				fex.ResponseRateLimit = int64(rate)
+			case "status_key", "body_key", "headers_key":
+				name := d.Val()
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, name, args, 1)
+				if err != nil {
+					return err
+				}
+				if args[0] == "" {
+					return d.Errf("%s must not be empty", name)
+				}
+				switch name {
+				case "status_key":
+					fex.StatusKey = args[0]
+				case "body_key":
+					fex.BodyKey = args[0]
+				case "headers_key":
+					fex.HeadersKey = args[0]
+				}
+			case "field_style":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "field_style", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case "snake", "aws":
+				default:
+					return d.Errf("unsupported field_style %q, supported styles: snake, aws", args[0])
+				}
+				fex.FieldStyle = args[0]
+			case "escape_html":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "escape_html", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.EscapeHTML = true
+			case "auto_json":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "auto_json", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.AutoJSON = true
+			case "require_output":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "require_output", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.RequireOutput = true
+			case "partial_on_timeout":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "partial_on_timeout", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.PartialOnTimeout = true
+			case "passthrough_status":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "passthrough_status", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.PassthroughStatus = true
+			case "pass_through":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "pass_through", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.PassThrough = true
+			case "capture_stdout":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "capture_stdout", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.CaptureStdout = true
+			case "response_header_allowlist":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "response_header_allowlist", args, 1); err != nil {
+					return err
+				}
+				fex.ResponseHeaderAllowlist = append(fex.ResponseHeaderAllowlist, args...)
+			case "response_header_denylist":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "response_header_denylist", args, 1); err != nil {
+					return err
+				}
+				fex.ResponseHeaderDenylist = append(fex.ResponseHeaderDenylist, args...)
+			case "security_headers":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "security_headers", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.SecurityHeaders = &SecurityHeaders{}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					name := d.Val()
+					args = d.RemainingArgs()
+					err := ensureArgsCount(d, "security_headers "+name, args, 1)
+					if err != nil {
+						return err
+					}
+					switch name {
+					case "content_type_options":
+						fex.SecurityHeaders.ContentTypeOptions = args[0]
+					case "frame_options":
+						fex.SecurityHeaders.FrameOptions = args[0]
+					case "content_security_policy":
+						fex.SecurityHeaders.ContentSecurityPolicy = args[0]
+					default:
+						return d.Errf("unsupported security_headers option %q", name)
+					}
+				}
+			case "circuit_breaker":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "circuit_breaker", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.CircuitBreaker = &CircuitBreaker{}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					name := d.Val()
+					args = d.RemainingArgs()
+					err := ensureArgsCount(d, "circuit_breaker "+name, args, 1)
+					if err != nil {
+						return err
+					}
+					switch name {
+					case "failure_threshold":
+						count, err := ensureArgUint(d, "circuit_breaker "+name, args[0])
+						if err != nil {
+							return err
+						}
+						fex.CircuitBreaker.FailureThreshold = count
+					case "open_duration":
+						dur, err := caddy.ParseDuration(args[0])
+						if err != nil {
+							return d.Errf("invalid open_duration %s: %v", args[0], err)
+						}
+						fex.CircuitBreaker.OpenDuration = caddy.Duration(dur)
+					case "status_code":
+						code, err := strconv.Atoi(args[0])
+						if err != nil || code < 400 || code > 599 {
+							return d.Errf("circuit_breaker status_code %s must be between 400 and 599", args[0])
+						}
+						fex.CircuitBreaker.StatusCode = code
+					default:
+						return d.Errf("unsupported circuit_breaker option %q", name)
+					}
+				}
+			case "match":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "match", args, 0)
+				if err != nil {
+					return err
+				}
+				if fex.Match == nil {
+					fex.Match = &RequestMatch{}
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					name := d.Val()
+					args = d.RemainingArgs()
+					switch name {
+					case "method":
+						if err := ensureArgsMin(d, "match "+name, args, 1); err != nil {
+							return err
+						}
+						for _, arg := range args {
+							fex.Match.Methods = append(fex.Match.Methods, strings.ToUpper(arg))
+						}
+					case "header":
+						if err := ensureArgsMin(d, "match "+name, args, 1); err != nil {
+							return err
+						}
+						if len(args) > 2 {
+							return ensureArgsCount(d, "match "+name, args, 2)
+						}
+						h := &HeaderMatch{Name: args[0]}
+						if len(args) == 2 {
+							h.Value = args[1]
+						}
+						fex.Match.Headers = append(fex.Match.Headers, h)
+					default:
+						return d.Errf("unsupported match option %q", name)
+					}
+				}
+			case "pipeline":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "pipeline", args, 0)
+				if err != nil {
+					return err
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					name := d.Val()
+					args = d.RemainingArgs()
+					switch name {
+					case "step":
+						if err := ensureArgsMin(d, "pipeline "+name, args, 1); err != nil {
+							return err
+						}
+						if len(args) > 2 {
+							return ensureArgsCount(d, "pipeline "+name, args, 2)
+						}
+						step := &PipelineStep{EntrypointHandler: args[len(args)-1]}
+						if len(args) == 2 {
+							step.EntrypointPath = args[0]
+						}
+						fex.Pipeline = append(fex.Pipeline, step)
+					default:
+						return d.Errf("unsupported pipeline option %q", name)
+					}
+				}
+			case "rate_limit":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "rate_limit", args, 2)
+				if err != nil {
+					return err
+				}
+				count, err := ensureArgUint(d, "rate_limit", args[0])
+				if err != nil {
+					return err
+				}
+				if count == 0 {
+					return d.Errf("rate_limit %s must be greater than zero", args[0])
+				}
+				window, err := caddy.ParseDuration(args[1])
+				if err != nil || window <= 0 {
+					return d.Errf("invalid rate_limit window %s", args[1])
+				}
+				fex.RateLimit = &RateLimit{Requests: count, Window: caddy.Duration(window)}
+			case "crash_loop":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "crash_loop", args, 2)
+				if err != nil {
+					return err
+				}
+				count, err := ensureArgUint(d, "crash_loop", args[0])
+				if err != nil {
+					return err
+				}
+				window, err := caddy.ParseDuration(args[1])
+				if err != nil || window <= 0 {
+					return d.Errf("invalid crash_loop window %s", args[1])
+				}
+				fex.CrashLoop = &CrashLoop{Restarts: count, Window: caddy.Duration(window)}
+			case "secrets":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "secrets", args, 1); err != nil {
+					return err
+				}
+				fex.Secrets = append(fex.Secrets, args...)
+			case "vars":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "vars", args, 1); err != nil {
+					return err
+				}
+				for _, arg := range args {
+					fex.Vars = append(fex.Vars, strings.TrimSuffix(strings.TrimPrefix(arg, "{"), "}"))
+				}
+			case "secrets_ttl":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "secrets_ttl", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil {
+					return d.Errf("invalid secrets_ttl %s: %v", args[0], err)
+				}
+				fex.SecretsTTL = caddy.Duration(dur)
+			case "etag":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "etag", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.ETag = true
+			case "body_file_dir":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "body_file_dir", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.BodyFileDir = args[0]
+			case "redirect_signing_key":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "redirect_signing_key", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.RedirectSigningKey = args[0]
+			case "body_transport":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "body_transport", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case bodyTransportJSON, bodyTransportFD:
+				default:
+					return d.Errf("unsupported body_transport %q, supported transports: json, fd", args[0])
+				}
+				fex.BodyTransport = args[0]
+			case "max_body_size":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "max_body_size", args, 1)
+				if err != nil {
+					return err
+				}
+				size, err := humanize.ParseBytes(args[0])
+				if err != nil {
+					return d.Errf("failed to parse max_body_size %s: %v", args[0], err)
+				}
+				fex.MaxBodySize = int64(size)
+			case "body_type":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "body_type", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case bodyTypeBytes, bodyTypeStr, bodyTypeAuto:
+				default:
+					return d.Errf("unsupported body_type %q, supported types: bytes, str, auto", args[0])
+				}
+				fex.BodyType = args[0]
+			case "accept_content_types":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "accept_content_types", args, 1); err != nil {
+					return err
+				}
+				for _, arg := range args {
+					mediaType, _, err := mime.ParseMediaType(arg)
+					if err != nil {
+						return d.Errf("invalid accept_content_types %s: %v", arg, err)
+					}
+					fex.AcceptContentTypes = append(fex.AcceptContentTypes, mediaType)
+				}
+			case "decompress_request":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "decompress_request", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.DecompressRequest = true
 			case "workers":
 				args = d.RemainingArgs()
-				err := ensureArgsCount(d, args, 1)
+				err := ensureArgsCount(d, "workers", args, 1)
 				if err != nil {
 					return err
 				}
@@ -125,12 +777,249 @@ func (fex *FunctionExecutor) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return err
 				}
 				fex.MaxWorkersCount = count
+			case "isolation":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "isolation", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case isolationShared, isolationPerRequest:
+				default:
+					return d.Errf("unsupported isolation %q, supported levels: shared, per_request", args[0])
+				}
+				fex.Isolation = args[0]
+			case "sticky_header":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "sticky_header", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.StickyHeader = args[0]
+			case "fairness_key":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "fairness_key", args, 1)
+				if err != nil {
+					return err
+				}
+				fex.FairnessKey = args[0]
+			case "max_total_workers":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "max_total_workers", args, 1)
+				if err != nil {
+					return err
+				}
+				count, err := ensureArgUint(d, "max_total_workers", args[0])
+				if err != nil {
+					return err
+				}
+				fex.MaxTotalWorkers = count
+			case "max_retries":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "max_retries", args, 1)
+				if err != nil {
+					return err
+				}
+				count, err := ensureArgUint(d, "max_retries", args[0])
+				if err != nil {
+					return err
+				}
+				fex.MaxRetries = &count
+			case "dispatch_timeout":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "dispatch_timeout", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil {
+					return d.Errf("invalid dispatch_timeout %s: %v", args[0], err)
+				}
+				fex.DispatchTimeout = caddy.Duration(dur)
+			case "max_queue":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "max_queue", args, 1)
+				if err != nil {
+					return err
+				}
+				count, err := ensureArgUint(d, "max_queue", args[0])
+				if err != nil {
+					return err
+				}
+				fex.MaxQueue = count
+			case "import_timeout":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "import_timeout", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil {
+					return d.Errf("invalid import_timeout %s: %v", args[0], err)
+				}
+				fex.ImportTimeout = caddy.Duration(dur)
+			case "write_timeout":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "write_timeout", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil {
+					return d.Errf("invalid write_timeout %s: %v", args[0], err)
+				}
+				fex.WriteTimeout = caddy.Duration(dur)
+			case "max_worker_age":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "max_worker_age", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil || dur <= 0 {
+					return d.Errf("invalid max_worker_age %s: must be a positive duration", args[0])
+				}
+				fex.MaxWorkerAge = caddy.Duration(dur)
+			case "max_total_duration":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "max_total_duration", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil || dur <= 0 {
+					return d.Errf("invalid max_total_duration %s: must be a positive duration", args[0])
+				}
+				fex.MaxTotalDuration = caddy.Duration(dur)
+			case "process_priority":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "process_priority", args, 1)
+				if err != nil {
+					return err
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n < minProcessPriority || n > maxProcessPriority {
+					return d.Errf("invalid process_priority %s: must be between %d and %d", args[0], minProcessPriority, maxProcessPriority)
+				}
+				fex.ProcessPriority = n
+			case "log_sample":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "log_sample", args, 1)
+				if err != nil {
+					return err
+				}
+				rate, err := strconv.ParseFloat(args[0], 64)
+				if err != nil || rate < 0 || rate > 1 {
+					return d.Errf("log_sample %s must be a number between 0 and 1", args[0])
+				}
+				fex.LogSample = &rate
+			case "max_concurrency":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "max_concurrency", args, 1)
+				if err != nil {
+					return err
+				}
+				count, err := ensureArgUint(d, "max_concurrency", args[0])
+				if err != nil {
+					return err
+				}
+				fex.MaxConcurrency = count
+			case "queue_timeout":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "queue_timeout", args, 1)
+				if err != nil {
+					return err
+				}
+				dur, err := caddy.ParseDuration(args[0])
+				if err != nil {
+					return d.Errf("invalid queue_timeout %s: %v", args[0], err)
+				}
+				fex.QueueTimeout = caddy.Duration(dur)
+			case "force_retry":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "force_retry", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.ForceRetry = true
+			case "pass_cookie_header":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "pass_cookie_header", args, 0)
+				if err != nil {
+					return err
+				}
+				fex.PassCookieHeader = true
+			case "error_format":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "error_format", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case "json", "text":
+				default:
+					return d.Errf("unsupported error_format %q, supported formats: json, text", args[0])
+				}
+				fex.ErrorFormat = args[0]
+			case "request_id_format":
+				args = d.RemainingArgs()
+				err := ensureArgsCount(d, "request_id_format", args, 1)
+				if err != nil {
+					return err
+				}
+				switch args[0] {
+				case requestIDFormatUUID, requestIDFormatHex, requestIDFormatULID:
+				default:
+					return d.Errf("unsupported request_id_format %q, supported formats: %s", args[0], strings.Join(supportedRequestIDFormats, ", "))
+				}
+				fex.RequestIDFormat = args[0]
+			case "include":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "include", args, 1); err != nil {
+					return err
+				}
+				for _, arg := range args {
+					if err := ensureRequestField(d, arg); err != nil {
+						return err
+					}
+				}
+				fex.IncludeFields = append(fex.IncludeFields, args...)
+			case "request_transform":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "request_transform", args, 1); err != nil {
+					return err
+				}
+				t := &RequestTransform{Name: args[0], Args: args[1:]}
+				if _, err := newRequestTransform(t); err != nil {
+					return d.Err(err.Error())
+				}
+				fex.RequestTransforms = append(fex.RequestTransforms, t)
+			case "response_transform":
+				args = d.RemainingArgs()
+				if err := ensureArgsMin(d, "response_transform", args, 1); err != nil {
+					return err
+				}
+				t := &ResponseTransform{Name: args[0], Args: args[1:]}
+				if _, err := newResponseTransform(t); err != nil {
+					return d.Err(err.Error())
+				}
+				fex.ResponseTransforms = append(fex.ResponseTransforms, t)
 			default:
 				return d.Errf("unsupported %s directive %q", pluginName, d.Val())
 			}
 		}
 	}
 
+	// The values set in Caddyfile override the values from the config file.
+	if fileConfig != nil {
+		fex.mergeConfig(fileConfig)
+		for _, field := range fex.IncludeFields {
+			if err := ensureRequestField(d, field); err != nil {
+				return err
+			}
+		}
+	}
+
 	switch fex.Runtime {
 	case "python":
 		if fex.Name == "" {
@@ -139,10 +1028,16 @@ func (fex *FunctionExecutor) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 		if fex.EntrypointPath == "" {
 			return d.Errf("%s lambda %s runtime entrypoint path is not set", fex.Name, fex.Runtime)
 		}
-		if fex.EntrypointHandler == "" {
+		if fex.EntrypointHandler == "" && !fex.CaptureStdout {
 			return d.Errf("%s lambda %s runtime entrypoint function is not set", fex.Name, fex.Runtime)
 		}
-		if fex.PythonExecutable == "" {
+		if fex.FallbackEntrypointPath != "" && fex.FallbackEntrypointHandler == "" {
+			return d.Errf("%s lambda %s runtime fallback function is not set", fex.Name, fex.Runtime)
+		}
+		if len(fex.Pipeline) > 0 && fex.CaptureStdout {
+			return d.Errf("%s lambda %s runtime pipeline is not supported with capture_stdout", fex.Name, fex.Runtime)
+		}
+		if fex.PythonExecutable == "" && len(fex.PythonExecutableCandidates) == 0 {
 			fex.PythonExecutable = "python"
 		}
 		if fex.MaxWorkersCount == 0 {
@@ -151,15 +1046,24 @@ func (fex *FunctionExecutor) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 		fex.logger.Debug(
 			"configured lambda function",
 			zap.String("name", fex.Name),
-			zap.String("runtime", fex.Runtime),
-			zap.String("python_executable", fex.PythonExecutable),
-			zap.String("entrypoint", fex.EntrypointPath),
-			zap.String("function", fex.EntrypointHandler),
-			zap.Uint("workers", fex.MaxWorkersCount),
+			zap.Any("settings", fex.getLoggedSettings()),
 		)
+	case "":
+		return d.Err("lambda runtime is not set")
 	default:
-		return d.Errf("lambda runtime is not set")
+		return ensureRuntime(d, fex.Runtime)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// getLoggedSettings returns a copy of the function executor with the
+// redirect signing key redacted. When logged, the copy is encoded as JSON,
+// and the settings left at their defaults are omitted.
+func (fex *FunctionExecutor) getLoggedSettings() *FunctionExecutor {
+	settings := *fex
+	if settings.RedirectSigningKey != "" {
+		settings.RedirectSigningKey = "REDACTED"
+	}
+	return &settings
+}
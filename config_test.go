@@ -15,10 +15,16 @@
 package lambda
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestParseCaddyfile(t *testing.T) {
@@ -44,6 +50,286 @@ func TestParseCaddyfile(t *testing.T) {
 			    "foo": "bar"
 			}`,
 		},
+		{
+			name: "test missing runtime",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+				}`),
+			shouldErr: true,
+			err:       errors.New("lambda runtime is not set, at Testfile:6"),
+		},
+		{
+			name: "test unsupported runtime",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime golang
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+				}`),
+			shouldErr: true,
+			err:       errors.New(`unsupported lambda runtime "golang", supported runtimes: python, at Testfile:4`),
+		},
+		{
+			name: "test empty status key",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					status_key ""
+				}`),
+			shouldErr: true,
+			err:       errors.New("status_key must not be empty, at Testfile:7"),
+		},
+		{
+			name: "test unsupported call style",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					call_style positional
+				}`),
+			shouldErr: true,
+			err:       errors.New(`unsupported call_style "positional", supported styles: dict, unpacked, at Testfile:7`),
+		},
+		{
+			name: "test unsupported request transform",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					request_transform foo
+				}`),
+			shouldErr: true,
+			err:       errors.New(`unsupported request transform "foo", supported transforms: host_to_tenant, set_field, strip_prefix, at Testfile:7`),
+		},
+		{
+			name: "test request transform with invalid arguments",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					request_transform strip_prefix
+				}`),
+			shouldErr: true,
+			err:       errors.New(`invalid request transform "strip_prefix": expected 1 argument, got 0, at Testfile:7`),
+		},
+		{
+			name: "test unsupported response transform",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					response_transform foo
+				}`),
+			shouldErr: true,
+			err:       errors.New(`unsupported response transform "foo", supported transforms: cors, rewrite_location, server_timing, at Testfile:7`),
+		},
+		{
+			name: "test unsupported response mode",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					response_mode chunked
+				}`),
+			shouldErr: true,
+			err:       errors.New(`unsupported response_mode "chunked", supported modes: buffer, stream, at Testfile:7`),
+		},
+		{
+			name: "test unsupported request id format",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					request_id_format snowflake
+				}`),
+			shouldErr: true,
+			err:       errors.New(`unsupported request_id_format "snowflake", supported formats: uuid, hex, ulid, at Testfile:7`),
+		},
+		{
+			name: "test unsupported match option",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					match {
+						path /api
+					}
+				}`),
+			shouldErr: true,
+			err:       errors.New(`unsupported match option "path", at Testfile:8`),
+		},
+		{
+			name: "test pipeline step with too many arguments",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					pipeline {
+						step app.py render html
+					}
+				}`),
+			shouldErr: true,
+			err:       errors.New(`too many arguments for pipeline step: expected 2, got 3, unexpected "html", at Testfile:8`),
+		},
+		{
+			name: "test pipeline with capture_stdout",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					capture_stdout
+					pipeline {
+						step render
+					}
+				}`),
+			shouldErr: true,
+			err:       errors.New(`hello_world lambda python runtime pipeline is not supported with capture_stdout, at Testfile:10`),
+		},
+		{
+			name: "test invalid max_total_duration",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					max_total_duration 0s
+				}`),
+			shouldErr: true,
+			err:       errors.New(`invalid max_total_duration 0s: must be a positive duration, at Testfile:7`),
+		},
+		{
+			name: "test process_priority out of range",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					process_priority 20
+				}`),
+			shouldErr: true,
+			err:       errors.New(`invalid process_priority 20: must be between -20 and 19, at Testfile:7`),
+		},
+		{
+			name: "test too many arguments",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					workers 2 4
+				}`),
+			shouldErr: true,
+			err:       errors.New(`too many arguments for workers: expected 1, got 2, unexpected "4", at Testfile:7`),
+		},
+		{
+			name: "test too few arguments",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					workers
+				}`),
+			shouldErr: true,
+			err:       errors.New(`too few arguments for workers: expected 1, got 0, at Testfile:7`),
+		},
+		{
+			name: "test too few arguments for variadic directive",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					python_path
+				}`),
+			shouldErr: true,
+			err:       errors.New(`too few arguments for python_path: expected at least 1, got 0, at Testfile:7`),
+		},
+		{
+			name: "test invalid argument type",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					workers many
+				}`),
+			shouldErr: true,
+			err:       errors.New(`invalid workers argument "many": expected a non-negative integer, at Testfile:7`),
+		},
+		{
+			name: "test negative argument",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					workers -1
+				}`),
+			shouldErr: true,
+			err:       errors.New(`invalid workers argument "-1": expected a non-negative integer, at Testfile:7`),
+		},
+		{
+			name: "test invalid nested argument type",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					circuit_breaker {
+						failure_threshold 1.5
+					}
+				}`),
+			shouldErr: true,
+			err:       errors.New(`invalid circuit_breaker failure_threshold argument "1.5": expected a non-negative integer, at Testfile:8`),
+		},
+		{
+			name: "test too many nested arguments",
+			d: caddyfile.NewTestDispenser(`
+				lambda {
+					name hello_world
+					runtime python
+					entrypoint assets/scripts/api/hello_world/app/index.py
+					function handler
+					circuit_breaker {
+						failure_threshold 5 10
+					}
+				}`),
+			shouldErr: true,
+			err:       errors.New(`too many arguments for circuit_breaker failure_threshold: expected 1, got 2, unexpected "10", at Testfile:8`),
+		},
 	}
 
 	for _, tc := range testcases {
@@ -80,4 +366,140 @@ func TestParseCaddyfile(t *testing.T) {
 		})
 	}
 
-}
\ No newline at end of file
+}
+func TestParseCaddyfileConfigFile(t *testing.T) {
+	unknownKeyFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(unknownKeyFile, []byte(`{"name": "foo", "wrokers": 2}`), 0600); err != nil {
+		t.Fatalf("failed writing config file: %v", err)
+	}
+
+	for i, tc := range []struct {
+		name      string
+		config    string
+		want      *FunctionExecutor
+		shouldErr bool
+		err       string
+	}{
+		{
+			name: "test yaml config file",
+			config: `
+			lambda {
+				config_file assets/config/hello_world.yaml
+			}`,
+			want: &FunctionExecutor{
+				Name:              "hello_world",
+				Runtime:           "python",
+				EntrypointPath:    "assets/scripts/api/hello_world/app/index.py",
+				EntrypointHandler: "handler",
+				PythonExecutable:  "python",
+				MaxWorkersCount:   2,
+				QueueTimeout:      caddy.Duration(5 * time.Second),
+				IncludeFields:     []string{"method", "path"},
+			},
+		},
+		{
+			name: "test caddyfile values override json config file",
+			config: `
+			lambda {
+				config_file assets/config/hello_world.json
+				name foo
+				workers 4
+				pass_through
+			}`,
+			want: &FunctionExecutor{
+				Name:              "foo",
+				Runtime:           "python",
+				EntrypointPath:    "assets/scripts/api/hello_world/app/index.py",
+				EntrypointHandler: "handler",
+				PythonExecutable:  "python",
+				MaxWorkersCount:   4,
+				QueueTimeout:      caddy.Duration(5 * time.Second),
+				IncludeFields:     []string{"method", "path"},
+				PassThrough:       true,
+			},
+		},
+		{
+			name: "test missing config file",
+			config: `
+			lambda {
+				config_file assets/config/missing.yaml
+			}`,
+			shouldErr: true,
+			err:       "failed loading config_file assets/config/missing.yaml: open assets/config/missing.yaml: no such file or directory, at Testfile:3",
+		},
+		{
+			name: "test config file with unknown key",
+			config: `
+			lambda {
+				config_file ` + unknownKeyFile + `
+			}`,
+			shouldErr: true,
+			err:       "failed loading config_file " + unknownKeyFile + `: json: unknown field "wrokers", at Testfile:3`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := &FunctionExecutor{}
+			fex.logger = initDebugLogger()
+			err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tc.config))
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(tc.err, err.Error()); diff != "" {
+					t.Fatalf("unexpected error mismatch (-want +got):\n%s", diff)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.want, fex, cmpopts.IgnoreUnexported(FunctionExecutor{})); diff != "" {
+				t.Fatalf("unexpected config mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestParseCaddyfileMaxRetries(t *testing.T) {
+	for i, tc := range []struct {
+		name    string
+		options string
+		want    *uint
+	}{
+		{
+			name: "test max_retries is unset",
+		},
+		{
+			name:    "test max_retries 0 disables retries",
+			options: "max_retries 0",
+			want:    func() *uint { n := uint(0); return &n }(),
+		},
+		{
+			name:    "test max_retries 3",
+			options: "max_retries 3",
+			want:    func() *uint { n := uint(3); return &n }(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := &FunctionExecutor{}
+			fex.logger = initDebugLogger()
+			err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`
+			lambda {
+				name hello_world
+				runtime python
+				entrypoint assets/scripts/api/hello_world/app/index.py
+				function handler
+				` + tc.options + `
+			}`))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, fex.MaxRetries); diff != "" {
+				t.Fatalf("unexpected max_retries mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
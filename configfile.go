@@ -0,0 +1,110 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile reads the function configuration from a JSON or YAML
+// file. The keys are the same as in the JSON config of the plugin, e.g.
+// entrypoint_path and workers. Unknown keys are rejected.
+func loadConfigFile(fp string) (*FunctionExecutor, error) {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(fp) {
+	case ".json":
+	case ".yaml", ".yml":
+		m := make(map[string]interface{})
+		if err := yaml.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		b, err = json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q, supported extensions: .json, .yaml, .yml", filepath.Ext(fp))
+	}
+
+	cfg := &FunctionExecutor{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	switch cfg.FieldStyle {
+	case "", "snake", "aws":
+	default:
+		return nil, fmt.Errorf("unsupported field_style %q, supported styles: snake, aws", cfg.FieldStyle)
+	}
+	switch cfg.HandlerSignature {
+	case "", handlerSignatureSingle, handlerSignatureEventContext:
+	default:
+		return nil, fmt.Errorf("unsupported handler_signature %q, supported signatures: single, event_context", cfg.HandlerSignature)
+	}
+	switch cfg.CallStyle {
+	case "", callStyleDict, callStyleUnpacked:
+	default:
+		return nil, fmt.Errorf("unsupported call_style %q, supported styles: dict, unpacked", cfg.CallStyle)
+	}
+	switch cfg.ErrorFormat {
+	case "", "json", "text":
+	default:
+		return nil, fmt.Errorf("unsupported error_format %q, supported formats: json, text", cfg.ErrorFormat)
+	}
+	switch cfg.Isolation {
+	case "", isolationShared, isolationPerRequest:
+	default:
+		return nil, fmt.Errorf("unsupported isolation %q, supported levels: shared, per_request", cfg.Isolation)
+	}
+	switch cfg.BodyTransport {
+	case "", bodyTransportJSON, bodyTransportFD:
+	default:
+		return nil, fmt.Errorf("unsupported body_transport %q, supported transports: json, fd", cfg.BodyTransport)
+	}
+	switch cfg.BodyType {
+	case "", bodyTypeBytes, bodyTypeStr, bodyTypeAuto:
+	default:
+		return nil, fmt.Errorf("unsupported body_type %q, supported types: bytes, str, auto", cfg.BodyType)
+	}
+	return cfg, nil
+}
+
+// mergeConfig sets the config fields which are not set, i.e. have zero
+// values, to the values from the other config.
+func (fex *FunctionExecutor) mergeConfig(cfg *FunctionExecutor) {
+	dst := reflect.ValueOf(fex).Elem()
+	src := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if !dst.Type().Field(i).IsExported() {
+			continue
+		}
+		if dst.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
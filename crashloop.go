@@ -0,0 +1,112 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// CrashLoop holds the config of the crash loop detection of the function.
+// When the workers of the function crash and are restarted more than the
+// number of restarts within the window, the function is crash looping. The
+// plugin logs an error, and the function is reported not ready until the
+// restart rate drops below the threshold.
+type CrashLoop struct {
+	Restarts uint           `json:"restarts,omitempty"`
+	Window   caddy.Duration `json:"window,omitempty"`
+}
+
+// crashLoopDetector tracks the restarts of the crashed workers of the
+// function in a sliding window.
+type crashLoopDetector struct {
+	mu       sync.Mutex
+	name     string
+	restarts uint
+	window   time.Duration
+	// times are the times of the restarts within the window.
+	times   []time.Time
+	looping bool
+	logger  *zap.Logger
+	now     func() time.Time
+}
+
+func newCrashLoopDetector(name string, cfg *CrashLoop, logger *zap.Logger) *crashLoopDetector {
+	return &crashLoopDetector{
+		name:     name,
+		restarts: cfg.Restarts,
+		window:   time.Duration(cfg.Window),
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// isWorkerCrash returns true when the worker process died, as opposed to
+// being replaced by the plugin, e.g. after a timeout.
+func isWorkerCrash(err error) bool {
+	return errors.Is(err, errWorkerExited) || errors.Is(err, errWorkerBrokenPipe)
+}
+
+// recordRestart records the restart of a crashed worker, and logs an error
+// when the restart rate exceeds the threshold.
+func (d *crashLoopDetector) recordRestart(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.times = append(d.times, d.now())
+	if d.update() {
+		d.logger.Error(
+			"lambda workers are crash looping",
+			zap.String("lambda_name", d.name),
+			zap.Int("restarts", len(d.times)),
+			zap.Duration("window", d.window),
+			zap.Error(err),
+		)
+	}
+}
+
+// isLooping returns true when the restart rate exceeds the threshold.
+func (d *crashLoopDetector) isLooping() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.update()
+	return d.looping
+}
+
+// update drops the restarts outside of the window and evaluates the restart
+// rate. It returns true when the function starts crash looping. The caller
+// must hold the lock.
+func (d *crashLoopDetector) update() bool {
+	cutoff := d.now().Add(-d.window)
+	var i int
+	for i < len(d.times) && !d.times[i].After(cutoff) {
+		i++
+	}
+	d.times = d.times[i:]
+
+	looping := uint(len(d.times)) > d.restarts
+	started := looping && !d.looping
+	if d.looping && !looping {
+		d.logger.Info(
+			"lambda workers recovered from crash loop",
+			zap.String("lambda_name", d.name),
+		)
+	}
+	d.looping = looping
+	return started
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCrashLoopDetector(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	core, logs := observer.New(zapcore.InfoLevel)
+	d := newCrashLoopDetector("crash", &CrashLoop{Restarts: 2, Window: caddy.Duration(10 * time.Second)}, zap.New(core))
+	d.now = func() time.Time { return now }
+
+	for i, tc := range []struct {
+		name      string
+		advance   time.Duration
+		restart   bool
+		looping   bool
+		wantError int
+		wantInfo  int
+	}{
+		{name: "test first restart", restart: true},
+		{name: "test second restart", advance: time.Second, restart: true},
+		{name: "test third restart within window starts crash loop", advance: time.Second, restart: true, looping: true, wantError: 1},
+		{name: "test fourth restart does not log again", advance: time.Second, restart: true, looping: true, wantError: 1},
+		{name: "test restarts leaving window end crash loop", advance: 9 * time.Second, looping: false, wantError: 1, wantInfo: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			now = now.Add(tc.advance)
+			if tc.restart {
+				d.recordRestart(errWorkerExited)
+			}
+			if got := d.isLooping(); got != tc.looping {
+				t.Fatalf("unexpected isLooping(): got %t, want %t", got, tc.looping)
+			}
+			if got := logs.FilterMessage("lambda workers are crash looping").Len(); got != tc.wantError {
+				t.Fatalf("unexpected crash loop error count: got %d, want %d", got, tc.wantError)
+			}
+			if got := logs.FilterMessage("lambda workers recovered from crash loop").Len(); got != tc.wantInfo {
+				t.Fatalf("unexpected crash loop recovery count: got %d, want %d", got, tc.wantInfo)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorCrashLoop(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name crash
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/crash/app/index.py
+		function crash_handler
+		workers 1
+		crash_loop 2 1m
+	}`)
+	defer fex.Cleanup()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	fex.crashLoop.logger = zap.New(core)
+
+	for i := 0; i < 3; i++ {
+		if status := fex.checkHealth(); !status.Ready {
+			t.Fatalf("function is not ready after %d restarts: %s", i, status.Error)
+		}
+		// The unsafe method is not retried, so each request restarts a
+		// single worker.
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "POST", "/")); err != nil {
+			t.Fatalf("unexpected invoke() error: %v", err)
+		}
+		if resp.statusCode != http.StatusBadGateway {
+			t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusBadGateway)
+		}
+	}
+
+	entries := logs.FilterMessage("lambda workers are crash looping").All()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected crash loop error count: got %d, want 1", len(entries))
+	}
+	if entries[0].Level != zapcore.ErrorLevel {
+		t.Fatalf("unexpected crash loop log level: %v", entries[0].Level)
+	}
+	status := fex.checkHealth()
+	if status.Ready || status.Error != "workers are crash looping" {
+		t.Fatalf("unexpected health status of crash looping function: %+v", status)
+	}
+}
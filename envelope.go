@@ -0,0 +1,99 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import "sync"
+
+// requestEnvelope is the request data dispatched to the workers. The data
+// is encoded on the first dispatch, and the encoding is reused when the
+// same request is dispatched again, e.g. on retry or to the fallback
+// function, instead of marshaling the data, including the body, each time.
+type requestEnvelope struct {
+	// raw is the request data as built from the request.
+	raw map[string]interface{}
+	// data is the request data in the configured field style, which is
+	// passed to the handlers.
+	data map[string]interface{}
+
+	mu        sync.Mutex
+	encodings map[envelopeEncodingKey]*envelopeEncoding
+}
+
+// envelopeEncodingKey identifies the encoding options of the envelope,
+// which differ by the handler and the worker.
+type envelopeEncodingKey struct {
+	escapeHTML bool
+	splitBody  bool
+}
+
+// envelopeEncoding is the encoded request data.
+type envelopeEncoding struct {
+	// literal is the python string literal of the JSON encoded data.
+	literal string
+	// body is the raw body passed on the body pipe, when it is split from
+	// the data.
+	body []byte
+	err  error
+}
+
+// newRequestEnvelope returns the envelope of the request data, which is
+// passed to the handlers as is.
+func newRequestEnvelope(data map[string]interface{}) *requestEnvelope {
+	return &requestEnvelope{raw: data, data: data}
+}
+
+// newEnvelope returns the envelope of the request data, which is passed to
+// the handlers in the configured field style.
+func (fex *FunctionExecutor) newEnvelope(data map[string]interface{}) *requestEnvelope {
+	return &requestEnvelope{raw: data, data: fex.formatRequestData(data)}
+}
+
+// getRequestID returns the request id of the request data.
+func (e *requestEnvelope) getRequestID() string {
+	requestID, _ := e.raw["request_id"].(string)
+	return requestID
+}
+
+// encode returns the encoding of the request data. When splitBody is true,
+// the raw body is split from the data to be passed on the body pipe.
+func (e *requestEnvelope) encode(escapeHTML, splitBody bool) *envelopeEncoding {
+	key := envelopeEncodingKey{escapeHTML: escapeHTML, splitBody: splitBody}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if enc, found := e.encodings[key]; found {
+		return enc
+	}
+	enc := encodeRequestData(e.data, escapeHTML, splitBody)
+	if e.encodings == nil {
+		e.encodings = make(map[envelopeEncodingKey]*envelopeEncoding)
+	}
+	e.encodings[key] = enc
+	return enc
+}
+
+// encodeRequestData returns the encoding of the request data.
+func encodeRequestData(data map[string]interface{}, escapeHTML, splitBody bool) *envelopeEncoding {
+	enc := &envelopeEncoding{}
+	if splitBody {
+		enc.body, data = splitRequestBody(data)
+	}
+	b, err := marshalJSON(data, escapeHTML)
+	if err != nil {
+		enc.err = err
+		return enc
+	}
+	enc.literal = pythonString(string(b))
+	return enc
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newEnvelopeTestData(bodySize int) map[string]interface{} {
+	return map[string]interface{}{
+		"request_id":   "test-request-id",
+		"method":       "POST",
+		"path":         "/api/items",
+		"query_string": "q=<a>&page=1",
+		"headers": map[string]interface{}{
+			"Content-Type": "text/html",
+			"Accept":       []string{"text/html", "application/json"},
+		},
+		"body":              []byte(strings.Repeat("<a>", bodySize/3)),
+		"is_base64_encoded": true,
+	}
+}
+
+func TestRequestEnvelopeEncode(t *testing.T) {
+	env := newRequestEnvelope(newEnvelopeTestData(30))
+
+	enc := env.encode(false, false)
+	if enc.err != nil {
+		t.Fatalf("unexpected encode() error: %v", enc.err)
+	}
+	if got := env.encode(false, false); got != enc {
+		t.Fatalf("unexpected encoding not reused")
+	}
+	// The encodings with other options are not shared.
+	escaped := env.encode(true, false)
+	if escaped == enc || !strings.Contains(escaped.literal, "\\\\u003c") {
+		t.Fatalf("unexpected encoding with escaped html: %s", escaped.literal)
+	}
+	split := env.encode(false, true)
+	if diff := cmp.Diff([]byte(strings.Repeat("<a>", 10)), split.body); diff != "" {
+		t.Fatalf("unexpected split body mismatch (-want +got):\n%s", diff)
+	}
+	if strings.Contains(split.literal, "body") {
+		t.Fatalf("unexpected body in encoding: %s", split.literal)
+	}
+	if want := pythonString(string(mustMarshalJSON(t, env.data))); enc.literal != want {
+		t.Fatalf("unexpected encoding: got %s, want %s", enc.literal, want)
+	}
+}
+
+func mustMarshalJSON(t *testing.T, v interface{}) []byte {
+	b, err := marshalJSON(v, false)
+	if err != nil {
+		t.Fatalf("unexpected marshalJSON() error: %v", err)
+	}
+	return b
+}
+
+// BenchmarkRequestEnvelopeFanOut compares the encoding of a request
+// dispatched three times, i.e. to the function, on retry, and to the
+// fallback function, with and without reusing the envelope.
+func BenchmarkRequestEnvelopeFanOut(b *testing.B) {
+	const dispatches = 3
+	data := newEnvelopeTestData(64 << 10)
+	b.Run("reencode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < dispatches; j++ {
+				if enc := newRequestEnvelope(data).encode(false, false); enc.err != nil {
+					b.Fatalf("unexpected encode() error: %v", enc.err)
+				}
+			}
+		}
+	})
+	b.Run("reuse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			env := newRequestEnvelope(data)
+			for j := 0; j < dispatches; j++ {
+				if enc := env.encode(false, false); enc.err != nil {
+					b.Fatalf("unexpected encode() error: %v", enc.err)
+				}
+			}
+		}
+	})
+}
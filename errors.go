@@ -0,0 +1,104 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errWorkerTimeout         = errors.New("lambda worker timed out")
+	errWorkersUnavailable    = errors.New("no lambda workers available")
+	errHandlerFailed         = errors.New("lambda handler failed")
+	errWorkerBrokenPipe      = errors.New("lambda worker input is closed")
+	errWorkerWriteTimeout    = errors.New("lambda worker input write timed out")
+	errWorkerExited          = errors.New("lambda worker exited")
+	errWorkerTruncated       = errors.New("lambda worker output is truncated")
+	errConcurrencyLimit      = errors.New("lambda concurrency limit reached")
+	errFairShareLimit        = errors.New("lambda fair share limit reached")
+	errBodyFile              = errors.New("lambda body file is invalid")
+	errSignedRedirect        = errors.New("lambda signed redirect is invalid")
+	errRequestBodyTooLarge   = errors.New("lambda request body is too large")
+	errRequestBodyEncoding   = errors.New("lambda request body encoding is invalid")
+	errStreamCanceled        = errors.New("lambda event stream is canceled")
+	errMalformedStreamRecord = errors.New("lambda stream record is malformed")
+	errQueueFull             = errors.New("lambda request queue is full")
+	errInvalidStatusCode     = errors.New("lambda handler returned invalid status code")
+	errRangeMalformed        = errors.New("lambda range is malformed")
+	errRangeNotSatisfiable   = errors.New("lambda range is not satisfiable")
+	errCircuitOpen           = errors.New("lambda circuit breaker is open")
+	errRateLimited           = errors.New("lambda rate limit reached")
+	errContentTypeRejected   = errors.New("lambda request content type is not accepted")
+	errWebSocketOrigin       = errors.New("lambda websocket origin is not allowed")
+	// errBodyFileDisabled is returned for the body_file of the handler,
+	// when body_file_dir is not set.
+	errBodyFileDisabled = fmt.Errorf("%w: body_file_dir is not set", errBodyFile)
+	// errWorkerMaxDuration is a timeout, so that it is handled as such,
+	// e.g. by the fallback function and the circuit breaker.
+	errWorkerMaxDuration = fmt.Errorf("%w: max total duration exceeded", errWorkerTimeout)
+	// errHandlerNoOutput is a handler failure, when require_output is set.
+	errHandlerNoOutput = fmt.Errorf("%w: no output", errHandlerFailed)
+)
+
+// isWorkerError returns true when the worker process is no longer usable.
+func isWorkerError(err error) bool {
+	return errors.Is(err, errWorkerBrokenPipe) || errors.Is(err, errWorkerWriteTimeout) || errors.Is(err, errWorkerExited) ||
+		errors.Is(err, errWorkerTruncated) || errors.Is(err, errStreamCanceled) || errors.Is(err, errWorkerMaxDuration)
+}
+
+// isRetryableError returns true when the request was not delivered to the
+// worker, and it is safe to dispatch it to another worker.
+func isRetryableError(err error) bool {
+	return errors.Is(err, errWorkerBrokenPipe) || errors.Is(err, errWorkerWriteTimeout)
+}
+
+// queueRetryAfter is the number of seconds a client rejected because the
+// request queue is full should wait before retrying.
+const queueRetryAfter = "1"
+
+// errorEnvelope is the JSON body written on plugin-level failures
+// when error_format is set to json.
+type errorEnvelope struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id"`
+	Code      int    `json:"code"`
+}
+
+// writeError writes a plugin-level error response in the configured format.
+func (fex *FunctionExecutor) writeError(resp http.ResponseWriter, requestID string, statusCode int) {
+	if statusCode == 0 {
+		statusCode = http.StatusInternalServerError
+	}
+	if fex.ErrorFormat == "json" {
+		b, _ := json.Marshal(&errorEnvelope{
+			Error:     http.StatusText(statusCode),
+			RequestID: requestID,
+			Code:      statusCode,
+		})
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		resp.WriteHeader(statusCode)
+		resp.Write(b)
+		return
+	}
+	b := []byte(http.StatusText(statusCode))
+	resp.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	resp.WriteHeader(statusCode)
+	resp.Write(b)
+}
@@ -15,11 +15,19 @@
 package lambda
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
-	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -29,91 +37,695 @@ func (fex *FunctionExecutor) invoke(resp http.ResponseWriter, req *http.Request)
 			return nil
 		}
 	}
-	var requestID string
-	rawRequestID := caddyhttp.GetVar(req.Context(), "request_id")
-	if rawRequestID == nil {
-		requestID = uuid.New().String()
-		caddyhttp.SetVar(req.Context(), "request_id", requestID)
+	requestID := getRequestID(req, fex.RequestIDFormat)
+
+	if fex.WebSocket && isWebSocketRequest(req) {
+		return fex.serveWebSocket(resp, req, requestID)
+	}
+
+	if r, err := fex.admitRequest(resp, req, requestID); err != nil {
+		setPlaceholders(req, requestID, r)
+		fex.writeSecurityHeaders(resp)
+		fex.writeError(resp, requestID, r.StatusCode)
+		return nil
+	}
+
+	var sw streamWriter
+	streamResp := resp
+	if fex.ResponseRateLimit > 0 {
+		streamResp = newRateLimitedWriter(req.Context(), resp, fex.ResponseRateLimit)
+	}
+	switch {
+	case fex.CaptureStdout:
+		// The script is not a handler, so it does not yield records.
+	case fex.SSE && isEventStreamRequest(req):
+		sw = newSSEWriter(streamResp)
+	case fex.MultipartStream:
+		sw = newMultipartWriter(streamResp)
+	case fex.ResponseMode == responseModeStream:
+		sw = fex.newBodyWriter(streamResp, req, requestID)
+	}
+
+	var r *workerResponse
+	var err error
+	if sw != nil {
+		r, err = fex.execStream(req, requestID, sw)
+		if sw.isStarted() {
+			// The response is already written.
+			if bw, ok := sw.(*bodyWriter); ok {
+				r.StatusCode = bw.statusCode
+			}
+			setPlaceholders(req, requestID, r)
+			if fex.afterWorkers != nil {
+				fex.invokeAfter(req, requestID, r)
+			}
+			return nil
+		}
 	} else {
-		requestID = rawRequestID.(string)
+		r, err = fex.execRequest(req, requestID)
+	}
+	setPlaceholders(req, requestID, r)
+	if fex.afterWorkers != nil {
+		defer fex.invokeAfter(req, requestID, r)
+	}
+	if err != nil {
+		if errors.Is(err, errQueueFull) {
+			resp.Header().Set("Retry-After", queueRetryAfter)
+		}
+		if r.RetryAfter > 0 {
+			resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(r.RetryAfter.Seconds()))))
+		}
+		fex.writeSecurityHeaders(resp)
+		fex.writeError(resp, requestID, r.StatusCode)
+		return nil
+	}
+
+	fex.writeResponseHeaders(resp, requestID, r.Headers)
+	fex.writeSecurityHeaders(resp)
+	if r.Partial {
+		resp.Header().Set("X-Lambda-Timeout", "true")
+	}
+	if r.Connection != "" {
+		// The Connection header returned by the handler is dropped, but
+		// its hint is honored, e.g. the connection is not reused after
+		// the response with close.
+		resp.Header().Set("Connection", r.Connection)
+	}
+	if r.Location != "" {
+		// The signed URL is set by the plugin, so the header allowlist
+		// does not apply.
+		resp.Header().Set("Location", r.Location)
+	}
+	fex.applyResponseTransforms(req, r, resp.Header())
+	statusCode := r.StatusCode
+	if isInformationalStatus(statusCode) {
+		// The interim response carries the headers, e.g. the Link headers
+		// of 103 Early Hints, and is followed by the final response.
+		resp.WriteHeader(statusCode)
+		statusCode = http.StatusOK
+	}
+	if statusCode == http.StatusOK {
+		fex.setETag(resp, r)
+		if isNotModified(req, resp.Header()) {
+			resp.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		if writeRange(resp, req, r.Body) {
+			return nil
+		}
+	}
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		resp.WriteHeader(statusCode)
+		return nil
+	}
+	// The body is fully known, so the response is not chunked.
+	resp.Header().Set("Content-Length", strconv.Itoa(len(r.Body)))
+	if req.Method == http.MethodHead {
+		// The body is not written, but its length is announced.
+		resp.WriteHeader(statusCode)
+		return nil
+	}
+	resp.WriteHeader(statusCode)
+	resp.Write(r.Body)
+	return nil
+}
+
+// admitRequest applies the checks preceding the invocation of the function,
+// in both the terminal and the pass-through mode, i.e. the accepted content
+// types and the rate limit, and decompresses the body. When the request is
+// rejected, it returns the response and the error. The rate limit headers
+// are written to resp.
+func (fex *FunctionExecutor) admitRequest(resp http.ResponseWriter, req *http.Request, requestID string) (*workerResponse, error) {
+	if !fex.isContentTypeAccepted(req) {
+		// The body is not read, and the function is not invoked.
+		return &workerResponse{StatusCode: http.StatusUnsupportedMediaType}, errContentTypeRejected
+	}
+
+	if fex.limiter != nil {
+		if ok, status := fex.limiter.allow(); !ok {
+			fex.logger.Debug(
+				"rejected lambda function request",
+				zap.String("lambda_name", fex.Name),
+				zap.String("request_id", requestID),
+				zap.Error(errRateLimited),
+			)
+			status.writeHeaders(resp)
+			return &workerResponse{StatusCode: http.StatusTooManyRequests, RetryAfter: status.reset}, errRateLimited
+		}
+	}
+
+	if fex.DecompressRequest {
+		if err := decompressRequestBody(req); err != nil {
+			fex.logger.Warn(
+				"failed decompressing lambda request body",
+				zap.String("lambda_name", fex.Name),
+				zap.String("request_id", requestID),
+				zap.Error(err),
+			)
+			return &workerResponse{StatusCode: http.StatusBadRequest}, err
+		}
+	}
+	return nil, nil
+}
+
+// invokeAfter dispatches the after function with the request data and the
+// summary of the response. It does not wait for the function to complete.
+func (fex *FunctionExecutor) invokeAfter(req *http.Request, requestID string, r *workerResponse) {
+	method := req.Method
+	data := fex.buildRequestData(req, requestID)
+	data["response"] = map[string]interface{}{
+		"status_code": r.StatusCode,
+		"body_size":   len(r.Body),
+	}
+	go func() {
+		r, err := fex.execPool(fex.afterWorkers, method, "", fex.newEnvelope(data))
+		if err != nil {
+			fex.logger.Warn(
+				"failed executing lambda after function",
+				zap.String("lambda_name", fex.Name),
+				zap.String("request_id", requestID),
+				zap.Error(err),
+			)
+			return
+		}
+		fex.logger.Debug(
+			"completed lambda after function",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.Uint("worker_id", r.WorkerID),
+		)
+	}()
+}
+
+// isInformationalStatus returns true for the 1xx status codes.
+func isInformationalStatus(code int) bool {
+	return code >= 100 && code < 200
+}
+
+// isStatusCodeAllowed returns true when the status code returned by the
+// handler is written as is. The informational status codes, except 101,
+// are allowed with passthrough_status only.
+func (fex *FunctionExecutor) isStatusCodeAllowed(code int) bool {
+	if code >= 200 && code <= 599 {
+		return true
+	}
+	return fex.PassthroughStatus && isInformationalStatus(code) && code != http.StatusSwitchingProtocols
+}
+
+// invokePassThrough executes the function and stores its response in the
+// lambda_status_code and lambda_body variables, instead of writing it, and
+// then calls the next handler in the chain. On failure, the error is stored
+// in the lambda_error variable.
+func (fex *FunctionExecutor) invokePassThrough(resp http.ResponseWriter, req *http.Request, next caddyhttp.Handler) error {
+	if fex.filterURIPattern != nil {
+		if !fex.filterURIPattern.MatchString(req.RequestURI) {
+			return next.ServeHTTP(resp, req)
+		}
+	}
+	requestID := getRequestID(req, fex.RequestIDFormat)
+
+	r, err := fex.admitRequest(resp, req, requestID)
+	if err == nil {
+		r, err = fex.execRequest(req, requestID)
+	}
+	setPlaceholders(req, requestID, r)
+	if err != nil {
+		caddyhttp.SetVar(req.Context(), "lambda_error", err.Error())
+	}
+	caddyhttp.SetVar(req.Context(), "lambda_body", string(r.Body))
+	return next.ServeHTTP(resp, req)
+}
+
+// addRequestBody reads the request body into the request data, when the
+// body is included. On failure, it returns the response to the request.
+func (fex *FunctionExecutor) addRequestBody(req *http.Request, requestID string, data map[string]interface{}) (*workerResponse, error) {
+	if !fex.isFieldIncluded("body") {
+		return nil, nil
+	}
+	body, err := fex.readRequestBody(req)
+	if err != nil {
+		fex.logger.Warn(
+			"failed reading lambda request body",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.Error(err),
+		)
+		if errors.Is(err, errRequestBodyTooLarge) {
+			return &workerResponse{StatusCode: http.StatusRequestEntityTooLarge}, err
+		}
+		return &workerResponse{StatusCode: http.StatusBadRequest}, err
+	}
+	data["body"], data["is_base64_encoded"] = fex.encodeRequestBody(req.Header.Get("Content-Type"), body)
+	return nil, nil
+}
+
+// execRequest executes the function for the request, falling back to the
+// fallback function if configured.
+func (fex *FunctionExecutor) execRequest(req *http.Request, requestID string) (*workerResponse, error) {
+	sampled := fex.isLogSampled()
+	if sampled {
+		fex.logger.Debug(
+			"invoked lambda function",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.String("method", req.Method),
+			zap.String("request_uri", req.RequestURI),
+		)
+	}
+
+	data := fex.buildRequestData(req, requestID)
+	if r, err := fex.addRequestBody(req, requestID, data); err != nil {
+		return r, err
+	}
+
+	start := time.Now()
+	span := fex.startSpan(req)
+	addTraceData(span, data)
+
+	// The envelope is encoded once for the function and the fallback.
+	env := fex.newEnvelope(data)
+	stickyKey := fex.getStickyKey(req)
+	r, err := fex.execWorker(req.Method, stickyKey, fex.getFairnessKey(req), env)
+	if err != nil && fex.isFallbackEnabled() && isFallbackError(err) {
+		fex.logger.Warn(
+			"lambda function failed, invoking fallback",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.Error(err),
+		)
+		r, err = fex.execFallbackWorker(req.Method, stickyKey, env)
+	}
+	if err != nil && r.Partial {
+		fex.logger.Warn(
+			"serving partial output of lambda function",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.Error(err),
+		)
+		err = nil
+	}
+	if err == nil && r.BodyFile != "" {
+		err = fex.readBodyFile(r)
+	}
+	if err == nil && len(fex.pipeline) > 0 {
+		r, err = fex.execPipeline(req.Method, stickyKey, data, r)
+	}
+	if err == nil && r.RedirectSigned != nil {
+		err = fex.signRedirect(r)
+	}
+	if err == nil && !fex.isStatusCodeAllowed(r.StatusCode) {
+		err = fmt.Errorf("%w: %d", errInvalidStatusCode, r.StatusCode)
+		r.StatusCode = http.StatusBadGateway
+	}
+	endSpan(span, r, err)
+	if err != nil {
+		fex.logger.Warn(
+			"failed executing lambda function",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.Error(err),
+		)
+		return r, err
+	}
+
+	duration := time.Since(start)
+	r.Duration = duration
+	if sampled {
+		fields := []zap.Field{
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.Uint("worker_id", r.WorkerID),
+			zap.Int("status_code", r.StatusCode),
+			zap.Int("response_size", len(r.Body)),
+			zap.Duration("duration", duration),
+			zap.Bool("cold", r.Cold),
+		}
+		if r.Stats != nil {
+			fields = append(fields,
+				zap.Float64("user_cpu_ms", r.Stats.UserCPUMillis),
+				zap.Float64("system_cpu_ms", r.Stats.SystemCPUMillis),
+				zap.Int64("max_rss_kb", r.Stats.MaxRSSKilobytes),
+			)
+		}
+		fex.logger.Debug("completed lambda function", fields...)
+	}
+	fex.observeStats(r.Stats)
+	fex.observeDuration(r, duration)
+	return r, nil
+}
+
+// isLogSampled returns true when the invocation and completion of the
+// function should be logged, according to log_sample.
+func (fex *FunctionExecutor) isLogSampled() bool {
+	if fex.LogSample == nil {
+		return true
+	}
+	return rand.Float64() < *fex.LogSample
+}
+
+// setPlaceholders exports the outcome of the function invocation to the
+// downstream handlers via the {http.lambda.status_code} and
+// {http.lambda.request_id} placeholders, and the lambda_status_code and
+// lambda_request_id variables.
+func setPlaceholders(req *http.Request, requestID string, r *workerResponse) {
+	if repl, ok := req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set("http.lambda.status_code", r.StatusCode)
+		repl.Set("http.lambda.request_id", requestID)
+	}
+	caddyhttp.SetVar(req.Context(), "lambda_status_code", r.StatusCode)
+	caddyhttp.SetVar(req.Context(), "lambda_request_id", requestID)
+}
+
+// getRequestID returns the id of the request. The id is taken from the
+// request_id variable, if set. Otherwise, Caddy's {http.request.uuid}
+// placeholder is used so that the lambda and Caddy logs share the same id.
+// A new id is generated when neither is available, or the placeholder
+// cannot be converted to the request_id_format. The resolved id is stored
+// in the request_id variable.
+func getRequestID(req *http.Request, format string) string {
+	if v, ok := caddyhttp.GetVar(req.Context(), "request_id").(string); ok && v != "" {
+		return v
+	}
+	var requestID string
+	if repl, ok := req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		if id, _ := repl.GetString("http.request.uuid"); id != "" {
+			requestID = formatCaddyRequestID(id, format)
+		}
+	}
+	if requestID == "" {
+		requestID = newRequestID(format)
+	}
+	caddyhttp.SetVar(req.Context(), "request_id", requestID)
+	return requestID
+}
+
+// buildRequestData returns the request data passed to the function handler.
+// Only the fields configured via include are populated. The request_id is
+// always present. The secrets and vars are present when configured. The
+// configured request transforms are applied last.
+func (fex *FunctionExecutor) buildRequestData(req *http.Request, requestID string) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["request_id"] = requestID
+
+	if fex.isFieldIncluded("method") {
+		data["method"] = req.Method
+	}
+	if fex.isFieldIncluded("path") {
+		data["path"] = req.URL.Path
+	}
+	if fex.isFieldIncluded("proto") {
+		data["proto"] = req.Proto
+		data["proto_major"] = req.ProtoMajor
+		data["proto_minor"] = req.ProtoMinor
+		data["http2"] = req.ProtoMajor == 2
+		data["http3"] = req.ProtoMajor == 3
+	}
+	if fex.isFieldIncluded("host") {
+		data["host"] = req.Host
+	}
+	if fex.isFieldIncluded("server_name") {
+		// Unlike the host, the name identifies the server of the Caddy
+		// config serving the request, e.g. srv0.
+		if srv, ok := req.Context().Value(caddyhttp.ServerCtxKey).(*caddyhttp.Server); ok && srv != nil && srv.Name() != "" {
+			data["server_name"] = srv.Name()
+		}
+	}
+	if fex.isFieldIncluded("client_cert") {
+		if cert := getClientCert(req); cert != nil {
+			data["client_cert"] = cert
+		}
+	}
+	if fex.isFieldIncluded("request_uri") {
+		data["request_uri"] = req.RequestURI
+	}
+	if fex.isFieldIncluded("remote_addr_port") {
+		data["remote_addr_port"] = req.RemoteAddr
+	}
+	if fex.isFieldIncluded("remote_ip") || fex.isFieldIncluded("remote_port") {
+		ip, port := splitRemoteAddr(req.RemoteAddr)
+		if fex.isFieldIncluded("remote_ip") {
+			data["remote_ip"] = ip
+		}
+		if fex.isFieldIncluded("remote_port") && port > 0 {
+			data["remote_port"] = port
+		}
 	}
 
 	// Extract cookies
-	cookies := req.Cookies()
+	if fex.isFieldIncluded("cookies") {
+		data["cookies"] = req.Cookies()
+	}
 
 	// Extract query parameters
-	queryParams := make(map[string]interface{})
-	queryValues := req.URL.Query()
-	for k, v := range queryValues {
-		if len(v) == 1 {
-			queryParams[k] = v[0]
-		} else {
-			queryParams[k] = v
+	if fex.isFieldIncluded("query_params") {
+		queryParams := make(map[string]interface{})
+		queryValues := req.URL.Query()
+		for k, v := range queryValues {
+			if len(v) == 1 {
+				queryParams[k] = v[0]
+			} else {
+				queryParams[k] = v
+			}
 		}
+		data["query_params"] = queryParams
+	}
+	if fex.isFieldIncluded("query_string") {
+		// The raw query string keeps the order and the encoding of the
+		// parameters, e.g. for signature verification.
+		data["query_string"] = req.URL.RawQuery
 	}
 
 	// Extract headers
-	reqHeaders := make(map[string]interface{})
-	if req.Header != nil {
-		for k, v := range req.Header {
-			if k == "Cookie" || k == "Set-Cookie" {
-				continue
-			}
-			if len(v) == 1 {
-				reqHeaders[k] = v[0]
-			} else {
-				reqHeaders[k] = v
+	if fex.isFieldIncluded("headers") {
+		reqHeaders := make(map[string]interface{})
+		if req.Header != nil {
+			for k, v := range req.Header {
+				if k == "Set-Cookie" {
+					continue
+				}
+				if k == "Cookie" && !fex.PassCookieHeader {
+					continue
+				}
+				if len(v) == 1 {
+					reqHeaders[k] = v[0]
+				} else {
+					reqHeaders[k] = v
+				}
 			}
 		}
+		data["headers"] = reqHeaders
+	}
+
+	if fex.secrets != nil {
+		data["secrets"] = fex.getSecrets(req.Context(), requestID)
 	}
 
-	fex.logger.Debug(
-		"invoked lambda function",
-		zap.String("lambda_name", fex.Name),
-		zap.String("request_id", requestID),
-	)
+	if len(fex.Vars) > 0 {
+		data["vars"] = fex.getVars(req)
+	}
 
-	data := make(map[string]interface{})
-	data["request_id"] = requestID
-	data["method"] = req.Method
-	data["path"] = req.URL.Path
-	data["proto"] = req.Proto
-	data["host"] = req.Host
-	data["request_uri"] = req.RequestURI
-	data["remote_addr_port"] = req.RemoteAddr
-	data["cookies"] = cookies
-	data["headers"] = reqHeaders
-	data["query_params"] = queryParams
-
-	statusCode, body, err := fex.execWorker(data)
+	fex.applyRequestTransforms(req, data)
+	return data
+}
+
+// getVars returns the values of the placeholders configured via vars,
+// keyed by the placeholder name, e.g. http.request.tls.client.subject.
+// The unknown placeholders are omitted.
+func (fex *FunctionExecutor) getVars(req *http.Request) map[string]string {
+	m := make(map[string]string)
+	repl, ok := req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return m
+	}
+	for _, name := range fex.Vars {
+		if v, found := repl.GetString(name); found {
+			m[name] = v
+		}
+	}
+	return m
+}
+
+// splitRemoteAddr splits the remote address of the request into the IP
+// address and the port. If the address has no port, the port is zero.
+func splitRemoteAddr(addr string) (string, int) {
+	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
-		resp.WriteHeader(http.StatusInternalServerError)
-		resp.Write([]byte(http.StatusText(http.StatusInternalServerError)))
-		return nil
+		return addr, 0
 	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return host, 0
+	}
+	return host, n
+}
 
-	resp.WriteHeader(statusCode)
-	resp.Write(body)
+// isFieldIncluded returns true when the request field should be passed
+// to the function handler.
+func (fex *FunctionExecutor) isFieldIncluded(name string) bool {
+	if len(fex.IncludeFields) == 0 {
+		return true
+	}
+	for _, field := range fex.IncludeFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// execWorker executes the function subject to the circuit breaker. When the
+// circuit is open, the request fails fast.
+func (fex *FunctionExecutor) execWorker(method, stickyKey, fairnessKey string, env *requestEnvelope) (*workerResponse, error) {
+	if fex.breaker == nil {
+		return fex.execPrimaryWorker(method, stickyKey, fairnessKey, env)
+	}
+	if ok, wait := fex.breaker.allow(); !ok {
+		return &workerResponse{StatusCode: fex.CircuitBreaker.StatusCode, RetryAfter: wait}, errCircuitOpen
+	}
+	r, err := fex.execPrimaryWorker(method, stickyKey, fairnessKey, env)
+	fex.breaker.record(err)
+	return r, err
+}
+
+// execPrimaryWorker executes the function. When fairness_key is set, the
+// request waits for the fair share of its key, and when max_concurrency is
+// set, for an invocation slot, each for up to queue_timeout.
+func (fex *FunctionExecutor) execPrimaryWorker(method, stickyKey, fairnessKey string, env *requestEnvelope) (*workerResponse, error) {
+	if fex.scheduler != nil {
+		if err := fex.scheduler.acquire(fairnessKey, time.Duration(fex.QueueTimeout)); err != nil {
+			return &workerResponse{StatusCode: http.StatusServiceUnavailable}, err
+		}
+		defer fex.scheduler.release(fairnessKey)
+	}
+	if fex.concurrency != nil {
+		if err := fex.acquireConcurrency(context.Background()); err != nil {
+			return &workerResponse{StatusCode: http.StatusServiceUnavailable}, err
+		}
+		defer fex.concurrency.Release(1)
+	}
+	if fex.CaptureStdout {
+		return fex.execScript(env.raw)
+	}
+	return fex.execPool(fex.workers, method, stickyKey, env)
+}
+
+// acquireConcurrency acquires an invocation slot. When max_concurrency is
+// reached, it waits for up to queue_timeout.
+func (fex *FunctionExecutor) acquireConcurrency(ctx context.Context) error {
+	if fex.concurrency.TryAcquire(1) {
+		return nil
+	}
+	queuedAt := time.Now()
+	observeQueued(fex.Name)
+	defer observeDequeued(fex.Name, queuedAt)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(fex.QueueTimeout))
+	defer cancel()
+	if err := fex.concurrency.Acquire(ctx, 1); err != nil {
+		return errConcurrencyLimit
+	}
 	return nil
 }
 
-func (fex *FunctionExecutor) execWorker(data map[string]interface{}) (int, []byte, error) {
-	availableWorkers := 0
-	for {
-		for _, w := range fex.workers {
-			if w.Terminated {
-				continue
-			}
-			if w.InUse {
-				availableWorkers++
-				continue
-			}
-			return w.handle(fex.entrypointImport, fex.EntrypointHandler, data)
+func (fex *FunctionExecutor) execFallbackWorker(method, stickyKey string, env *requestEnvelope) (*workerResponse, error) {
+	return fex.execPool(fex.fallbackWorkers, method, stickyKey, env)
+}
+
+// execPool dispatches the request to a worker of the pool. The requests
+// with the same non-empty sticky key prefer the same worker.
+func (fex *FunctionExecutor) execPool(p *workerPool, method, stickyKey string, env *requestEnvelope) (*workerResponse, error) {
+	if p == nil {
+		return &workerResponse{StatusCode: http.StatusServiceUnavailable}, errWorkersUnavailable
+	}
+	return p.exec(env.getRequestID(), stickyKey, env, fex.getRetryPolicy(method))
+}
+
+// getStickyKey returns the value of the sticky_header of the request, which
+// pins the requests of a session to a worker.
+func (fex *FunctionExecutor) getStickyKey(req *http.Request) string {
+	if fex.StickyHeader == "" {
+		return ""
+	}
+	return req.Header.Get(fex.StickyHeader)
+}
+
+// getFairnessKey returns the value of the fairness_key header of the
+// request, e.g. the tenant, which the fair share of the workers applies to.
+func (fex *FunctionExecutor) getFairnessKey(req *http.Request) string {
+	if fex.FairnessKey == "" {
+		return ""
+	}
+	return req.Header.Get(fex.FairnessKey)
+}
+
+// getRetryPolicy returns the retry policy for the request method. Requests
+// delivered to a worker which crashed are retried only for safe methods,
+// unless force_retry is set.
+func (fex *FunctionExecutor) getRetryPolicy(method string) retryPolicy {
+	return retryPolicy{
+		maxRetries:  *fex.MaxRetries,
+		retryExited: fex.ForceRetry || isSafeMethod(method),
+	}
+}
+
+// isSafeMethod returns true for the HTTP methods which are safe to retry.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// awsFieldNames maps request data keys to the names used in AWS API Gateway
+// proxy events. Other keys are converted to camelCase.
+var awsFieldNames = map[string]string{
+	"method":       "httpMethod",
+	"query_params": "queryStringParameters",
+	"query_string": "rawQueryString",
+	"request_id":   "requestId",
+}
+
+// formatRequestData returns the request data with the keys named according
+// to the configured field style.
+func (fex *FunctionExecutor) formatRequestData(data map[string]interface{}) map[string]interface{} {
+	if fex.FieldStyle != "aws" {
+		return data
+	}
+	m := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		if name, found := awsFieldNames[k]; found {
+			m[name] = v
+			continue
+		}
+		m[toCamelCase(k)] = v
+	}
+	if requestID, found := data["request_id"]; found {
+		m["requestContext"] = map[string]interface{}{
+			"requestId": requestID,
 		}
-		if availableWorkers < 1 {
-			break
+	}
+	return m
+}
+
+// toCamelCase converts snake_case string to camelCase.
+func toCamelCase(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] == "" {
+			continue
 		}
-		time.Sleep(100 * time.Millisecond)
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
 	}
-	return http.StatusServiceUnavailable, []byte(http.StatusText(http.StatusServiceUnavailable)), nil
-}
\ No newline at end of file
+	return strings.Join(parts, "")
+}
+
+// isFallbackEnabled returns true when the fallback handler is configured.
+func (fex *FunctionExecutor) isFallbackEnabled() bool {
+	return fex.FallbackEntrypointHandler != ""
+}
+
+// isFallbackError returns true when the error warrants invoking the
+// fallback handler, i.e. the primary handler failed or timed out.
+func isFallbackError(err error) bool {
+	return errors.Is(err, errHandlerFailed) || errors.Is(err, errWorkerTimeout)
+}
@@ -0,0 +1,979 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestBuildRequestDataVars(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		vars {http.request.method} {http.vars.geo_country} http.request.tls.client.subject {http.vars.missing}
+	}`)
+	defer fex.Cleanup()
+
+	for i, tc := range []struct {
+		name     string
+		replacer bool
+		want     map[string]string
+	}{
+		{
+			name:     "test vars resolved from placeholders",
+			replacer: true,
+			want: map[string]string{
+				"http.request.method":   "GET",
+				"http.vars.geo_country": "CA",
+				"http.vars.missing":     "",
+			},
+		},
+		{
+			name: "test vars without replacer",
+			want: map[string]string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newRequest(t, "GET", "/")
+			if tc.replacer {
+				ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]any{"geo_country": "CA"})
+				req = req.WithContext(ctx)
+				caddyhttp.NewTestReplacer(req)
+			}
+			data := fex.buildRequestData(req, "test-request-id")
+			if diff := cmp.Diff(tc.want, data["vars"]); diff != "" {
+				t.Fatalf("unexpected vars mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestBuildRequestDataCookieHeader(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		fex        FunctionExecutor
+		wantHeader bool
+	}{
+		{
+			name: "test cookie header is not passed by default",
+			fex:  FunctionExecutor{},
+		},
+		{
+			name: "test cookie header is passed when enabled",
+			fex: FunctionExecutor{
+				PassCookieHeader: true,
+			},
+			wantHeader: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newRequest(t, "GET", "/")
+			req.Header.Set("Cookie", "foo=bar; baz=qux")
+			data := tc.fex.buildRequestData(req, "test-request-id")
+
+			headers := data["headers"].(map[string]interface{})
+			header, found := headers["Cookie"]
+			if found != tc.wantHeader {
+				t.Fatalf("unexpected Cookie header presence: got %t, want %t", found, tc.wantHeader)
+			}
+			if found && header != "foo=bar; baz=qux" {
+				t.Fatalf("unexpected Cookie header: %v", header)
+			}
+
+			cookies := data["cookies"].([]*http.Cookie)
+			if len(cookies) != 2 {
+				t.Fatalf("unexpected cookie count: got %d, want 2", len(cookies))
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWriteErrorFormat(t *testing.T) {
+	for i, tc := range []struct {
+		name        string
+		format      string
+		statusCode  int
+		want        string
+		contentType string
+	}{
+		{
+			name:        "test internal server error in json format",
+			format:      "json",
+			statusCode:  http.StatusInternalServerError,
+			want:        `{"error":"Internal Server Error","request_id":"test-request-id","code":500}`,
+			contentType: "application/json",
+		},
+		{
+			name:        "test service unavailable error in json format",
+			format:      "json",
+			statusCode:  http.StatusServiceUnavailable,
+			want:        `{"error":"Service Unavailable","request_id":"test-request-id","code":503}`,
+			contentType: "application/json",
+		},
+		{
+			name:        "test request timeout error in json format",
+			format:      "json",
+			statusCode:  http.StatusRequestTimeout,
+			want:        `{"error":"Request Timeout","request_id":"test-request-id","code":408}`,
+			contentType: "application/json",
+		},
+		{
+			name:        "test request entity too large error in json format",
+			format:      "json",
+			statusCode:  http.StatusRequestEntityTooLarge,
+			want:        `{"error":"Request Entity Too Large","request_id":"test-request-id","code":413}`,
+			contentType: "application/json",
+		},
+		{
+			name:       "test request timeout error in text format",
+			format:     "text",
+			statusCode: http.StatusRequestTimeout,
+			want:       "Request Timeout",
+		},
+		{
+			name:       "test unset status code defaults to internal server error",
+			statusCode: 0,
+			want:       "Internal Server Error",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{ErrorFormat: tc.format}
+			fex.logger = initLogger(zapcore.DebugLevel)
+			resp := newResponseWriter(fex.logger)
+			fex.writeError(resp, "test-request-id", tc.statusCode)
+
+			wantStatusCode := tc.statusCode
+			if wantStatusCode == 0 {
+				wantStatusCode = http.StatusInternalServerError
+			}
+			if resp.statusCode != wantStatusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, wantStatusCode)
+			}
+			if diff := cmp.Diff(tc.want, string(resp.body)); diff != "" {
+				t.Fatalf("unexpected body mismatch (-want +got):\n%s", diff)
+			}
+			if got := resp.Header().Get("Content-Type"); got != tc.contentType {
+				t.Fatalf("unexpected content type: got %q, want %q", got, tc.contentType)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestInvokeUnavailableErrorFormat(t *testing.T) {
+	fex := FunctionExecutor{
+		Name:        "foo",
+		ErrorFormat: "json",
+	}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	resp := newResponseWriter(fex.logger)
+	req := newRequest(t, "GET", "/")
+	if err := fex.invoke(resp, req); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusServiceUnavailable)
+	}
+	var envelope errorEnvelope
+	if err := json.Unmarshal(resp.body, &envelope); err != nil {
+		t.Fatalf("unexpected body %q: %v", resp.body, err)
+	}
+	if envelope.Code != http.StatusServiceUnavailable || envelope.RequestID == "" {
+		t.Fatalf("unexpected error envelope: %+v", envelope)
+	}
+}
+
+func TestBuildRequestDataIncludeFields(t *testing.T) {
+	for i, tc := range []struct {
+		name   string
+		fields []string
+		want   []string
+	}{
+		{
+			name: "test all fields are included by default",
+			want: []string{
+				"cookies", "headers", "host", "http2", "http3", "method", "path", "proto", "proto_major", "proto_minor",
+				"query_params", "query_string", "remote_addr_port", "remote_ip", "remote_port", "request_id", "request_uri",
+			},
+		},
+		{
+			name:   "test only included fields are present",
+			fields: []string{"path", "headers"},
+			want:   []string{"headers", "path", "request_id"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{IncludeFields: tc.fields}
+			req := newRequest(t, "GET", "/foo?bar=baz")
+			encodedData, err := json.Marshal(fex.buildRequestData(req, "test-request-id"))
+			if err != nil {
+				t.Fatalf("unexpected json.Marshal() error: %v", err)
+			}
+			m := make(map[string]interface{})
+			if err := json.Unmarshal(encodedData, &m); err != nil {
+				t.Fatalf("unexpected json.Unmarshal() error: %v", err)
+			}
+			var got []string
+			for k := range m {
+				got = append(got, k)
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected fields mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestBuildRequestDataRemoteAddr(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		remoteAddr string
+		want       map[string]interface{}
+	}{
+		{
+			name:       "test ipv4 remote address",
+			remoteAddr: "192.168.1.10:51234",
+			want: map[string]interface{}{
+				"remote_addr_port": "192.168.1.10:51234",
+				"remote_ip":        "192.168.1.10",
+				"remote_port":      51234,
+			},
+		},
+		{
+			name:       "test ipv6 remote address",
+			remoteAddr: "[2001:db8::1]:443",
+			want: map[string]interface{}{
+				"remote_addr_port": "[2001:db8::1]:443",
+				"remote_ip":        "2001:db8::1",
+				"remote_port":      443,
+			},
+		},
+		{
+			name:       "test remote address without port",
+			remoteAddr: "2001:db8::1",
+			want: map[string]interface{}{
+				"remote_addr_port": "2001:db8::1",
+				"remote_ip":        "2001:db8::1",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{IncludeFields: []string{"remote_addr_port", "remote_ip", "remote_port"}}
+			req := newRequest(t, "GET", "/")
+			req.RemoteAddr = tc.remoteAddr
+			data := fex.buildRequestData(req, "test-request-id")
+			delete(data, "request_id")
+			if diff := cmp.Diff(tc.want, data); diff != "" {
+				t.Fatalf("unexpected data mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestBuildRequestDataServerName(t *testing.T) {
+	for i, tc := range []struct {
+		name   string
+		server *caddyhttp.Server
+		want   map[string]interface{}
+	}{
+		{
+			name: "test request without server",
+			want: map[string]interface{}{"host": "example.com"},
+		},
+		{
+			name:   "test request with unnamed server",
+			server: &caddyhttp.Server{},
+			want:   map[string]interface{}{"host": "example.com"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{IncludeFields: []string{"host", "server_name"}}
+			req := newRequest(t, "GET", "/")
+			req.Host = "example.com"
+			if tc.server != nil {
+				req = req.WithContext(context.WithValue(req.Context(), caddyhttp.ServerCtxKey, tc.server))
+			}
+			data := fex.buildRequestData(req, "test-request-id")
+			delete(data, "request_id")
+			if diff := cmp.Diff(tc.want, data); diff != "" {
+				t.Fatalf("unexpected data mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestBuildRequestDataProto(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		proto      string
+		protoMajor int
+		protoMinor int
+		want       map[string]interface{}
+	}{
+		{
+			name:       "test http/1.1 request",
+			proto:      "HTTP/1.1",
+			protoMajor: 1,
+			protoMinor: 1,
+			want: map[string]interface{}{
+				"proto":       "HTTP/1.1",
+				"proto_major": 1,
+				"proto_minor": 1,
+				"http2":       false,
+				"http3":       false,
+			},
+		},
+		{
+			name:       "test http/2 request",
+			proto:      "HTTP/2.0",
+			protoMajor: 2,
+			want: map[string]interface{}{
+				"proto":       "HTTP/2.0",
+				"proto_major": 2,
+				"proto_minor": 0,
+				"http2":       true,
+				"http3":       false,
+			},
+		},
+		{
+			name:       "test http/3 request",
+			proto:      "HTTP/3.0",
+			protoMajor: 3,
+			want: map[string]interface{}{
+				"proto":       "HTTP/3.0",
+				"proto_major": 3,
+				"proto_minor": 0,
+				"http2":       false,
+				"http3":       true,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{IncludeFields: []string{"proto"}}
+			req := newRequest(t, "GET", "/")
+			req.Proto, req.ProtoMajor, req.ProtoMinor = tc.proto, tc.protoMajor, tc.protoMinor
+			data := fex.buildRequestData(req, "test-request-id")
+			delete(data, "request_id")
+			if diff := cmp.Diff(tc.want, data); diff != "" {
+				t.Fatalf("unexpected data mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestBuildRequestDataQueryString(t *testing.T) {
+	for i, tc := range []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{
+			name: "test no query string",
+			uri:  "/foo",
+			want: "",
+		},
+		{
+			name: "test query string keeps order",
+			uri:  "/foo?z=1&a=2&z=3",
+			want: "z=1&a=2&z=3",
+		},
+		{
+			name: "test query string keeps encoding",
+			uri:  "/foo?sig=a%2Bb%3D&q=hello+world&name=%E2%9C%93&flag",
+			want: "sig=a%2Bb%3D&q=hello+world&name=%E2%9C%93&flag",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{}
+			data := fex.buildRequestData(newRequest(t, "GET", tc.uri), "test-request-id")
+			if diff := cmp.Diff(tc.want, data["query_string"]); diff != "" {
+				t.Fatalf("unexpected query string mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestGetRequestID(t *testing.T) {
+	for i, tc := range []struct {
+		name     string
+		vars     map[string]interface{}
+		replacer bool
+		want     string
+	}{
+		{
+			name: "test pre-set request_id variable is reused",
+			vars: map[string]interface{}{"request_id": "foo-bar"},
+			want: "foo-bar",
+		},
+		{
+			name:     "test caddy request uuid is used",
+			vars:     map[string]interface{}{},
+			replacer: true,
+		},
+		{
+			name: "test request id is generated",
+			vars: map[string]interface{}{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newRequest(t, "GET", "/")
+			ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, tc.vars)
+			req = req.WithContext(ctx)
+			want := tc.want
+			if tc.replacer {
+				repl := caddyhttp.NewTestReplacer(req)
+				want, _ = repl.GetString("http.request.uuid")
+			}
+
+			got := getRequestID(req, "")
+			if want != "" && got != want {
+				t.Fatalf("unexpected request id: got %q, want %q", got, want)
+			}
+			if _, err := uuid.Parse(got); want == "" && err != nil {
+				t.Fatalf("unexpected generated request id %q: %v", got, err)
+			}
+			if v := caddyhttp.GetVar(req.Context(), "request_id"); v != got {
+				t.Fatalf("unexpected request_id variable: got %v, want %q", v, got)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFormatRequestDataFieldStyle(t *testing.T) {
+	for i, tc := range []struct {
+		name  string
+		style string
+		want  []string
+	}{
+		{
+			name: "test snake field style",
+			want: []string{
+				"cookies", "headers", "host", "http2", "http3", "method", "path", "proto", "proto_major", "proto_minor",
+				"query_params", "query_string", "remote_addr_port", "remote_ip", "remote_port", "request_id", "request_uri",
+			},
+		},
+		{
+			name:  "test aws field style",
+			style: "aws",
+			want: []string{
+				"cookies", "headers", "host", "http2", "http3", "httpMethod", "path", "proto", "protoMajor", "protoMinor",
+				"queryStringParameters", "rawQueryString", "remoteAddrPort", "remoteIp", "remotePort", "requestContext", "requestId", "requestUri",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{FieldStyle: tc.style}
+			req := newRequest(t, "GET", "/foo?bar=baz")
+			data := fex.formatRequestData(fex.buildRequestData(req, "test-request-id"))
+			var got []string
+			for k := range data {
+				got = append(got, k)
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected fields mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestInvokeSetsPlaceholders(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+	}`)
+	defer fex.Cleanup()
+
+	req := newRequest(t, "GET", "/")
+	ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{})
+	req = req.WithContext(ctx)
+	repl := caddyhttp.NewTestReplacer(req)
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, req); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+
+	requestID := caddyhttp.GetVar(req.Context(), "request_id")
+	if v := caddyhttp.GetVar(req.Context(), "lambda_request_id"); v != requestID {
+		t.Fatalf("unexpected lambda_request_id variable: got %v, want %v", v, requestID)
+	}
+	if v := caddyhttp.GetVar(req.Context(), "lambda_status_code"); v != http.StatusOK {
+		t.Fatalf("unexpected lambda_status_code variable: %v", v)
+	}
+	if got := repl.ReplaceAll("{http.lambda.status_code} {http.lambda.request_id}", ""); got != "200 "+requestID.(string) {
+		t.Fatalf("unexpected placeholders: %q", got)
+	}
+}
+
+func TestExecWorkerMaxConcurrency(t *testing.T) {
+	for i, tc := range []struct {
+		name         string
+		queueTimeout string
+		want         map[int]int
+		minDuration  time.Duration
+	}{
+		{
+			name:         "test requests over the limit time out in queue",
+			queueTimeout: "100ms",
+			want: map[int]int{
+				http.StatusOK:                 2,
+				http.StatusServiceUnavailable: 2,
+			},
+		},
+		{
+			name:         "test requests over the limit wait in queue",
+			queueTimeout: "5s",
+			want: map[int]int{
+				http.StatusOK: 4,
+			},
+			minDuration: time.Second,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name slow
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/slow/app/index.py
+				function handler
+				workers 4
+				max_concurrency 2
+				queue_timeout `+tc.queueTimeout+`
+			}`)
+			defer fex.Cleanup()
+
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			got := make(map[int]int)
+			start := time.Now()
+			for j := 0; j < 4; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp := newResponseWriter(fex.logger)
+					if err := fex.invoke(resp, newRequest(t, "GET", "/?sleep=0.5")); err != nil {
+						t.Errorf("unexpected invoke() error: %v", err)
+					}
+					mu.Lock()
+					got[resp.statusCode]++
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected status codes mismatch (-want +got):\n%s", diff)
+			}
+			if d := time.Since(start); d < tc.minDuration {
+				t.Fatalf("unexpected duration: got %s, want at least %s", d, tc.minDuration)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestExecRequestLogSample(t *testing.T) {
+	for i, tc := range []struct {
+		name     string
+		rate     string
+		function string
+		want     []string
+	}{
+		{
+			name:     "test rate 0 does not log successful invocations",
+			rate:     "0",
+			function: "handler",
+		},
+		{
+			name:     "test rate 0 logs failed invocations",
+			rate:     "0",
+			function: "crash_handler",
+			want:     []string{"failed executing lambda function"},
+		},
+		{
+			name:     "test rate 1 logs all invocations",
+			rate:     "1",
+			function: "handler",
+			want:     []string{"invoked lambda function", "completed lambda function"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name crash
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/crash/app/index.py
+				function `+tc.function+`
+				log_sample `+tc.rate+`
+			}`)
+			defer fex.Cleanup()
+
+			core, logs := observer.New(zapcore.DebugLevel)
+			fex.logger = zap.New(core)
+			req := newRequest(t, "POST", "/")
+			fex.execRequest(req, "test-request-id")
+
+			var got []string
+			for _, entry := range logs.All() {
+				if entry.ContextMap()["request_id"] == "test-request-id" {
+					got = append(got, entry.Message)
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected log messages mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestInvokeHeadRequest(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name head
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/etag/app/index.py
+		function plain_handler
+	}`)
+	defer fex.Cleanup()
+
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "HEAD", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusOK)
+	}
+	if resp.body != nil {
+		t.Fatalf("unexpected body in HEAD response: %s", resp.body)
+	}
+	if got := resp.Header().Get("Content-Length"); got != "2" {
+		t.Fatalf("unexpected Content-Length: got %q, want %q", got, "2")
+	}
+}
+
+func TestInvokeContentLength(t *testing.T) {
+	for i, tc := range []struct {
+		name     string
+		function string
+		format   string
+	}{
+		{
+			name:     "test successful response",
+			function: "handler",
+		},
+		{
+			name:     "test error response in text format",
+			function: "crash_handler",
+		},
+		{
+			name:     "test error response in json format",
+			function: "crash_handler",
+			format:   "json",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name crash
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/crash/app/index.py
+				function ` + tc.function
+			if tc.format != "" {
+				config += `
+				error_format ` + tc.format
+			}
+			config += `
+			}`
+			fex := newTestFunctionExecutor(t, config)
+			defer fex.Cleanup()
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "POST", "/")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if got, want := resp.Header().Get("Content-Length"), strconv.Itoa(len(resp.body)); got != want {
+				t.Fatalf("unexpected Content-Length: got %q, want %q", got, want)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestInvokePassthroughStatus(t *testing.T) {
+	for i, tc := range []struct {
+		name           string
+		code           int
+		options        string
+		wantStatusCode int
+		wantInterim    []int
+		wantBody       string
+	}{
+		{
+			name:           "test handler returns 301",
+			code:           301,
+			wantStatusCode: 301,
+			wantBody:       "status 301",
+		},
+		{
+			name:           "test handler returns 418",
+			code:           418,
+			wantStatusCode: 418,
+			wantBody:       "status 418",
+		},
+		{
+			name:           "test handler returns 503",
+			code:           503,
+			wantStatusCode: 503,
+			wantBody:       "status 503",
+		},
+		{
+			name:           "test handler returns 204 without body",
+			code:           204,
+			wantStatusCode: 204,
+		},
+		{
+			name:           "test handler returns 103 without passthrough_status",
+			code:           103,
+			wantStatusCode: http.StatusBadGateway,
+			wantBody:       "Bad Gateway",
+		},
+		{
+			name:           "test handler returns 103 with passthrough_status",
+			code:           103,
+			options:        "passthrough_status",
+			wantStatusCode: http.StatusOK,
+			wantInterim:    []int{103},
+			wantBody:       "status 103",
+		},
+		{
+			name:           "test handler returns invalid status code",
+			code:           700,
+			options:        "passthrough_status",
+			wantStatusCode: http.StatusBadGateway,
+			wantBody:       "Bad Gateway",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name status
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/status/app/index.py
+				function status_handler
+				`+tc.options+`
+			}`)
+			defer fex.Cleanup()
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", fmt.Sprintf("/?code=%d", tc.code))); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.wantStatusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.wantStatusCode)
+			}
+			if diff := cmp.Diff(tc.wantInterim, resp.interimStatusCodes); diff != "" {
+				t.Fatalf("unexpected interim status codes mismatch (-want +got):\n%s", diff)
+			}
+			if string(resp.body) != tc.wantBody {
+				t.Fatalf("unexpected body: got %q, want %q", resp.body, tc.wantBody)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestInvokeAfterFunction(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name after
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/after/app/index.py
+		function handler
+		after_function after
+	}`)
+	defer fex.Cleanup()
+
+	for i, tc := range []struct {
+		name       string
+		code       int
+		statusCode int
+	}{
+		{
+			name:       "test after function receives success status",
+			code:       201,
+			statusCode: http.StatusCreated,
+		},
+		{
+			name:       "test after function receives error status",
+			code:       503,
+			statusCode: http.StatusServiceUnavailable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fp := filepath.Join(t.TempDir(), "audit.json")
+			req := newRequest(t, "GET", "/?code="+strconv.Itoa(tc.code)+"&audit="+url.QueryEscape(fp))
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, req); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			// The response is written before the after function completes.
+			if _, err := os.Stat(fp); err == nil {
+				t.Fatalf("unexpected after function completed before response")
+			}
+
+			var got map[string]interface{}
+			for deadline := time.Now().Add(5 * time.Second); got == nil && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+				b, _ := os.ReadFile(fp)
+				json.Unmarshal(b, &got)
+			}
+			want := map[string]interface{}{
+				"status_code": float64(tc.statusCode),
+				"body_size":   float64(2),
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("unexpected response summary mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestInvokeResultKeys(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name result_keys
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/result_keys/app/index.py
+		function handler
+		status_key code
+		body_key data
+		headers_key hdrs
+	}`)
+	defer fex.Cleanup()
+
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	// The status_code returned by the handler is ignored.
+	if resp.statusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusCreated)
+	}
+	if string(resp.body) != "created" {
+		t.Fatalf("unexpected body: got %q, want %q", resp.body, "created")
+	}
+	if got := resp.Header().Get("X-Custom"); got != "foo" {
+		t.Fatalf("unexpected X-Custom header: got %q, want %q", got, "foo")
+	}
+}
+
+func TestInvokeAutoJSON(t *testing.T) {
+	for i, tc := range []struct {
+		name        string
+		function    string
+		statusCode  int
+		body        string
+		contentType string
+	}{
+		{
+			name:        "test data dict is json body",
+			function:    "data_handler",
+			statusCode:  http.StatusOK,
+			body:        `{"name": "world", "items": [1, 2]}`,
+			contentType: "application/json",
+		},
+		{
+			name:       "test dict with status code is envelope",
+			function:   "envelope_handler",
+			statusCode: http.StatusCreated,
+			body:       "created",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name auto_json
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/auto_json/app/index.py
+				function `+tc.function+`
+				auto_json
+			}`)
+			defer fex.Cleanup()
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			if string(resp.body) != tc.body {
+				t.Fatalf("unexpected body: got %q, want %q", resp.body, tc.body)
+			}
+			if got := resp.Header().Get("Content-Type"); got != tc.contentType {
+				t.Fatalf("unexpected Content-Type header: got %q, want %q", got, tc.contentType)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
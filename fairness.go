@@ -0,0 +1,91 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"sync"
+	"time"
+)
+
+// fairScheduler limits the concurrent invocations of the requests sharing
+// a fairness key, e.g. the tenant, to a fair share of the workers. The
+// share is the number of workers divided by the number of keys with
+// invocations in flight, rounded up. A key without competition may use all
+// workers, and a new key is always admitted.
+type fairScheduler struct {
+	mu       sync.Mutex
+	released *sync.Cond
+	capacity int
+	inFlight map[string]int
+}
+
+func newFairScheduler(capacity int) *fairScheduler {
+	s := &fairScheduler{
+		capacity: capacity,
+		inFlight: make(map[string]int),
+	}
+	s.released = sync.NewCond(&s.mu)
+	return s
+}
+
+// share returns the max number of concurrent invocations of a key. The
+// caller must hold the lock.
+func (s *fairScheduler) share() int {
+	keys := len(s.inFlight)
+	if keys == 0 {
+		return s.capacity
+	}
+	if share := (s.capacity + keys - 1) / keys; share > 1 {
+		return share
+	}
+	return 1
+}
+
+// acquire waits until the invocations of the key are below its share, for
+// up to the timeout, and counts the invocation of the key in flight.
+func (s *fairScheduler) acquire(key string, timeout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired bool
+	if s.inFlight[key] >= s.share() {
+		timer := time.AfterFunc(timeout, func() {
+			s.mu.Lock()
+			expired = true
+			s.mu.Unlock()
+			s.released.Broadcast()
+		})
+		defer timer.Stop()
+	}
+	for s.inFlight[key] >= s.share() {
+		if expired {
+			return errFairShareLimit
+		}
+		s.released.Wait()
+	}
+	s.inFlight[key]++
+	return nil
+}
+
+// release completes the invocation of the key and wakes up the requests
+// waiting for their share.
+func (s *fairScheduler) release(key string) {
+	s.mu.Lock()
+	s.inFlight[key]--
+	if s.inFlight[key] <= 0 {
+		delete(s.inFlight, key)
+	}
+	s.mu.Unlock()
+	s.released.Broadcast()
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFairScheduler(t *testing.T) {
+	s := newFairScheduler(4)
+
+	// A key without competition may use all workers.
+	for i := 0; i < 4; i++ {
+		if err := s.acquire("noisy", time.Second); err != nil {
+			t.Fatalf("unexpected acquire() error: %v", err)
+		}
+	}
+	if err := s.acquire("noisy", 10*time.Millisecond); !errors.Is(err, errFairShareLimit) {
+		t.Fatalf("unexpected acquire() error: got %v, want %v", err, errFairShareLimit)
+	}
+
+	// A new key is admitted, and it halves the share of the other key.
+	if err := s.acquire("quiet", 10*time.Millisecond); err != nil {
+		t.Fatalf("unexpected acquire() error: %v", err)
+	}
+	if got := s.share(); got != 2 {
+		t.Fatalf("unexpected share: got %d, want 2", got)
+	}
+	s.release("noisy")
+	s.release("noisy")
+	if err := s.acquire("noisy", 10*time.Millisecond); !errors.Is(err, errFairShareLimit) {
+		t.Fatalf("unexpected acquire() error: got %v, want %v", err, errFairShareLimit)
+	}
+	if err := s.acquire("quiet", 10*time.Millisecond); err != nil {
+		t.Fatalf("unexpected acquire() error: %v", err)
+	}
+
+	// The waiting request is admitted once the key is below its share.
+	acquired := make(chan error)
+	go func() {
+		acquired <- s.acquire("noisy", time.Second)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.release("noisy")
+	if err := <-acquired; err != nil {
+		t.Fatalf("unexpected acquire() error: %v", err)
+	}
+}
+
+func TestFunctionExecutorFairness(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name slow
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/slow/app/index.py
+		function handler
+		workers 2
+		fairness_key X-Tenant-Id
+	}`)
+	defer fex.Cleanup()
+
+	invoke := func(tenant, uri string) time.Duration {
+		start := time.Now()
+		req := newRequest(t, "GET", uri)
+		req.Header.Set("X-Tenant-Id", tenant)
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, req); err != nil {
+			t.Errorf("unexpected invoke() error: %v", err)
+		}
+		if resp.statusCode != http.StatusOK {
+			t.Errorf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusOK)
+		}
+		return time.Since(start)
+	}
+
+	// The burst of the noisy tenant takes 1.5s to serve with 2 workers.
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			invoke("noisy", "/?sleep=0.5")
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	// The request of the quiet tenant is served when the first worker is
+	// released, ahead of the queued requests of the noisy tenant.
+	if d := invoke("quiet", "/?sleep=0"); d > time.Second {
+		t.Fatalf("quiet tenant request served after %s", d)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// hopByHopHeaders are the headers which a handler is never allowed to set.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// The connection hints returned by a handler, which set the Connection
+// header of the response.
+const (
+	// connectionClose closes the connection after the response.
+	connectionClose = "close"
+	// connectionKeepAlive keeps the connection open for reuse.
+	connectionKeepAlive = "keep-alive"
+)
+
+// isConnectionHint returns true when the value is a valid connection hint.
+func isConnectionHint(s string) bool {
+	return s == connectionClose || s == connectionKeepAlive
+}
+
+// The default values of the security headers.
+const (
+	defaultContentTypeOptions    = "nosniff"
+	defaultFrameOptions          = "DENY"
+	defaultContentSecurityPolicy = "default-src 'self'"
+)
+
+// securityHeaderOff disables the security header.
+const securityHeaderOff = "off"
+
+// SecurityHeaders holds the values of the security headers set on the
+// responses, unless the handler sets them. The empty values are set to
+// the defaults, and the headers set to off are not set.
+type SecurityHeaders struct {
+	ContentTypeOptions    string `json:"content_type_options,omitempty"`
+	FrameOptions          string `json:"frame_options,omitempty"`
+	ContentSecurityPolicy string `json:"content_security_policy,omitempty"`
+}
+
+func (sh *SecurityHeaders) setDefaults() {
+	if sh.ContentTypeOptions == "" {
+		sh.ContentTypeOptions = defaultContentTypeOptions
+	}
+	if sh.FrameOptions == "" {
+		sh.FrameOptions = defaultFrameOptions
+	}
+	if sh.ContentSecurityPolicy == "" {
+		sh.ContentSecurityPolicy = defaultContentSecurityPolicy
+	}
+}
+
+// headers returns the security headers, keyed by the header name.
+func (sh *SecurityHeaders) headers() http.Header {
+	h := make(http.Header)
+	for k, v := range map[string]string{
+		"X-Content-Type-Options":  sh.ContentTypeOptions,
+		"X-Frame-Options":         sh.FrameOptions,
+		"Content-Security-Policy": sh.ContentSecurityPolicy,
+	} {
+		if v == "" || v == securityHeaderOff {
+			continue
+		}
+		h.Set(k, v)
+	}
+	return h
+}
+
+func containsHeader(names []string, name string) bool {
+	for _, s := range names {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isResponseHeaderAllowed returns true when the handler is allowed to set
+// the response header. Hop-by-hop headers and the headers in the denylist
+// are dropped. If the allowlist is not empty, only the headers in the
+// allowlist pass through.
+func (fex *FunctionExecutor) isResponseHeaderAllowed(name string) bool {
+	if containsHeader(hopByHopHeaders, name) {
+		return false
+	}
+	if containsHeader(fex.ResponseHeaderDenylist, name) {
+		return false
+	}
+	if len(fex.ResponseHeaderAllowlist) > 0 {
+		return containsHeader(fex.ResponseHeaderAllowlist, name)
+	}
+	return true
+}
+
+// writeResponseHeaders adds the headers returned by the handler to the
+// response, subject to the response header policy.
+func (fex *FunctionExecutor) writeResponseHeaders(resp http.ResponseWriter, requestID string, headers http.Header) {
+	for k, values := range headers {
+		if !fex.isResponseHeaderAllowed(k) {
+			fex.logger.Debug(
+				"dropped response header set by lambda function",
+				zap.String("lambda_name", fex.Name),
+				zap.String("request_id", requestID),
+				zap.String("header", k),
+			)
+			continue
+		}
+		for _, v := range values {
+			resp.Header().Add(k, v)
+		}
+	}
+}
+
+// writeSecurityHeaders adds the security headers to the response, except
+// the ones already set, e.g. by the handler.
+func (fex *FunctionExecutor) writeSecurityHeaders(resp http.ResponseWriter) {
+	if fex.SecurityHeaders == nil {
+		return
+	}
+	for k, values := range fex.SecurityHeaders.headers() {
+		if resp.Header().Get(k) != "" {
+			continue
+		}
+		resp.Header()[k] = values
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestWriteResponseHeaders(t *testing.T) {
+	headers := http.Header{
+		"Connection":        []string{"close"},
+		"Transfer-Encoding": []string{"chunked"},
+		"X-Custom":          []string{"foo", "bar"},
+		"X-Frame-Options":   []string{"ALLOWALL"},
+	}
+	for i, tc := range []struct {
+		name      string
+		allowlist []string
+		denylist  []string
+		want      http.Header
+	}{
+		{
+			name: "test hop-by-hop headers are dropped by default",
+			want: http.Header{
+				"X-Custom":        []string{"foo", "bar"},
+				"X-Frame-Options": []string{"ALLOWALL"},
+			},
+		},
+		{
+			name:     "test denied headers are dropped",
+			denylist: []string{"x-frame-options"},
+			want: http.Header{
+				"X-Custom": []string{"foo", "bar"},
+			},
+		},
+		{
+			name:      "test only allowed headers pass through",
+			allowlist: []string{"X-Frame-Options", "Connection"},
+			want: http.Header{
+				"X-Frame-Options": []string{"ALLOWALL"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{
+				ResponseHeaderAllowlist: tc.allowlist,
+				ResponseHeaderDenylist:  tc.denylist,
+			}
+			fex.logger = initLogger(zapcore.DebugLevel)
+			resp := newResponseWriter(fex.logger)
+			fex.writeResponseHeaders(resp, "test-request-id", headers)
+			if diff := cmp.Diff(tc.want, resp.Header()); diff != "" {
+				t.Fatalf("unexpected headers mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorResponseHeaders(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name headers
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/headers/app/index.py
+		function handler
+		response_header_denylist X-Frame-Options
+	}`)
+	defer fex.Cleanup()
+
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.statusCode)
+	}
+	want := http.Header{
+		"Content-Length": []string{"2"},
+		"X-Custom":       []string{"foo", "bar"},
+	}
+	if diff := cmp.Diff(want, resp.Header()); diff != "" {
+		t.Fatalf("unexpected headers mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFunctionExecutorMultiValueResponseHeaders(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name headers
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/headers/app/index.py
+		function multi_value_handler
+	}`)
+	defer fex.Cleanup()
+
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.statusCode)
+	}
+	want := []string{"session=abc; Path=/; HttpOnly", "theme=dark; Path=/"}
+	if diff := cmp.Diff(want, resp.Header().Values("Set-Cookie")); diff != "" {
+		t.Fatalf("unexpected Set-Cookie headers mismatch (-want +got):\n%s", diff)
+	}
+	// The values of the names differing in case are merged, and the order
+	// of the values of a name is preserved.
+	want = []string{
+		"</style.css>; rel=preload; as=style",
+		"</app.js>; rel=preload; as=script",
+	}
+	var got []string
+	for _, v := range resp.Header().Values("Link") {
+		if v != "</font.woff2>; rel=preload; as=font" {
+			got = append(got, v)
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected Link headers mismatch (-want +got):\n%s", diff)
+	}
+	if n := len(resp.Header().Values("Link")); n != 3 {
+		t.Fatalf("unexpected number of Link headers: got %d, want 3", n)
+	}
+}
+
+func TestFunctionExecutorConnectionHint(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name headers
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/headers/app/index.py
+		function connection_handler
+	}`)
+	defer fex.Cleanup()
+
+	for i, tc := range []struct {
+		name       string
+		uri        string
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "test handler closes connection",
+			uri:        "/?connection=close",
+			statusCode: http.StatusOK,
+			want:       "close",
+		},
+		{
+			name:       "test handler keeps connection alive",
+			uri:        "/?connection=keep-alive",
+			statusCode: http.StatusOK,
+			want:       "keep-alive",
+		},
+		{
+			name:       "test handler without connection hint",
+			uri:        "/",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test handler with invalid connection hint",
+			uri:        "/?connection=upgrade",
+			statusCode: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", tc.uri)); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			if got := resp.Header().Get("Connection"); got != tc.want {
+				t.Fatalf("unexpected Connection header: got %q, want %q", got, tc.want)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorSecurityHeaders(t *testing.T) {
+	for i, tc := range []struct {
+		name   string
+		config string
+		want   http.Header
+	}{
+		{
+			name: "test default security headers with handler override",
+			config: `
+				security_headers`,
+			want: http.Header{
+				"Content-Length":          []string{"2"},
+				"Content-Security-Policy": []string{"default-src 'self'"},
+				"X-Content-Type-Options":  []string{"nosniff"},
+				"X-Custom":                []string{"foo", "bar"},
+				"X-Frame-Options":         []string{"ALLOWALL"},
+			},
+		},
+		{
+			name: "test security headers with handler header denied",
+			config: `
+				response_header_denylist X-Frame-Options
+				security_headers`,
+			want: http.Header{
+				"Content-Length":          []string{"2"},
+				"Content-Security-Policy": []string{"default-src 'self'"},
+				"X-Content-Type-Options":  []string{"nosniff"},
+				"X-Custom":                []string{"foo", "bar"},
+				"X-Frame-Options":         []string{"DENY"},
+			},
+		},
+		{
+			name: "test security headers overridden via options",
+			config: `
+				security_headers {
+					content_type_options off
+					content_security_policy "default-src 'none'"
+				}`,
+			want: http.Header{
+				"Content-Length":          []string{"2"},
+				"Content-Security-Policy": []string{"default-src 'none'"},
+				"X-Custom":                []string{"foo", "bar"},
+				"X-Frame-Options":         []string{"ALLOWALL"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name headers
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/headers/app/index.py
+				function handler`+tc.config+`
+			}`)
+			defer fex.Cleanup()
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != http.StatusOK {
+				t.Fatalf("unexpected status code: %d", resp.statusCode)
+			}
+			if diff := cmp.Diff(tc.want, resp.Header()); diff != "" {
+				t.Fatalf("unexpected headers mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
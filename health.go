@@ -0,0 +1,99 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// defaultHealthTimeout is the default max time the health function takes.
+const defaultHealthTimeout = 2 * time.Second
+
+// healthStatus holds the readiness of a function executor.
+type healthStatus struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Workers int    `json:"workers"`
+	// StatusCode and Body are returned by the health function, if any.
+	StatusCode int    `json:"status_code,omitempty"`
+	Body       string `json:"body,omitempty"`
+	// Error is the reason the function is not ready.
+	Error string `json:"error,omitempty"`
+}
+
+// startHealthWorker starts a lambda runtime process for the health function,
+// which is limited by the health timeout instead of the worker timeout.
+func (fex *FunctionExecutor) startHealthWorker() (*worker, error) {
+	w, err := fex.startWorker()
+	if err != nil {
+		return nil, err
+	}
+	w.timeout = time.Duration(fex.HealthTimeout)
+	return w, nil
+}
+
+// checkHealth returns the readiness of the function. The function is ready
+// when it has live workers, which are not crash looping, and the health
+// function, if configured, returns None or the 200 status code within the
+// health timeout.
+func (fex *FunctionExecutor) checkHealth() *healthStatus {
+	status := &healthStatus{Name: fex.Name}
+	if fex.CaptureStdout {
+		// The script runs per request, so there are no workers to check.
+		status.Ready = true
+		return status
+	}
+	status.Workers, _ = fex.workers.getCounts()
+	if status.Workers == 0 {
+		status.Error = "no live workers"
+		return status
+	}
+	if fex.crashLoop != nil && fex.crashLoop.isLooping() {
+		status.Error = "workers are crash looping"
+		return status
+	}
+	if fex.healthWorkers == nil {
+		status.Ready = true
+		return status
+	}
+
+	requestID := "health-" + uuid.New().String()
+	env := fex.newEnvelope(map[string]interface{}{"request_id": requestID})
+	r, err := fex.healthWorkers.exec(requestID, "", env, retryPolicy{})
+	if err != nil {
+		fex.logger.Warn(
+			"failed executing lambda health function",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.Error(err),
+		)
+		status.StatusCode = r.StatusCode
+		status.Error = err.Error()
+		return status
+	}
+	status.StatusCode = r.StatusCode
+	status.Body = string(r.Body)
+	if r.StatusCode != http.StatusOK {
+		status.Error = fmt.Sprintf("health function returned status code %d", r.StatusCode)
+		return status
+	}
+	status.Ready = true
+	return status
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddytest"
+)
+
+func TestIntegrationStatusCode(t *testing.T) {
+	// Caddy recognizes module maps by the json.RawMessage type name, which
+	// is an alias with the encoding/json v2 experiment.
+	if reflect.TypeOf(json.RawMessage{}).Name() != "RawMessage" {
+		t.Skip("caddy module loading requires GOEXPERIMENT=nojsonv2 with this toolchain")
+	}
+
+	tester := caddytest.NewTester(t)
+	tester.InitServer(`
+	{
+		admin localhost:2999
+		http_port 9080
+		https_port 9443
+		grace_period 1ns
+		order lambda before respond
+	}
+
+	http://localhost:9080 {
+		route /missing {
+			lambda {
+				name status
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/status/app/index.py
+				function not_found_handler
+			}
+		}
+		route /hello {
+			lambda {
+				name hello_world
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/hello_world/app/index.py
+				function handler
+			}
+		}
+	}`, "caddyfile")
+
+	tester.AssertGetResponse("http://localhost:9080/missing", http.StatusNotFound, "page not found: /missing")
+	req, err := http.NewRequest("GET", "http://localhost:9080/hello", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	resp := tester.AssertResponseCode(req, http.StatusOK)
+	defer resp.Body.Close()
+
+	// The server of the site block is named by the Caddyfile adapter.
+	var got struct {
+		Event map[string]interface{} `json:"event"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected response body: %v", err)
+	}
+	if got.Event["server_name"] != "srv0" {
+		t.Fatalf("unexpected server_name: %v", got.Event["server_name"])
+	}
+}
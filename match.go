@@ -0,0 +1,83 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+)
+
+// RequestMatch holds the conditions a request must meet for the function
+// to be invoked. The requests not meeting them are passed to the next
+// handler in the route.
+type RequestMatch struct {
+	// Methods is the set of the accepted request methods, e.g. GET.
+	Methods []string `json:"methods,omitempty"`
+	// Headers are the header conditions, all of which must be met.
+	Headers []*HeaderMatch `json:"headers,omitempty"`
+}
+
+// HeaderMatch is a request header condition.
+type HeaderMatch struct {
+	// Name is the name of the header.
+	Name string `json:"name"`
+	// Value, when not empty, must be equal to one of the values of the
+	// header. Otherwise, the header must be present.
+	Value string `json:"value,omitempty"`
+}
+
+// matches returns true when the request meets all the conditions.
+func (m *RequestMatch) matches(req *http.Request) bool {
+	if len(m.Methods) > 0 {
+		var found bool
+		for _, method := range m.Methods {
+			if req.Method == method {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, h := range m.Headers {
+		values, ok := req.Header[http.CanonicalHeaderKey(h.Name)]
+		if !ok {
+			return false
+		}
+		if h.Value == "" {
+			continue
+		}
+		var found bool
+		for _, v := range values {
+			if v == h.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// isRequestMatched returns true when the function is to be invoked for the
+// request, i.e. the match conditions, if any, are met.
+func (fex *FunctionExecutor) isRequestMatched(req *http.Request) bool {
+	if fex.Match == nil {
+		return true
+	}
+	return fex.Match.matches(req)
+}
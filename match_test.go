@@ -0,0 +1,144 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestRequestMatch(t *testing.T) {
+	m := &RequestMatch{
+		Methods: []string{"GET", "POST"},
+		Headers: []*HeaderMatch{
+			{Name: "X-Api-Key"},
+			{Name: "x-tenant", Value: "acme"},
+		},
+	}
+	for i, tc := range []struct {
+		name    string
+		method  string
+		headers map[string][]string
+		want    bool
+	}{
+		{
+			name:    "test matching request",
+			method:  "GET",
+			headers: map[string][]string{"X-Api-Key": {""}, "X-Tenant": {"acme"}},
+			want:    true,
+		},
+		{
+			name:    "test header value among multiple values",
+			method:  "POST",
+			headers: map[string][]string{"X-Api-Key": {"foo"}, "X-Tenant": {"contoso", "acme"}},
+			want:    true,
+		},
+		{
+			name:    "test method not in set",
+			method:  "DELETE",
+			headers: map[string][]string{"X-Api-Key": {"foo"}, "X-Tenant": {"acme"}},
+		},
+		{
+			name:    "test header absent",
+			method:  "GET",
+			headers: map[string][]string{"X-Tenant": {"acme"}},
+		},
+		{
+			name:    "test header value not equal",
+			method:  "GET",
+			headers: map[string][]string{"X-Api-Key": {"foo"}, "X-Tenant": {"ACME"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newRequest(t, tc.method, "/")
+			for k, values := range tc.headers {
+				for _, v := range values {
+					req.Header.Add(k, v)
+				}
+			}
+			if got := m.matches(req); got != tc.want {
+				t.Fatalf("unexpected matches() result: got %t, want %t", got, tc.want)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorMatch(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		match {
+			method get
+			header X-Tenant acme
+		}
+	}`)
+	defer fex.Cleanup()
+
+	for i, tc := range []struct {
+		name       string
+		method     string
+		tenant     string
+		nextCalled bool
+		statusCode int
+	}{
+		{
+			name:       "test matching request invokes function",
+			method:     "GET",
+			tenant:     "acme",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test non-matching method calls next handler",
+			method:     "POST",
+			tenant:     "acme",
+			nextCalled: true,
+		},
+		{
+			name:       "test non-matching header calls next handler",
+			method:     "GET",
+			tenant:     "contoso",
+			nextCalled: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newRequest(t, tc.method, "/")
+			req.Header.Set("X-Tenant", tc.tenant)
+			resp := newResponseWriter(fex.logger)
+
+			var nextCalled bool
+			next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				nextCalled = true
+				return nil
+			})
+			if err := fex.ServeHTTP(resp, req, next); err != nil {
+				t.Fatalf("unexpected ServeHTTP() error: %v", err)
+			}
+			if nextCalled != tc.nextCalled {
+				t.Fatalf("unexpected next handler call: got %t, want %t", nextCalled, tc.nextCalled)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var lambdaMetrics = struct {
+	init          sync.Once
+	handlerCPU    *prometheus.HistogramVec
+	handlerMaxRSS *prometheus.GaugeVec
+	// handlerDuration has the cold label, which is true for the first
+	// invocation of the handler by a worker.
+	handlerDuration *prometheus.HistogramVec
+	queueDepth      *prometheus.GaugeVec
+	queueWait       *prometheus.HistogramVec
+	// breakerState is 0 when the circuit is closed, 1 when it is open,
+	// and 2 when it is half-open.
+	breakerState *prometheus.GaugeVec
+}{}
+
+func initLambdaMetrics() {
+	const ns, sub = "caddy", "lambda"
+	labels := []string{"lambda_name"}
+
+	lambdaMetrics.handlerCPU = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "handler_cpu_seconds",
+		Help:      "CPU time (user and system) consumed by function handler invocations.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, labels)
+	lambdaMetrics.handlerMaxRSS = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "handler_max_rss_kilobytes",
+		Help:      "Peak resident set size of the worker reported by the last function handler invocation.",
+	}, labels)
+	lambdaMetrics.handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "handler_duration_seconds",
+		Help:      "Latency of function handler invocations, by cold or warm start of the worker.",
+		Buckets:   prometheus.DefBuckets,
+	}, append(labels, "cold"))
+	lambdaMetrics.queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "queue_depth",
+		Help:      "Number of requests waiting for an invocation slot or an available worker.",
+	}, labels)
+	lambdaMetrics.queueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "queue_wait_seconds",
+		Help:      "Time requests waited for an invocation slot or an available worker.",
+		Buckets:   prometheus.DefBuckets,
+	}, labels)
+	lambdaMetrics.breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker of the function, i.e. 0 for closed, 1 for open, and 2 for half-open.",
+	}, labels)
+}
+
+// observeStats records the resource usage of a function invocation.
+func (fex *FunctionExecutor) observeStats(stats *workerStats) {
+	if stats == nil {
+		return
+	}
+	lambdaMetrics.init.Do(initLambdaMetrics)
+	cpu := (stats.UserCPUMillis + stats.SystemCPUMillis) / 1000
+	lambdaMetrics.handlerCPU.WithLabelValues(fex.Name).Observe(cpu)
+	lambdaMetrics.handlerMaxRSS.WithLabelValues(fex.Name).Set(float64(stats.MaxRSSKilobytes))
+}
+
+// observeDuration records the latency of a function invocation.
+func (fex *FunctionExecutor) observeDuration(r *workerResponse, d time.Duration) {
+	lambdaMetrics.init.Do(initLambdaMetrics)
+	lambdaMetrics.handlerDuration.WithLabelValues(fex.Name, strconv.FormatBool(r.Cold)).Observe(d.Seconds())
+}
+
+// observeQueued records a request entering the queue of the function.
+func observeQueued(name string) {
+	lambdaMetrics.init.Do(initLambdaMetrics)
+	lambdaMetrics.queueDepth.WithLabelValues(name).Inc()
+}
+
+// observeDequeued records a request leaving the queue of the function after
+// waiting since the time it entered the queue.
+func observeDequeued(name string, since time.Time) {
+	lambdaMetrics.init.Do(initLambdaMetrics)
+	lambdaMetrics.queueDepth.WithLabelValues(name).Dec()
+	lambdaMetrics.queueWait.WithLabelValues(name).Observe(time.Since(since).Seconds())
+}
+
+// observeBreakerState records the state of the circuit breaker.
+func observeBreakerState(name string, state breakerState) {
+	lambdaMetrics.init.Do(initLambdaMetrics)
+	lambdaMetrics.breakerState.WithLabelValues(name).Set(float64(state))
+}
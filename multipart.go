@@ -0,0 +1,110 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+// multipartPart is a part of a multipart stream yielded by the handler.
+type multipartPart struct {
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers"`
+	Body        []byte            `json:"body"`
+}
+
+// multipartWriter writes the parts of a multipart/x-mixed-replace stream
+// to the client, e.g. the frames of a camera feed.
+type multipartWriter struct {
+	resp    http.ResponseWriter
+	mw      *multipart.Writer
+	started bool
+}
+
+func newMultipartWriter(resp http.ResponseWriter) *multipartWriter {
+	return &multipartWriter{resp: resp, mw: multipart.NewWriter(resp)}
+}
+
+func (mw *multipartWriter) mode() string         { return "multipart" }
+func (mw *multipartWriter) startMarker() string  { return "CMD_MULTIPART_START=" }
+func (mw *multipartWriter) recordMarker() string { return "CMD_MULTIPART_PART=" }
+func (mw *multipartWriter) isStarted() bool      { return mw.started }
+
+// start writes the headers of the multipart stream.
+func (mw *multipartWriter) start(string) error {
+	h := mw.resp.Header()
+	h.Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.mw.Boundary())
+	h.Set("Cache-Control", "no-cache")
+	h.Del("Content-Length")
+	mw.resp.WriteHeader(http.StatusOK)
+	mw.started = true
+	return mw.flush()
+}
+
+// writeRecord writes the JSON encoded part yielded by the handler. The body
+// of the part is base64 encoded.
+func (mw *multipartWriter) writeRecord(s string) error {
+	p := &multipartPart{}
+	if err := json.Unmarshal([]byte(s), p); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedStreamRecord, err)
+	}
+	return mw.writePart(p)
+}
+
+// writePart writes the part with its headers. When the handler does not
+// set the content type of the part, it is detected from the body.
+func (mw *multipartWriter) writePart(p *multipartPart) error {
+	h := make(textproto.MIMEHeader)
+	for k, v := range p.Headers {
+		h.Set(k, v)
+	}
+	if p.ContentType != "" {
+		h.Set("Content-Type", p.ContentType)
+	} else if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(p.Body))
+	}
+	h.Set("Content-Length", strconv.Itoa(len(p.Body)))
+	w, err := mw.mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(p.Body); err != nil {
+		return err
+	}
+	return mw.flush()
+}
+
+// writeKeepAlive does nothing, because a multipart stream has no comments.
+// The clients expect the next part instead.
+func (mw *multipartWriter) writeKeepAlive() error {
+	return nil
+}
+
+// end writes the closing boundary of the stream.
+func (mw *multipartWriter) end() error {
+	if err := mw.mw.Close(); err != nil {
+		return err
+	}
+	return mw.flush()
+}
+
+func (mw *multipartWriter) flush() error {
+	return http.NewResponseController(mw.resp).Flush()
+}
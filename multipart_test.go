@@ -0,0 +1,146 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func getMultipartReader(t *testing.T, resp *http.Response) *multipart.Reader {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("unexpected content type error: %v", err)
+	}
+	if mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("unexpected media type: %q", mediaType)
+	}
+	return multipart.NewReader(resp.Body, params["boundary"])
+}
+
+func TestFunctionExecutorMultipartStream(t *testing.T) {
+	_, srv := newTestServer(t, `
+	lambda {
+		name multipart_stream
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/multipart_stream/app/index.py
+		function handler
+		multipart_stream
+	}`)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	defer resp.Body.Close()
+	mr := getMultipartReader(t, resp)
+
+	type part struct {
+		ContentType   string
+		ContentLength string
+		Frame         string
+		Body          string
+	}
+	var got []part
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected part error: %v", err)
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		got = append(got, part{
+			ContentType:   p.Header.Get("Content-Type"),
+			ContentLength: p.Header.Get("Content-Length"),
+			Frame:         p.Header.Get("X-Frame"),
+			Body:          string(b),
+		})
+	}
+	want := []part{
+		{ContentType: "image/jpeg", ContentLength: "9", Body: "\xff\xd8frame-1"},
+		{ContentType: "text/plain", ContentLength: "7", Frame: "2", Body: "frame-2"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected parts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFunctionExecutorMultipartStreamPlainResponse(t *testing.T) {
+	_, srv := newTestServer(t, `
+	lambda {
+		name multipart_stream
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/multipart_stream/app/index.py
+		function plain_handler
+		multipart_stream
+	}`)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(b) != `{"message": "not a stream"}` {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode, b)
+	}
+}
+
+func TestFunctionExecutorMultipartStreamClientDisconnect(t *testing.T) {
+	fex, srv := newTestServer(t, `
+	lambda {
+		name multipart_stream
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/multipart_stream/app/index.py
+		function infinite_handler
+		multipart_stream
+	}`)
+	w := fex.workers.getWorkers()[0]
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	mr := getMultipartReader(t, resp)
+	for i := 0; i < 2; i++ {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("unexpected part error: %v", err)
+		}
+		io.ReadAll(p)
+	}
+	resp.Body.Close()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		if fex.workers.getWorkers()[0] != w {
+			return
+		}
+	}
+	t.Fatalf("worker streaming to the disconnected client was not replaced")
+}
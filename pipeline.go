@@ -0,0 +1,108 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// PipelineStep is a handler invoked with the response of the previous
+// handler of the pipeline.
+type PipelineStep struct {
+	// EntrypointPath is the path to the entrypoint of the step. Defaults
+	// to the entrypoint of the function.
+	EntrypointPath string `json:"entrypoint_path,omitempty"`
+	// EntrypointHandler is the name of the function invoked at the
+	// entrypoint.
+	EntrypointHandler string `json:"entrypoint_handler,omitempty"`
+}
+
+// newPipelineHandlers returns the handlers of the pipeline steps, which are
+// invoked by the workers of the function.
+func (fex *FunctionExecutor) newPipelineHandlers() []*handlerSpec {
+	var handlers []*handlerSpec
+	for _, step := range fex.Pipeline {
+		handlers = append(handlers, &handlerSpec{
+			lambdaName:       fex.Name,
+			importedPath:     getEntrypointImport(step.EntrypointPath),
+			handlerName:      step.EntrypointHandler,
+			signature:        fex.HandlerSignature,
+			unpacked:         fex.CallStyle == callStyleUnpacked,
+			escapeHTML:       fex.EscapeHTML,
+			decodeBody:       fex.BodyType != "",
+			resultKeys:       fex.getResultKeys(),
+			partialOnTimeout: fex.PartialOnTimeout,
+			autoJSON:         fex.AutoJSON,
+			requireOutput:    fex.RequireOutput,
+		})
+	}
+	return handlers
+}
+
+// execPipeline invokes the pipeline steps in order. Each step receives the
+// request data with the body replaced by the body of the previous response,
+// and the status code and the headers of the previous response in the
+// response key. The pipeline stops at the response with a non-2xx status
+// code, which is returned as is.
+func (fex *FunctionExecutor) execPipeline(method, stickyKey string, data map[string]interface{}, r *workerResponse) (*workerResponse, error) {
+	requestID := data["request_id"].(string)
+	for i, handler := range fex.pipeline {
+		if r.StatusCode < 200 || r.StatusCode > 299 {
+			fex.logger.Debug(
+				"stopped lambda function pipeline",
+				zap.String("lambda_name", fex.Name),
+				zap.String("request_id", requestID),
+				zap.Int("step", i+1),
+				zap.Int("status_code", r.StatusCode),
+			)
+			return r, nil
+		}
+		stepData := make(map[string]interface{}, len(data)+2)
+		for k, v := range data {
+			stepData[k] = v
+		}
+		stepData["body"], stepData["is_base64_encoded"] = fex.encodeRequestBody(r.Headers.Get("Content-Type"), r.Body)
+		stepData["response"] = map[string]interface{}{
+			"status_code": r.StatusCode,
+			"headers":     flattenHeaders(r.Headers),
+		}
+		var err error
+		r, err = fex.workers.execHandler(handler, requestID, stickyKey, fex.newEnvelope(stepData), fex.getRetryPolicy(method))
+		if err == nil && r.BodyFile != "" {
+			err = fex.readBodyFile(r)
+		}
+		if err != nil {
+			return r, fmt.Errorf("pipeline step %d %s: %w", i+1, handler.handlerName, err)
+		}
+	}
+	return r, nil
+}
+
+// flattenHeaders returns the headers with the single values as strings, as
+// the headers of the request data.
+func flattenHeaders(headers http.Header) map[string]interface{} {
+	m := make(map[string]interface{}, len(headers))
+	for k, v := range headers {
+		if len(v) == 1 {
+			m[k] = v[0]
+		} else {
+			m[k] = v
+		}
+	}
+	return m
+}
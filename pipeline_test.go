@@ -0,0 +1,80 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFunctionExecutorPipeline(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name pipeline
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/pipeline/app/index.py
+		function parse
+		body_type auto
+		pipeline {
+			step render
+		}
+	}`)
+	defer fex.Cleanup()
+
+	for i, tc := range []struct {
+		name        string
+		body        string
+		statusCode  int
+		want        string
+		pipelineHdr string
+	}{
+		{
+			name:        "test parse step transforms data for render step",
+			body:        `{"name": " jane doe "}`,
+			statusCode:  http.StatusOK,
+			want:        "Hello, Jane Doe!",
+			pipelineHdr: "application/json",
+		},
+		{
+			name:       "test parse step short-circuits pipeline",
+			body:       `{}`,
+			statusCode: http.StatusBadRequest,
+			want:       "name is required",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newRequest(t, "POST", "/")
+			req.Header.Set("Content-Type", "application/json")
+			req.Body = io.NopCloser(strings.NewReader(tc.body))
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, req); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			if string(resp.body) != tc.want {
+				t.Fatalf("unexpected body: got %q, want %q", resp.body, tc.want)
+			}
+			if got := resp.Header().Get("X-Pipeline-Input"); got != tc.pipelineHdr {
+				t.Fatalf("unexpected X-Pipeline-Input header: got %q, want %q", got, tc.pipelineHdr)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
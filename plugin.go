@@ -16,15 +16,22 @@ package lambda
 
 import (
 	"fmt"
+	"math/rand"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/sync/semaphore"
 )
 
 func init() {
@@ -42,20 +49,303 @@ type FunctionExecutor struct {
 	EntrypointPath string `json:"entrypoint_path,omitempty"`
 	// EntrypointHandler stores the name of the function to invoke at the Entrypoint. e.g handler.
 	EntrypointHandler string `json:"entrypoint_handler,omitempty"`
+	// HandlerSignature stores the arguments passed to the handler, i.e.
+	// single for handler(event), or event_context for handler(event, context).
+	// Defaults to single.
+	HandlerSignature string `json:"handler_signature,omitempty"`
+	// CallStyle stores how the request data is passed to the handler, i.e.
+	// dict for handler(event), or unpacked for the request fields passed as
+	// keyword arguments, e.g. handler(method, path, headers, body). Defaults
+	// to dict.
+	CallStyle string `json:"call_style,omitempty"`
 	// PythonExecutable stores the path to the python executable.
 	PythonExecutable string `json:"python_executable,omitempty"`
+	// PythonExecutableCandidates stores the paths to the python executables
+	// tried in order. The first one found is used as the python executable.
+	PythonExecutableCandidates []string `json:"python_executable_candidates,omitempty"`
+	// PythonPath stores the directories prepended to the module search path
+	// of the python executable, e.g. a directory with vendored dependencies.
+	PythonPath []string `json:"python_path,omitempty"`
+	// IOEncoding stores the encoding of the stdio of the python executable,
+	// passed in PYTHONIOENCODING. Defaults to utf-8.
+	IOEncoding string `json:"io_encoding,omitempty"`
 	// MaxWorkersCount stores the max number of concurrent runtimes.
 	MaxWorkersCount uint `json:"workers,omitempty"`
 	// WorkerTimeout stores the maximum number of seconds a function would run.
 	WorkerTimeout int `json:"worker_timeout,omitempty"`
+	// ImportTimeout stores the max time the import of the entrypoint by a
+	// worker takes, separately from the worker timeout of the requests.
+	// Defaults to twice the worker timeout.
+	ImportTimeout caddy.Duration `json:"import_timeout,omitempty"`
+	// WriteTimeout stores the max time writing a request to a worker takes,
+	// separately from the worker timeout of the handler. On timeout, the
+	// worker is replaced and the request is retried on another worker.
+	// Defaults to the worker timeout.
+	WriteTimeout caddy.Duration `json:"write_timeout,omitempty"`
+	// MaxWorkerAge stores the max lifetime of a worker. The worker older
+	// than that is replaced once it completes the request in flight, e.g.
+	// to pick up the credentials rotated since the entrypoint was imported.
+	// Up to 10% of jitter is added per worker, so that the workers started
+	// together are not replaced together. If zero, the workers are replaced
+	// on failure only.
+	MaxWorkerAge caddy.Duration `json:"max_worker_age,omitempty"`
+	// MaxTotalDuration stores the max time a handler runs. Unlike the
+	// worker timeout, which is reset by every line the handler prints, it
+	// is not extended, so that the handler printing periodically cannot
+	// hold the worker indefinitely. On timeout, the worker is replaced. If
+	// zero, the time is not limited.
+	MaxTotalDuration caddy.Duration `json:"max_total_duration,omitempty"`
+	// ProcessPriority stores the nice value of the workers, from -20, the
+	// highest priority, to 19, the lowest, e.g. to keep the CPU-heavy
+	// handlers from starving the server. Supported on Linux only. If zero,
+	// the workers inherit the priority of the server.
+	ProcessPriority int `json:"process_priority,omitempty"`
+	// PassCookieHeader instructs the plugin to include the raw Cookie header
+	// in the headers passed to the function, in addition to the parsed cookies.
+	PassCookieHeader bool `json:"pass_cookie_header,omitempty"`
+	// RequestIDFormat stores the format of the generated request ids, i.e.
+	// uuid, hex, or ulid. Defaults to uuid.
+	RequestIDFormat string `json:"request_id_format,omitempty"`
+	// ErrorFormat stores the format of plugin-level error responses,
+	// i.e. json or text. Defaults to text.
+	ErrorFormat string `json:"error_format,omitempty"`
+	// IncludeFields stores the list of request fields passed to the function.
+	// If empty, all supported fields are passed.
+	IncludeFields []string `json:"include,omitempty"`
+	// FallbackEntrypointPath stores the path to the fallback function's
+	// entrypoint. Defaults to EntrypointPath.
+	FallbackEntrypointPath string `json:"fallback_entrypoint_path,omitempty"`
+	// FallbackEntrypointHandler stores the name of the function invoked when
+	// the primary handler fails or times out.
+	FallbackEntrypointHandler string `json:"fallback_entrypoint_handler,omitempty"`
+	// StatusKey, BodyKey, and HeadersKey store the keys of the status code,
+	// body, and headers in the response returned by the handler. Default to
+	// status_code, body, and headers.
+	StatusKey  string `json:"status_key,omitempty"`
+	BodyKey    string `json:"body_key,omitempty"`
+	HeadersKey string `json:"headers_key,omitempty"`
+	// AutoJSON instructs the plugin to respond with the dict returned by
+	// the handler without the status code as the JSON body.
+	AutoJSON bool `json:"auto_json,omitempty"`
+	// RequireOutput instructs the plugin to fail the invocation, whose
+	// output holds no status code, e.g. when the handler ends the output
+	// early, with 500, instead of responding with 200 and an empty body.
+	RequireOutput bool `json:"require_output,omitempty"`
+	// PartialOnTimeout instructs the plugin to respond with 206 and the
+	// output the handler produced before it timed out, instead of failing.
+	PartialOnTimeout bool `json:"partial_on_timeout,omitempty"`
+	// CircuitBreaker stores the config of the circuit breaker of the
+	// function. If nil, the circuit breaker is disabled.
+	CircuitBreaker *CircuitBreaker `json:"circuit_breaker,omitempty"`
+	// RateLimit stores the config of the rate limit of the function. If nil,
+	// the requests are not limited.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+	// CrashLoop stores the config of the crash loop detection of the
+	// function. If nil, the restarts of the crashed workers are not tracked.
+	CrashLoop *CrashLoop `json:"crash_loop,omitempty"`
+	// AfterEntrypointHandler stores the name of the function in the
+	// entrypoint invoked after the response is sent, e.g. for audit logging.
+	// It receives the request data and the summary of the response.
+	AfterEntrypointHandler string `json:"after_entrypoint_handler,omitempty"`
+	// HealthEntrypointHandler stores the name of the function in the
+	// entrypoint invoked by the health endpoint, e.g. to check the database
+	// the function depends on. The function is ready when it returns None
+	// or the 200 status code.
+	HealthEntrypointHandler string `json:"health_entrypoint_handler,omitempty"`
+	// HealthTimeout stores the max time the health function takes. Defaults
+	// to 2s.
+	HealthTimeout caddy.Duration `json:"health_timeout,omitempty"`
+	// ShutdownEntrypointHandler stores the name of the function in the
+	// entrypoint invoked without arguments on each worker which imported the
+	// entrypoint, before the worker is terminated, e.g. to flush state or
+	// close connections.
+	ShutdownEntrypointHandler string `json:"shutdown_entrypoint_handler,omitempty"`
+	// ShutdownTimeout stores the max time the shutdown function takes.
+	// Defaults to 5s.
+	ShutdownTimeout caddy.Duration `json:"shutdown_timeout,omitempty"`
+	// ValidateOnStart instructs the plugin to invoke the handler with a
+	// synthetic request during provisioning and fail if the response does
+	// not conform to the handler contract.
+	ValidateOnStart bool `json:"validate_on_start,omitempty"`
+	// Selftest instructs the plugin to run a built-in handler through the
+	// worker protocol at startup, and to fail the startup when the response
+	// does not round-trip, e.g. the interpreter is incompatible.
+	Selftest bool `json:"selftest,omitempty"`
+	// WebSocket enables passing websocket messages to the function handler
+	// when a request asks for a websocket upgrade.
+	WebSocket bool `json:"websocket,omitempty"`
+	// WebSocketCrossOrigin allows the websocket upgrade requests with the
+	// Origin header of a host other than the requested one.
+	WebSocketCrossOrigin bool `json:"websocket_cross_origin,omitempty"`
+	// SSE enables forwarding the events yielded by a handler returning an
+	// iterator as server-sent events, when the client accepts them.
+	SSE bool `json:"sse,omitempty"`
+	// MultipartStream enables forwarding the parts yielded by a handler
+	// returning an iterator as a multipart/x-mixed-replace stream.
+	MultipartStream bool `json:"multipart_stream,omitempty"`
+	// ResponseRateLimit stores the max number of bytes per second written
+	// to the client of a stream. If zero, the rate is not limited.
+	ResponseRateLimit int64 `json:"response_rate_limit,omitempty"`
+	// FieldStyle stores the naming style of the request data keys passed to
+	// the function, i.e. snake or aws. Defaults to snake.
+	FieldStyle string `json:"field_style,omitempty"`
+	// EscapeHTML enables escaping the <, >, and & characters in the JSON
+	// encoded request data passed to the function. By default, they are
+	// passed verbatim.
+	EscapeHTML bool `json:"escape_html,omitempty"`
+	// PassthroughStatus allows a handler to return the informational 1xx
+	// status codes, e.g. 103 Early Hints, which are sent as interim
+	// responses. The status codes from 200 to 599 are always allowed.
+	PassthroughStatus bool `json:"passthrough_status,omitempty"`
+	// PassThrough instructs the plugin to store the function response in
+	// request variables and call the next handler, instead of writing
+	// the response.
+	PassThrough bool `json:"pass_through,omitempty"`
+	// CaptureStdout instructs the plugin to run the entrypoint as a fresh
+	// process per request, instead of invoking the function in a worker,
+	// and to respond with the standard output of the process.
+	CaptureStdout bool `json:"capture_stdout,omitempty"`
+	// MaxRetries stores the max number of times a request is dispatched to
+	// another worker after a worker failure. Defaults to 1. It is a pointer,
+	// so that 0, i.e. no retries, is told apart from the unset value.
+	MaxRetries *uint `json:"max_retries,omitempty"`
+	// ForceRetry allows retrying requests with non-idempotent methods
+	// after the worker crashed while processing them.
+	ForceRetry bool `json:"force_retry,omitempty"`
+	// Isolation stores the reuse of workers between requests, i.e. shared
+	// for long-lived workers, or per_request for replacing the worker after
+	// each request. Defaults to shared.
+	Isolation string `json:"isolation,omitempty"`
+	// StickyHeader stores the name of the request header whose value, e.g.
+	// a session token, pins the requests to a worker. The request is sent
+	// to another worker when the pinned worker is busy.
+	StickyHeader string `json:"sticky_header,omitempty"`
+	// FairnessKey stores the name of the header, e.g. the tenant id, whose
+	// values share the workers fairly. The concurrent invocations of a value
+	// are limited to the number of workers divided by the number of values
+	// with invocations in flight.
+	FairnessKey string `json:"fairness_key,omitempty"`
+	// MaxTotalWorkers stores the max number of workers of all functions in
+	// the config. If zero, the number is not limited.
+	MaxTotalWorkers uint `json:"max_total_workers,omitempty"`
+	// DispatchTimeout stores the max time a request waits for an available
+	// worker when all workers are busy. If zero, the request waits until a
+	// worker is available.
+	DispatchTimeout caddy.Duration `json:"dispatch_timeout,omitempty"`
+	// MaxQueue stores the max number of requests waiting for an available
+	// worker. The requests over the limit are rejected with 503 immediately.
+	// If zero, the number is not limited.
+	MaxQueue uint `json:"max_queue,omitempty"`
+	// MaxConcurrency stores the max number of concurrent invocations of the
+	// function, independent of the number of workers. If zero, the number
+	// of invocations is limited by the number of workers only.
+	MaxConcurrency uint `json:"max_concurrency,omitempty"`
+	// QueueTimeout stores the max time a request waits for an invocation
+	// slot when max_concurrency is reached, or for the fair share of its
+	// fairness_key. Defaults to worker timeout.
+	QueueTimeout caddy.Duration `json:"queue_timeout,omitempty"`
+	// LogSample stores the fraction of invocations, from 0 to 1, for which
+	// the invocation and completion of the function are logged. Failures
+	// are always logged. If not set, all invocations are logged.
+	LogSample *float64 `json:"log_sample,omitempty"`
+	// ResponseHeaderAllowlist stores the names of the response headers
+	// a handler is allowed to set. If empty, all headers are allowed,
+	// except hop-by-hop headers and the headers in the denylist.
+	ResponseHeaderAllowlist []string `json:"response_header_allowlist,omitempty"`
+	// ResponseHeaderDenylist stores the names of the response headers
+	// a handler is not allowed to set.
+	ResponseHeaderDenylist []string `json:"response_header_denylist,omitempty"`
+	// SecurityHeaders stores the security headers set on the responses,
+	// unless the handler sets them. If nil, none are set.
+	SecurityHeaders *SecurityHeaders `json:"security_headers,omitempty"`
+	// Secrets stores the keys of the values loaded from Caddy's storage and
+	// passed to the function in the secrets field of the request data.
+	Secrets []string `json:"secrets,omitempty"`
+	// Vars stores the names of the placeholders resolved with Caddy's
+	// replacer and passed to the function in the vars field of the request
+	// data, e.g. http.request.tls.client.subject.
+	Vars []string `json:"vars,omitempty"`
+	// SecretsTTL stores the time the secrets are cached for. Defaults to 1m.
+	SecretsTTL caddy.Duration `json:"secrets_ttl,omitempty"`
+	// ETag instructs the plugin to compute the ETag header from the response
+	// body when the handler does not return one.
+	ETag bool `json:"etag,omitempty"`
+	// BodyFileDir stores the directory the files returned by a handler via
+	// body_file must reside in. The body files are not served, unless the
+	// directory is set.
+	BodyFileDir string `json:"body_file_dir,omitempty"`
+	// RedirectSigningKey stores the HMAC key signing the URLs returned by a
+	// handler via redirect_signed. The key is never passed to the handler.
+	RedirectSigningKey string `json:"redirect_signing_key,omitempty"`
+	// BodyTransport stores how the request body is passed to the handler,
+	// i.e. json for the base64 encoded body field of the request data, or
+	// fd for the raw bytes read from file descriptor 3 of the worker.
+	// Defaults to json.
+	BodyTransport string `json:"body_transport,omitempty"`
+	// MaxBodySize stores the max size of the request body in bytes. Larger
+	// requests are rejected with 413. Defaults to 10MB.
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+	// AcceptContentTypes stores the media types of the request bodies the
+	// function accepts, e.g. application/json or text/*. The requests with
+	// a body of another type are rejected with 415. If empty, all types are
+	// accepted.
+	AcceptContentTypes []string `json:"accept_content_types,omitempty"`
+	// DecompressRequest enables decompressing the gzip and deflate encoded
+	// request bodies before they are passed to the handler. The max_body_size
+	// limits the decompressed size.
+	DecompressRequest bool `json:"decompress_request,omitempty"`
+	// BodyType stores the type of the body received by the handler, i.e.
+	// bytes, str, or auto for str when the content type is text and bytes
+	// otherwise. By default, the body is base64 encoded.
+	BodyType string `json:"body_type,omitempty"`
+	// RequestTransforms stores the built-in transforms applied to the request
+	// data in order, e.g. host_to_tenant, before it is passed to the handler.
+	RequestTransforms []*RequestTransform `json:"request_transforms,omitempty"`
+	// ResponseMode stores how the response of the handler is written, i.e.
+	// buffer, or stream for the body written in chunks as it arrives from
+	// the worker. Defaults to buffer.
+	ResponseMode string `json:"response_mode,omitempty"`
+	// ResponseTransforms stores the built-in transforms applied to the
+	// response of the handler in order, e.g. cors, before it is written.
+	ResponseTransforms []*ResponseTransform `json:"response_transforms,omitempty"`
+	// Match holds the method and header conditions a request must meet
+	// for the function to be invoked. The requests not meeting them are
+	// passed to the next handler.
+	Match *RequestMatch `json:"match,omitempty"`
+	// Pipeline stores the handlers invoked in order after the function,
+	// each with the response of the previous one.
+	Pipeline []*PipelineStep `json:"pipeline,omitempty"`
 	// If URIFilter is not empty, then only the plugin
 	// intercepts only the pages matching the regular expression
 	// in the filter
-	URIFilter         string `json:"uri_filter,omitempty"`
-	filterURIPattern  *regexp.Regexp
-	logger            *zap.Logger
-	workers           []*worker
-	entrypointImport string
+	URIFilter                string `json:"uri_filter,omitempty"`
+	filterURIPattern         *regexp.Regexp
+	logger                   *zap.Logger
+	workers                  *workerPool
+	entrypointImport         string
+	fallbackWorkers          *workerPool
+	fallbackEntrypointImport string
+	afterWorkers             *workerPool
+	pipeline                 []*handlerSpec
+	healthWorkers            *workerPool
+	breaker                  *circuitBreaker
+	limiter                  *rateLimiter
+	crashLoop                *crashLoopDetector
+	// archivePaths are the absolute paths to the archives holding the
+	// entrypoints, e.g. bundle.zip of bundle.zip/app/index.py.
+	archivePaths []string
+	nextWorkerID uint32
+	concurrency  *semaphore.Weighted
+	scheduler    *fairScheduler
+	secrets      *secretCache
+	// requestTransforms are the instantiated RequestTransforms.
+	requestTransforms []requestTransformFunc
+	// responseTransforms are the instantiated ResponseTransforms.
+	responseTransforms []responseTransformFunc
+	// reaperDone stops the reapers of the worker pools, which are waited
+	// for with reapers.
+	reaperDone chan struct{}
+	reapers    *sync.WaitGroup
 }
 
 // CaddyModule returns the Caddy module information.
@@ -81,23 +371,339 @@ func (fex *FunctionExecutor) Provision(ctx caddy.Context) error {
 	}
 
 	if fex.entrypointImport == "" {
-		fex.entrypointImport = strings.ReplaceAll(fex.EntrypointPath, "/", ".")
-		if strings.HasSuffix(fex.entrypointImport, ".py") {
-			fex.entrypointImport = fex.entrypointImport[:len(fex.entrypointImport)-3]
-		}
+		fex.entrypointImport = getEntrypointImport(fex.EntrypointPath)
 	}
 
-	var workerID uint = 0
 	if fex.WorkerTimeout < 1 {
 		fex.WorkerTimeout = 60
 	}
-	timeout := time.Second * time.Duration(fex.WorkerTimeout)
 
-	w, err := newWorker(workerID, fex.PythonExecutable, []string{"-u", "-q", "-i"}, timeout, fex.logger)
+	if fex.ImportTimeout <= 0 {
+		fex.ImportTimeout = caddy.Duration(2 * time.Second * time.Duration(fex.WorkerTimeout))
+	}
+
+	if fex.WriteTimeout <= 0 {
+		fex.WriteTimeout = caddy.Duration(time.Second * time.Duration(fex.WorkerTimeout))
+	}
+
+	if fex.MaxWorkersCount == 0 {
+		fex.MaxWorkersCount = 1
+	}
+
+	if fex.MaxRetries == nil {
+		maxRetries := uint(1)
+		fex.MaxRetries = &maxRetries
+	}
+
+	if fex.Isolation == "" {
+		fex.Isolation = isolationShared
+	}
+
+	if fex.MaxConcurrency > 0 || fex.FairnessKey != "" {
+		if fex.QueueTimeout <= 0 {
+			fex.QueueTimeout = caddy.Duration(time.Second * time.Duration(fex.WorkerTimeout))
+		}
+	}
+	if fex.MaxConcurrency > 0 {
+		fex.concurrency = semaphore.NewWeighted(int64(fex.MaxConcurrency))
+	}
+	if fex.FairnessKey != "" {
+		fex.scheduler = newFairScheduler(int(fex.MaxWorkersCount))
+	}
+
+	if len(fex.Secrets) > 0 {
+		if fex.SecretsTTL <= 0 {
+			fex.SecretsTTL = caddy.Duration(defaultSecretsTTL)
+		}
+		fex.secrets = newSecretCache(ctx.Storage(), time.Duration(fex.SecretsTTL))
+	}
+
+	if fex.CircuitBreaker != nil {
+		fex.CircuitBreaker.setDefaults()
+		fex.breaker = newCircuitBreaker(fex.Name, fex.CircuitBreaker)
+	}
+	if fex.RateLimit != nil {
+		fex.limiter = newRateLimiter(fex.RateLimit)
+	}
+	if fex.CrashLoop != nil {
+		fex.crashLoop = newCrashLoopDetector(fex.Name, fex.CrashLoop, fex.logger)
+	}
+
+	if fex.SecurityHeaders != nil {
+		fex.SecurityHeaders.setDefaults()
+	}
+
+	if err := fex.provisionRequestTransforms(); err != nil {
+		return err
+	}
+	if err := fex.provisionResponseTransforms(); err != nil {
+		return err
+	}
+
+	if fex.BodyTransport == "" {
+		fex.BodyTransport = bodyTransportJSON
+	}
+
+	if fex.CallStyle == "" {
+		fex.CallStyle = callStyleDict
+	}
+
+	if fex.MaxBodySize <= 0 {
+		fex.MaxBodySize = defaultMaxBodySize
+	}
+
+	if len(fex.PythonExecutableCandidates) > 0 {
+		fp, err := resolveExecutable(fex.PythonExecutableCandidates)
+		if err != nil {
+			return fmt.Errorf("failed provisioning lambda %s: %v", fex.Name, err)
+		}
+		fex.PythonExecutable = fp
+	}
+
+	if fex.PythonExecutable == "" {
+		fex.PythonExecutable = "python"
+	}
+	if fex.IOEncoding == "" {
+		fex.IOEncoding = defaultIOEncoding
+	}
+	fex.PythonExecutable = caddy.NewReplacer().ReplaceAll(fex.PythonExecutable, "")
+
+	for i, dir := range fex.PythonPath {
+		fp, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve python_path %s: %v", dir, err)
+		}
+		fi, err := os.Stat(fp)
+		if err != nil {
+			return fmt.Errorf("failed to access python_path %s: %v", dir, err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("python_path %s is not a directory", dir)
+		}
+		fex.PythonPath[i] = fp
+	}
+
+	if err := checkPythonVersion(fex.PythonExecutable); err != nil {
+		return fmt.Errorf("failed provisioning lambda %s: %v", fex.Name, err)
+	}
+
+	if fex.FallbackEntrypointHandler != "" && fex.FallbackEntrypointPath == "" {
+		fex.FallbackEntrypointPath = fex.EntrypointPath
+	}
+	for _, step := range fex.Pipeline {
+		if step.EntrypointPath == "" {
+			step.EntrypointPath = fex.EntrypointPath
+		}
+	}
+
+	if err := fex.provisionArchives(); err != nil {
+		return fmt.Errorf("failed provisioning lambda %s: %v", fex.Name, err)
+	}
+
+	if err := fex.checkCallStyle(); err != nil {
+		return fmt.Errorf("failed provisioning lambda %s: %v", fex.Name, err)
+	}
+
+	if fex.Selftest {
+		if err := fex.selftest(); err != nil {
+			return fmt.Errorf("failed provisioning lambda %s: %v", fex.Name, err)
+		}
+	}
+
+	if err := registry.register(ctx.Context, fex); err != nil {
+		return fmt.Errorf("failed provisioning lambda %s: %v", fex.Name, err)
+	}
+
+	if fex.CaptureStdout {
+		// The entrypoint runs as a fresh process per request, so no
+		// workers are started.
+		return nil
+	}
+
+	fex.workers = newWorkerPool(&handlerSpec{
+		lambdaName:       fex.Name,
+		importedPath:     fex.entrypointImport,
+		handlerName:      fex.EntrypointHandler,
+		signature:        fex.HandlerSignature,
+		unpacked:         fex.CallStyle == callStyleUnpacked,
+		escapeHTML:       fex.EscapeHTML,
+		decodeBody:       fex.BodyType != "",
+		resultKeys:       fex.getResultKeys(),
+		partialOnTimeout: fex.PartialOnTimeout,
+		autoJSON:         fex.AutoJSON,
+		requireOutput:    fex.RequireOutput,
+	}, fex.startWorker, fex.logger)
+	fex.workers.dispatchTimeout = time.Duration(fex.DispatchTimeout)
+	fex.workers.recycle = fex.Isolation == isolationPerRequest
+	fex.workers.maxQueue = fex.MaxQueue
+	fex.workers.crashLoop = fex.crashLoop
+	if err := fex.workers.start(fex.MaxWorkersCount); err != nil {
+		return err
+	}
+	// The pipeline steps are invoked by the workers of the function.
+	fex.pipeline = fex.newPipelineHandlers()
+
+	if fex.FallbackEntrypointHandler != "" {
+		if fex.fallbackEntrypointImport == "" {
+			fex.fallbackEntrypointImport = getEntrypointImport(fex.FallbackEntrypointPath)
+		}
+		fex.fallbackWorkers = newWorkerPool(&handlerSpec{
+			lambdaName:       fex.Name,
+			importedPath:     fex.fallbackEntrypointImport,
+			handlerName:      fex.FallbackEntrypointHandler,
+			signature:        fex.HandlerSignature,
+			unpacked:         fex.CallStyle == callStyleUnpacked,
+			escapeHTML:       fex.EscapeHTML,
+			decodeBody:       fex.BodyType != "",
+			resultKeys:       fex.getResultKeys(),
+			partialOnTimeout: fex.PartialOnTimeout,
+			autoJSON:         fex.AutoJSON,
+			requireOutput:    fex.RequireOutput,
+		}, fex.startWorker, fex.logger)
+		fex.fallbackWorkers.dispatchTimeout = time.Duration(fex.DispatchTimeout)
+		fex.fallbackWorkers.recycle = fex.Isolation == isolationPerRequest
+		fex.fallbackWorkers.maxQueue = fex.MaxQueue
+		fex.fallbackWorkers.crashLoop = fex.crashLoop
+		if err := fex.fallbackWorkers.start(1); err != nil {
+			return err
+		}
+	}
+
+	if fex.AfterEntrypointHandler != "" {
+		fex.afterWorkers = newWorkerPool(&handlerSpec{
+			lambdaName:   fex.Name,
+			importedPath: fex.entrypointImport,
+			handlerName:  fex.AfterEntrypointHandler,
+			signature:    fex.HandlerSignature,
+			unpacked:     fex.CallStyle == callStyleUnpacked,
+			escapeHTML:   fex.EscapeHTML,
+			hook:         true,
+		}, fex.startWorker, fex.logger)
+		fex.afterWorkers.dispatchTimeout = time.Duration(fex.DispatchTimeout)
+		fex.afterWorkers.recycle = fex.Isolation == isolationPerRequest
+		fex.afterWorkers.maxQueue = fex.MaxQueue
+		fex.afterWorkers.crashLoop = fex.crashLoop
+		if err := fex.afterWorkers.start(1); err != nil {
+			return err
+		}
+	}
+
+	if fex.ShutdownEntrypointHandler != "" && fex.ShutdownTimeout <= 0 {
+		fex.ShutdownTimeout = caddy.Duration(defaultShutdownTimeout)
+	}
+
+	if fex.HealthEntrypointHandler != "" {
+		if fex.HealthTimeout <= 0 {
+			fex.HealthTimeout = caddy.Duration(defaultHealthTimeout)
+		}
+		fex.healthWorkers = newWorkerPool(&handlerSpec{
+			lambdaName:   fex.Name,
+			importedPath: fex.entrypointImport,
+			handlerName:  fex.HealthEntrypointHandler,
+			signature:    fex.HandlerSignature,
+			unpacked:     fex.CallStyle == callStyleUnpacked,
+			escapeHTML:   fex.EscapeHTML,
+			hook:         true,
+		}, fex.startHealthWorker, fex.logger)
+		fex.healthWorkers.dispatchTimeout = time.Duration(fex.HealthTimeout)
+		// The health function which hangs, e.g. on a dependency which is
+		// down, must not delay the subsequent checks.
+		fex.healthWorkers.recycleOnTimeout = true
+		fex.healthWorkers.crashLoop = fex.crashLoop
+		if err := fex.healthWorkers.start(1); err != nil {
+			return err
+		}
+	}
+
+	if fex.ValidateOnStart {
+		if err := fex.validateHandler(); err != nil {
+			return fmt.Errorf("failed validating lambda %s handler: %v", fex.Name, err)
+		}
+	}
+
+	if fex.MaxWorkerAge > 0 {
+		fex.startReapers()
+	}
+	return nil
+}
+
+// startReapers starts the reaper of each worker pool, which replaces the
+// idle workers older than max_worker_age. The reapers are stopped by
+// Cleanup.
+func (fex *FunctionExecutor) startReapers() {
+	interval := getReapInterval(time.Duration(fex.MaxWorkerAge))
+	fex.reaperDone = make(chan struct{})
+	fex.reapers = &sync.WaitGroup{}
+	for _, p := range fex.getWorkerPools() {
+		fex.reapers.Add(1)
+		go func(p *workerPool) {
+			defer fex.reapers.Done()
+			p.reap(interval, fex.reaperDone)
+		}(p)
+	}
+}
+
+// validateHandler invokes the handler with a synthetic request and returns
+// an error if the handler fails or returns a malformed response.
+func (fex *FunctionExecutor) validateHandler() error {
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
 	if err != nil {
-		return fmt.Errorf("failed starting lambda worker %d %s: %s", workerID, fex.Name, err)
+		return err
+	}
+	req.RequestURI = req.URL.RequestURI()
+	data := fex.buildRequestData(req, "validate-"+uuid.New().String())
+	if _, err := fex.execWorker(req.Method, "", "", fex.newEnvelope(data)); err != nil {
+		return err
+	}
+	fex.logger.Info(
+		"validated lambda handler",
+		zap.String("lambda_name", fex.Name),
+	)
+	return nil
+}
+
+// startWorker starts a lambda runtime process.
+func (fex *FunctionExecutor) startWorker() (*worker, error) {
+	workerID := uint(atomic.AddUint32(&fex.nextWorkerID, 1) - 1)
+	timeout := time.Second * time.Duration(fex.WorkerTimeout)
+	env := fex.getWorkerEnv()
+	var tmpDir string
+	if fex.Isolation == isolationPerRequest {
+		// The worker serves a single request, so the temporary files of
+		// the request are isolated in the directory of the worker.
+		dir, err := os.MkdirTemp("", "lambda-"+fex.Name+"-")
+		if err != nil {
+			return nil, fmt.Errorf("failed creating lambda worker %d %s temporary directory: %s", workerID, fex.Name, err)
+		}
+		tmpDir = dir
+		env = append(env, "TMPDIR="+tmpDir)
+	}
+	w, err := newWorker(workerID, fex.PythonExecutable, []string{"-u", "-q", "-i", "-c", pythonInteractiveSetup}, env, timeout, fex.BodyTransport == bodyTransportFD, fex.logger)
+	if err != nil {
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+		return nil, fmt.Errorf("failed starting lambda worker %d %s: %s", workerID, fex.Name, err)
+	}
+	w.tmpDir = tmpDir
+	if fex.ProcessPriority != 0 {
+		if err := setProcessPriority(w.Pid, fex.ProcessPriority); err != nil {
+			w.terminate()
+			return nil, fmt.Errorf("failed setting lambda worker %d %s priority: %s", workerID, fex.Name, err)
+		}
+	}
+	w.importTimeout = time.Duration(fex.ImportTimeout)
+	w.writeTimeout = time.Duration(fex.WriteTimeout)
+	w.maxAge = getWorkerMaxAge(time.Duration(fex.MaxWorkerAge))
+	w.maxDuration = time.Duration(fex.MaxTotalDuration)
+	if fex.ShutdownEntrypointHandler != "" {
+		w.shutdownHandler = &handlerSpec{
+			lambdaName:   fex.Name,
+			importedPath: fex.entrypointImport,
+			handlerName:  fex.ShutdownEntrypointHandler,
+		}
+		w.shutdownTimeout = time.Duration(fex.ShutdownTimeout)
 	}
-	fex.workers = append(fex.workers, w)
 
 	fex.logger.Info(
 		"started lambda runtime",
@@ -105,11 +711,115 @@ func (fex *FunctionExecutor) Provision(ctx caddy.Context) error {
 		zap.Uint("worker_id", workerID),
 		zap.Int("worker_pid", w.getProcessPid()),
 		zap.Int("worker_timeout", fex.WorkerTimeout),
+		zap.Duration("import_timeout", w.importTimeout),
+		zap.Duration("write_timeout", w.writeTimeout),
+		zap.Duration("max_age", w.maxAge),
+		zap.Duration("max_total_duration", w.maxDuration),
+		zap.Int("process_priority", fex.ProcessPriority),
 	)
-	return nil
+	return w, nil
+}
+
+// The range of the nice values of process_priority.
+const (
+	minProcessPriority = -20
+	maxProcessPriority = 19
+)
+
+// maxWorkerAgeJitter is the max fraction of max_worker_age added to the
+// lifetime of a worker.
+const maxWorkerAgeJitter = 0.1
+
+// getWorkerMaxAge returns the max age with the random jitter of a worker.
+func getWorkerMaxAge(maxAge time.Duration) time.Duration {
+	if maxAge <= 0 {
+		return 0
+	}
+	return maxAge + time.Duration(rand.Float64()*maxWorkerAgeJitter*float64(maxAge))
+}
+
+// minReapInterval is the min interval the idle workers are checked for
+// their age at.
+const minReapInterval = 10 * time.Millisecond
+
+// getReapInterval returns the interval the idle workers are checked for
+// their age at, so that a worker is replaced within the jitter of its age.
+func getReapInterval(maxAge time.Duration) time.Duration {
+	interval := time.Duration(maxWorkerAgeJitter * float64(maxAge))
+	if interval < minReapInterval {
+		return minReapInterval
+	}
+	return interval
+}
+
+// getWorkerEnv returns the environment of the lambda runtime process. The
+// process inherits the environment of the server, with the python_path
+// entries and the entrypoint archives prepended to PYTHONPATH, and
+// PYTHONIOENCODING set to io_encoding, so that the stdio encoding does not
+// depend on the locale.
+func (fex *FunctionExecutor) getWorkerEnv() []string {
+	env := os.Environ()
+	if len(fex.PythonPath) > 0 || len(fex.archivePaths) > 0 {
+		paths := append(append([]string{}, fex.archivePaths...), fex.PythonPath...)
+		if s := os.Getenv("PYTHONPATH"); s != "" {
+			paths = append(paths, s)
+		}
+		env = append(env, "PYTHONPATH="+strings.Join(paths, string(os.PathListSeparator)))
+	}
+	return append(env, "PYTHONIOENCODING="+fex.IOEncoding)
+}
+
+// getResultKeys returns the custom keys of the handler response, keyed by
+// the default keys. The keys matching the defaults are omitted.
+func (fex *FunctionExecutor) getResultKeys() map[string]string {
+	m := make(map[string]string)
+	for k, v := range map[string]string{
+		"status_code": fex.StatusKey,
+		"body":        fex.BodyKey,
+		"headers":     fex.HeadersKey,
+	} {
+		if v != "" && v != k {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// getRequiredWorkersCount returns the number of workers the function
+// requires, including the fallback, after, and health workers.
+func (fex *FunctionExecutor) getRequiredWorkersCount() uint {
+	if fex.CaptureStdout {
+		return 0
+	}
+	count := fex.MaxWorkersCount
+	if fex.FallbackEntrypointHandler != "" {
+		count++
+	}
+	if fex.AfterEntrypointHandler != "" {
+		count++
+	}
+	if fex.HealthEntrypointHandler != "" {
+		count++
+	}
+	return count
+}
+
+// getEntrypointImport converts entrypoint path to python import path.
+func getEntrypointImport(s string) string {
+	if _, name, found := splitEntrypointArchive(s); found {
+		s = name
+	}
+	s = strings.ReplaceAll(s, "/", ".")
+	return strings.TrimSuffix(s, ".py")
 }
 
 func (fex FunctionExecutor) ServeHTTP(resp http.ResponseWriter, req *http.Request, next caddyhttp.Handler) error {
+	if !fex.isRequestMatched(req) {
+		return next.ServeHTTP(resp, req)
+	}
+	if fex.PassThrough {
+		return fex.invokePassThrough(resp, req, next)
+	}
 	return fex.invoke(resp, req)
 }
 
@@ -121,7 +831,18 @@ func (fex *FunctionExecutor) Cleanup() error {
 		zap.String("lambda_name", fex.Name),
 	)
 
-	for _, w := range fex.workers {
+	registry.unregister(fex)
+	if fex.reaperDone != nil {
+		// The reapers may be replacing the workers, so they are stopped
+		// before the workers are.
+		close(fex.reaperDone)
+		fex.reapers.Wait()
+		fex.reaperDone = nil
+	}
+	for _, p := range fex.getWorkerPools() {
+		p.pending.Wait()
+	}
+	for _, w := range fex.getAllWorkers() {
 		if err := w.terminate(); err != nil {
 			fex.logger.Warn(
 				"failed shutting down lambda runtime",
@@ -144,5 +865,38 @@ func (fex *FunctionExecutor) Cleanup() error {
 	return nil
 }
 
+// restart replaces the workers of all pools, one worker at a time, so that
+// the function keeps serving requests.
+func (fex *FunctionExecutor) restart() {
+	fex.logger.Info(
+		"restarting lambda runtimes",
+		zap.String("lambda_name", fex.Name),
+	)
+	for _, p := range fex.getWorkerPools() {
+		p.restart()
+	}
+}
+
+// getWorkerPools returns the primary, fallback, after, and health worker
+// pools, which are configured.
+func (fex *FunctionExecutor) getWorkerPools() []*workerPool {
+	var pools []*workerPool
+	for _, p := range []*workerPool{fex.workers, fex.fallbackWorkers, fex.afterWorkers, fex.healthWorkers} {
+		if p != nil {
+			pools = append(pools, p)
+		}
+	}
+	return pools
+}
+
+// getAllWorkers returns the workers of all pools.
+func (fex *FunctionExecutor) getAllWorkers() []*worker {
+	var workers []*worker
+	for _, p := range fex.getWorkerPools() {
+		workers = append(workers, p.getWorkers()...)
+	}
+	return workers
+}
+
 // Interface guard
 var _ caddyhttp.MiddlewareHandler = (*FunctionExecutor)(nil)
@@ -15,12 +15,21 @@
 package lambda
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/google/go-cmp/cmp"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -72,14 +81,18 @@ func newRequest(t *testing.T, method, uri string) *http.Request {
 		t.Fatalf("error creating request: %v", err)
 	}
 	req.RequestURI = req.URL.RequestURI()
+	req.RemoteAddr = "127.0.0.1:34567"
 	return req
 }
 
 type responseWriter struct {
 	body       []byte
 	statusCode int
-	header     http.Header
-	logger		*zap.Logger
+	// interimStatusCodes holds the 1xx status codes written before the
+	// final status code.
+	interimStatusCodes []int
+	header             http.Header
+	logger             *zap.Logger
 }
 
 func newResponseWriter(logger *zap.Logger) *responseWriter {
@@ -100,6 +113,561 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 }
 
 func (w *responseWriter) WriteHeader(statusCode int) {
+	if statusCode >= 100 && statusCode < 200 {
+		w.interimStatusCodes = append(w.interimStatusCodes, statusCode)
+	}
 	w.statusCode = statusCode
 	w.logger.Debug("wrote response header", zap.Int("status_code", statusCode))
-}
\ No newline at end of file
+}
+
+func TestFunctionExecutorFallback(t *testing.T) {
+	for i, tc := range []struct {
+		name           string
+		config         string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name: "test primary handler success does not invoke fallback",
+			config: `
+			lambda {
+				name fallback
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/fallback/app/index.py
+				function handler
+				fallback_function fallback_handler
+			}`,
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"message": "primary"}`,
+		},
+		{
+			name: "test primary handler error invokes fallback",
+			config: `
+			lambda {
+				name fallback
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/fallback/app/index.py
+				function failing_handler
+				fallback_entrypoint assets/scripts/api/fallback/app/index.py
+				fallback_function fallback_handler
+			}`,
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"message": "degraded"}`,
+		},
+		{
+			name: "test primary and fallback handler errors",
+			config: `
+			lambda {
+				name fallback
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/fallback/app/index.py
+				function failing_handler
+				fallback_function failing_fallback_handler
+			}`,
+			wantStatusCode: http.StatusInternalServerError,
+			wantBody:       http.StatusText(http.StatusInternalServerError),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := &FunctionExecutor{}
+			fex.logger = initLogger(zapcore.DebugLevel)
+			resp := newResponseWriter(fex.logger)
+			if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tc.config)); err != nil {
+				t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+			}
+			ctx := caddy.Context{Context: context.Background()}
+			if err := fex.Provision(ctx); err != nil {
+				t.Fatalf("unexpected Provision() error: %v", err)
+			}
+			defer fex.Cleanup()
+			if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.wantStatusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.wantStatusCode)
+			}
+			if string(resp.body) != tc.wantBody {
+				t.Fatalf("unexpected body: got %q, want %q", resp.body, tc.wantBody)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorValidateOnStart(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		entrypoint string
+		shouldErr  bool
+	}{
+		{
+			name:       "test conforming handler passes validation",
+			entrypoint: "assets/scripts/api/hello_world/app/index.py",
+		},
+		{
+			name:       "test non-conforming handler fails validation",
+			entrypoint: "assets/scripts/api/malformed/app/index.py",
+			shouldErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name validate
+				runtime python
+				python_executable python
+				entrypoint ` + tc.entrypoint + `
+				function handler
+				validate_on_start
+			}`
+			fex := &FunctionExecutor{}
+			fex.logger = initLogger(zapcore.DebugLevel)
+			if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)); err != nil {
+				t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+			}
+			ctx := caddy.Context{Context: context.Background()}
+			err := fex.Provision(ctx)
+			defer fex.Cleanup()
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("unexpected Provision() error: %v", err)
+				}
+				if !strings.Contains(err.Error(), "malformed handler response") {
+					t.Fatalf("unexpected Provision() error: %v", err)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected Provision() success")
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorPassThrough(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		pass_through
+	}`)
+	defer fex.Cleanup()
+
+	req := newRequest(t, "GET", "/")
+	ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{})
+	req = req.WithContext(ctx)
+	resp := newResponseWriter(fex.logger)
+
+	var nextCalled bool
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		nextCalled = true
+		if code := caddyhttp.GetVar(r.Context(), "lambda_status_code"); code != http.StatusOK {
+			t.Fatalf("unexpected lambda_status_code variable: %v", code)
+		}
+		body, _ := caddyhttp.GetVar(r.Context(), "lambda_body").(string)
+		if !strings.Contains(body, "hello world!") {
+			t.Fatalf("unexpected lambda_body variable: %v", body)
+		}
+		return nil
+	})
+
+	if err := fex.ServeHTTP(resp, req, next); err != nil {
+		t.Fatalf("unexpected ServeHTTP() error: %v", err)
+	}
+	if !nextCalled {
+		t.Fatalf("next handler was not called")
+	}
+	if resp.statusCode != 0 || resp.body != nil {
+		t.Fatalf("unexpected response written in pass-through mode: %d %s", resp.statusCode, resp.body)
+	}
+}
+
+func TestFunctionExecutorPassThroughAdmission(t *testing.T) {
+	text := []byte("hello world!")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(text)
+	zw.Close()
+
+	for i, tc := range []struct {
+		name        string
+		options     string
+		contentType string
+		encoding    string
+		body        []byte
+		// admitted is the number of the requests preceding the request.
+		admitted   int
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "test gzip body is decompressed",
+			options:    "decompress_request",
+			encoding:   "gzip",
+			body:       gz.Bytes(),
+			statusCode: http.StatusOK,
+			want:       fmt.Sprintf(`"size": %d,`, len(text)),
+		},
+		{
+			name:       "test malformed gzip body is rejected",
+			options:    "decompress_request",
+			encoding:   "gzip",
+			body:       text,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:        "test accepted content type",
+			options:     "accept_content_types text/*",
+			contentType: "text/plain",
+			body:        text,
+			statusCode:  http.StatusOK,
+			want:        fmt.Sprintf(`"size": %d,`, len(text)),
+		},
+		{
+			name:        "test rejected content type",
+			options:     "accept_content_types application/json",
+			contentType: "text/plain",
+			body:        text,
+			statusCode:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "test request over rate limit is rejected",
+			options:    "rate_limit 1 1m",
+			admitted:   1,
+			statusCode: http.StatusTooManyRequests,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name body
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/body/app/index.py
+				function handler
+				pass_through
+				`+tc.options+`
+			}`)
+			defer fex.Cleanup()
+
+			for n := 0; n < tc.admitted; n++ {
+				req := newRequest(t, "POST", "/")
+				req = req.WithContext(context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{}))
+				if err := fex.ServeHTTP(newResponseWriter(fex.logger), req, caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error {
+					return nil
+				})); err != nil {
+					t.Fatalf("unexpected ServeHTTP() error: %v", err)
+				}
+			}
+
+			req := newRequest(t, "POST", "/")
+			req.Body = io.NopCloser(bytes.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{}))
+
+			var nextCalled bool
+			next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				nextCalled = true
+				if code := caddyhttp.GetVar(r.Context(), "lambda_status_code"); code != tc.statusCode {
+					t.Fatalf("unexpected lambda_status_code variable: got %v, want %d", code, tc.statusCode)
+				}
+				errMsg, _ := caddyhttp.GetVar(r.Context(), "lambda_error").(string)
+				if (errMsg != "") != (tc.statusCode != http.StatusOK) {
+					t.Fatalf("unexpected lambda_error variable: %q", errMsg)
+				}
+				body, _ := caddyhttp.GetVar(r.Context(), "lambda_body").(string)
+				if !strings.Contains(body, tc.want) {
+					t.Fatalf("unexpected lambda_body variable: got %q, want %q", body, tc.want)
+				}
+				return nil
+			})
+
+			if err := fex.ServeHTTP(newResponseWriter(fex.logger), req, next); err != nil {
+				t.Fatalf("unexpected ServeHTTP() error: %v", err)
+			}
+			if !nextCalled {
+				t.Fatalf("next handler was not called")
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorPythonPath(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		pythonPath string
+		err        string
+	}{
+		{
+			name:       "test handler imports module from python_path",
+			pythonPath: "assets/scripts/api/python_path/vendor",
+		},
+		{
+			name:       "test missing python_path directory fails provisioning",
+			pythonPath: "assets/scripts/api/python_path/missing",
+			err:        "failed to access python_path assets/scripts/api/python_path/missing",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name python_path
+				runtime python
+				python_executable python
+				python_path ` + tc.pythonPath + `
+				entrypoint assets/scripts/api/python_path/app/index.py
+				function handler
+			}`
+			fex := &FunctionExecutor{}
+			fex.logger = initLogger(zapcore.DebugLevel)
+			if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)); err != nil {
+				t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+			}
+			err := fex.Provision(caddy.Context{Context: context.Background()})
+			defer fex.Cleanup()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("unexpected Provision() error: got %v, want %q", err, tc.err)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected Provision() error: %v", err)
+			}
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != http.StatusOK || string(resp.body) != "hello world!" {
+				t.Fatalf("unexpected response: %d %s", resp.statusCode, resp.body)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorImportIsolation(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		entrypoint string
+		statusCode int
+	}{
+		{
+			name:       "test entrypoint with syntax error",
+			entrypoint: "assets/scripts/api/broken/app/index.py",
+			statusCode: http.StatusInternalServerError,
+		},
+		{
+			name:       "test working entrypoint",
+			entrypoint: "assets/scripts/api/hello_world/app/index.py",
+			statusCode: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name isolation
+				runtime python
+				python_executable python
+				entrypoint `+tc.entrypoint+`
+				function handler
+			}`)
+			defer fex.Cleanup()
+
+			// The worker must respond promptly rather than wait for the timeout.
+			for j := 0; j < 2; j++ {
+				resp := newResponseWriter(fex.logger)
+				if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+					t.Fatalf("unexpected invoke() error: %v", err)
+				}
+				if resp.statusCode != tc.statusCode {
+					t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+				}
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorHandlerSignature(t *testing.T) {
+	for i, tc := range []struct {
+		name      string
+		function  string
+		signature string
+		want      map[string]interface{}
+	}{
+		{
+			name:     "test single signature by default",
+			function: "handler",
+			want: map[string]interface{}{
+				"request_id": "test-request-id",
+			},
+		},
+		{
+			name:      "test single signature",
+			function:  "handler",
+			signature: "single",
+			want: map[string]interface{}{
+				"request_id": "test-request-id",
+			},
+		},
+		{
+			name:      "test event and context signature",
+			function:  "aws_handler",
+			signature: "event_context",
+			want: map[string]interface{}{
+				"request_id":         "test-request-id",
+				"function_name":      "signature",
+				"has_time_remaining": true,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name signature
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/signature/app/index.py
+				function ` + tc.function
+			if tc.signature != "" {
+				config += `
+				handler_signature ` + tc.signature
+			}
+			config += `
+			}`
+			fex := newTestFunctionExecutor(t, config)
+			defer fex.Cleanup()
+
+			req := newRequest(t, "GET", "/")
+			ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{"request_id": "test-request-id"})
+			req = req.WithContext(ctx)
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, req); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			got := make(map[string]interface{})
+			if err := json.Unmarshal(resp.body, &got); err != nil {
+				t.Fatalf("unexpected body %q: %v", resp.body, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected body mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorContextTimeRemaining(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name signature
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/signature/app/index.py
+		function deadline_handler
+		handler_signature event_context
+	}`)
+	defer fex.Cleanup()
+
+	req := newRequest(t, "GET", "/")
+	ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{"request_id": "test-request-id"})
+	req = req.WithContext(ctx)
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, req); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	var got struct {
+		RequestID string  `json:"request_id"`
+		Before    float64 `json:"before"`
+		After     float64 `json:"after"`
+	}
+	if err := json.Unmarshal(resp.body, &got); err != nil {
+		t.Fatalf("unexpected body %q: %v", resp.body, err)
+	}
+	if got.RequestID != "test-request-id" {
+		t.Fatalf("unexpected context request id: %q", got.RequestID)
+	}
+	timeout := float64(fex.WorkerTimeout)
+	if got.Before > timeout || got.Before < timeout-1 {
+		t.Fatalf("unexpected time remaining %v of the worker timeout %v", got.Before, timeout)
+	}
+	// The handler sleeps for 200ms between the calls.
+	if elapsed := got.Before - got.After; elapsed < 0.15 {
+		t.Fatalf("time remaining did not decrease across the sleep: before %v, after %v", got.Before, got.After)
+	}
+}
+
+func TestFunctionExecutorIOEncoding(t *testing.T) {
+	// The locale of the server does not affect the protocol.
+	t.Setenv("LC_ALL", "C")
+	t.Setenv("PYTHONIOENCODING", "ascii")
+
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name unicode
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/unicode/app/index.py
+		function handler
+	}`)
+	defer fex.Cleanup()
+
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/?name="+url.QueryEscape("Ünïcødé"))); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	want := []byte("héllo wörld, こんにちは, 🎉 Ünïcødé")
+	if resp.statusCode != http.StatusOK || !bytes.Equal(resp.body, want) {
+		t.Fatalf("unexpected response: %d %q, want %q", resp.statusCode, resp.body, want)
+	}
+}
+
+func TestFunctionExecutorEscapeHTML(t *testing.T) {
+	for i, tc := range []struct {
+		name   string
+		config string
+	}{
+		{name: "test html characters passed verbatim"},
+		{name: "test html characters escaped", config: "escape_html"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name unicode
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/unicode/app/index.py
+				function handler
+				`+tc.config+`
+			}`)
+			defer fex.Cleanup()
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", "/?name="+url.QueryEscape(`<script>"a" & 'b'</script>`))); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			want := []byte(`héllo wörld, こんにちは, 🎉 <script>"a" & 'b'</script>`)
+			if resp.statusCode != http.StatusOK || !bytes.Equal(resp.body, want) {
+				t.Fatalf("unexpected response: %d %q, want %q", resp.statusCode, resp.body, want)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
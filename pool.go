@@ -0,0 +1,474 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// isolation values control the reuse of workers between requests.
+const (
+	// isolationShared reuses the worker for subsequent requests.
+	isolationShared = "shared"
+	// isolationPerRequest replaces the worker after each request, so that
+	// no state is shared between requests.
+	isolationPerRequest = "per_request"
+)
+
+// workerPool manages the runtime processes executing a function handler.
+type workerPool struct {
+	mu sync.Mutex
+	// released is signaled when a worker becomes available.
+	released    *sync.Cond
+	handler     *handlerSpec
+	workers     []*worker
+	startWorker func() (*worker, error)
+	logger      *zap.Logger
+	// dispatchTimeout is the max time a request waits for an available
+	// worker. If zero, the request waits until a worker is available.
+	dispatchTimeout time.Duration
+	// recycle instructs the pool to replace the worker after each request.
+	recycle bool
+	// recycleOnTimeout instructs the pool to replace the worker which timed
+	// out, instead of waiting for the handler to complete.
+	recycleOnTimeout bool
+	// queued is the number of requests waiting for an available worker.
+	queued uint
+	// maxQueue is the max number of requests waiting for an available
+	// worker. If zero, the number is not limited.
+	maxQueue uint
+	// crashLoop tracks the restarts of the crashed workers, if not nil.
+	crashLoop *crashLoopDetector
+	// failed holds the terminated workers, whose replacement failed to
+	// start. They are respawned by acquire once respawnAt passes.
+	failed    map[*worker]bool
+	respawnAt time.Time
+	// respawnBackoff is the delay before the next respawn. It doubles with
+	// each failed start, up to maxRespawnBackoff.
+	respawnBackoff time.Duration
+	// pending tracks the replacements of the workers started in the
+	// background, which are waited for before the workers are terminated.
+	pending sync.WaitGroup
+}
+
+func newWorkerPool(handler *handlerSpec, startWorker func() (*worker, error), logger *zap.Logger) *workerPool {
+	p := &workerPool{
+		handler:     handler,
+		startWorker: startWorker,
+		logger:      logger,
+		failed:      make(map[*worker]bool),
+	}
+	p.released = sync.NewCond(&p.mu)
+	return p
+}
+
+// maxParallelStarts is the max number of workers started concurrently.
+const maxParallelStarts = 8
+
+// minRespawnBackoff and maxRespawnBackoff bound the delay before a worker,
+// whose replacement failed to start, is respawned.
+const (
+	minRespawnBackoff = 250 * time.Millisecond
+	maxRespawnBackoff = 30 * time.Second
+)
+
+// start launches the requested number of workers concurrently. If any of
+// the workers fails to start, the started workers are terminated and the
+// errors are returned.
+func (p *workerPool) start(count uint) error {
+	workers := make([]*worker, count)
+	errs := make([]error, count)
+	sem := make(chan struct{}, maxParallelStarts)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			workers[i], errs[i] = p.startWorker()
+		}(i)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		for _, w := range workers {
+			if w == nil {
+				continue
+			}
+			if err := w.terminate(); err != nil {
+				p.logger.Debug(
+					"failed shutting down lambda runtime",
+					zap.String("lambda_name", p.handler.lambdaName),
+					zap.Uint("worker_id", w.ID),
+					zap.Int("worker_pid", w.Pid),
+					zap.Error(err),
+				)
+			}
+		}
+		return err
+	}
+
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+	p.mu.Lock()
+	p.workers = append(p.workers, workers...)
+	p.mu.Unlock()
+	return nil
+}
+
+// getWorkers returns the workers of the pool.
+func (p *workerPool) getWorkers() []*worker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	workers := make([]*worker, len(p.workers))
+	copy(workers, p.workers)
+	return workers
+}
+
+// getCounts returns the number of live workers and the number of
+// workers processing requests.
+func (p *workerPool) getCounts() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var total, busy int
+	for _, w := range p.workers {
+		if w.Terminated {
+			continue
+		}
+		total++
+		if w.InUse {
+			busy++
+		}
+	}
+	return total, busy
+}
+
+// acquire returns an available worker and marks it in use. When the sticky
+// key is not empty, the worker selected by the hash of the key is preferred,
+// if it is available. If all workers are busy, it waits until a worker is
+// released or the dispatch timeout expires. When max_queue requests are
+// already waiting, it fails immediately. The caller must hold the lock.
+func (p *workerPool) acquire(stickyKey string) (*worker, error) {
+	if stickyKey != "" && len(p.workers) > 0 {
+		// The replacement of a worker takes its place, so the key keeps
+		// selecting the same slot.
+		h := fnv.New32a()
+		h.Write([]byte(stickyKey))
+		w := p.workers[h.Sum32()%uint32(len(p.workers))]
+		if !w.InUse && !w.Terminated && !w.isExpired() {
+			w.InUse = true
+			return w, nil
+		}
+	}
+
+	var queuedAt time.Time
+	defer func() {
+		if !queuedAt.IsZero() {
+			p.queued--
+			observeDequeued(p.handler.lambdaName, queuedAt)
+		}
+	}()
+	var expired bool
+	if p.dispatchTimeout > 0 {
+		timer := time.AfterFunc(p.dispatchTimeout, func() {
+			p.mu.Lock()
+			expired = true
+			p.mu.Unlock()
+			p.released.Broadcast()
+		})
+		defer timer.Stop()
+	}
+	for {
+		var busy bool
+		for _, w := range p.workers {
+			// A worker being replaced is in use until the replacement starts.
+			if w.InUse {
+				busy = true
+				continue
+			}
+			if w.Terminated {
+				if p.failed[w] && !time.Now().Before(p.respawnAt) {
+					// The request waits for the respawned worker.
+					w.InUse = true
+					busy = true
+					p.pending.Add(1)
+					go func(w *worker) {
+						defer p.pending.Done()
+						p.respawn(w)
+						p.release(w)
+					}(w)
+				}
+				continue
+			}
+			if w.isExpired() {
+				// The expired worker is replaced before it serves another
+				// request, and the request waits for the replacement.
+				w.InUse = true
+				busy = true
+				p.pending.Add(1)
+				go func(w *worker) {
+					defer p.pending.Done()
+					p.replace(w)
+					p.release(w)
+				}(w)
+				continue
+			}
+			w.InUse = true
+			return w, nil
+		}
+		if !busy || expired {
+			return nil, errWorkersUnavailable
+		}
+		if queuedAt.IsZero() {
+			if p.maxQueue > 0 && p.queued >= p.maxQueue {
+				return nil, errQueueFull
+			}
+			queuedAt = time.Now()
+			p.queued++
+			observeQueued(p.handler.lambdaName)
+		}
+		p.released.Wait()
+	}
+}
+
+// release marks the worker available and wakes up the requests waiting
+// for a worker.
+func (p *workerPool) release(w *worker) {
+	p.mu.Lock()
+	w.InUse = false
+	p.mu.Unlock()
+	p.released.Broadcast()
+}
+
+// retryBackoff is the delay before the first retry. It doubles with
+// each subsequent retry.
+const retryBackoff = 50 * time.Millisecond
+
+// retryPolicy controls dispatching a request to another worker after
+// a worker failure.
+type retryPolicy struct {
+	maxRetries uint
+	// retryExited allows retrying requests delivered to a worker
+	// which exited before responding.
+	retryExited bool
+}
+
+func (rp retryPolicy) isRetryable(err error) bool {
+	if isRetryableError(err) {
+		return true
+	}
+	return rp.retryExited && errors.Is(err, errWorkerExited)
+}
+
+// exec dispatches the request to an available worker. When the worker
+// fails, the worker is replaced and the request is dispatched to another
+// worker according to the retry policy.
+func (p *workerPool) exec(requestID, stickyKey string, env *requestEnvelope, rp retryPolicy) (*workerResponse, error) {
+	return p.execHandler(p.handler, requestID, stickyKey, env, rp)
+}
+
+// execHandler dispatches the request for the handler, which is not
+// necessarily the handler of the pool, e.g. a pipeline step, to an
+// available worker.
+func (p *workerPool) execHandler(handler *handlerSpec, requestID, stickyKey string, env *requestEnvelope, rp retryPolicy) (*workerResponse, error) {
+	r, err := p.dispatch(handler, requestID, stickyKey, env)
+	for attempt := uint(1); attempt <= rp.maxRetries && rp.isRetryable(err); attempt++ {
+		p.logger.Warn(
+			"retrying lambda function on another worker",
+			zap.String("lambda_name", handler.lambdaName),
+			zap.String("request_id", requestID),
+			zap.Uint("worker_id", r.WorkerID),
+			zap.Uint("attempt", attempt),
+			zap.Error(err),
+		)
+		time.Sleep(retryBackoff << (attempt - 1))
+		r, err = p.dispatch(handler, requestID, stickyKey, env)
+	}
+	return r, err
+}
+
+func (p *workerPool) dispatch(handler *handlerSpec, requestID, stickyKey string, env *requestEnvelope) (*workerResponse, error) {
+	p.mu.Lock()
+	w, err := p.acquire(stickyKey)
+	p.mu.Unlock()
+	if err != nil {
+		return &workerResponse{StatusCode: http.StatusServiceUnavailable}, err
+	}
+	r, err := w.handle(handler, requestID, env)
+	if p.crashLoop != nil && isWorkerCrash(err) {
+		p.crashLoop.recordRestart(err)
+	}
+	p.finish(w, err)
+	return r, err
+}
+
+// shouldReplace returns true when the worker is replaced after the request,
+// which completed with the error, if any. It applies to both the requests
+// and the streams.
+func (p *workerPool) shouldReplace(w *worker, err error) bool {
+	return isWorkerError(err) || p.recycle || (p.recycleOnTimeout && errors.Is(err, errWorkerTimeout)) || w.isExpired()
+}
+
+// finish releases the worker after the request, which completed with the
+// error, if any, and replaces the worker first, when shouldReplace says so.
+// With per_request isolation, the worker is terminated, but its replacement
+// is started in the background, so that the request does not wait for the
+// interpreter to start. The worker stays in use until it is replaced.
+func (p *workerPool) finish(w *worker, err error) {
+	switch {
+	case !p.shouldReplace(w, err):
+	case p.recycle:
+		p.retire(w)
+		p.pending.Add(1)
+		go func() {
+			defer p.pending.Done()
+			p.respawn(w)
+			p.release(w)
+		}()
+		return
+	default:
+		p.replace(w)
+	}
+	p.release(w)
+}
+
+// replace terminates the worker and starts a new one in its place.
+func (p *workerPool) replace(w *worker) {
+	p.retire(w)
+	p.respawn(w)
+}
+
+// retire terminates the worker, which keeps its place in the pool until it
+// is respawned.
+func (p *workerPool) retire(w *worker) {
+	p.mu.Lock()
+	w.Terminated = true
+	p.mu.Unlock()
+
+	if err := w.terminate(); err != nil {
+		p.logger.Debug(
+			"failed shutting down lambda runtime",
+			zap.String("lambda_name", p.handler.lambdaName),
+			zap.Uint("worker_id", w.ID),
+			zap.Int("worker_pid", w.Pid),
+			zap.Error(err),
+		)
+	}
+}
+
+// respawn starts a new worker in place of the terminated worker. When the
+// start fails, e.g. on a transient fork failure, the worker is respawned by
+// acquire after a backoff, so that the pool does not shrink for good.
+func (p *workerPool) respawn(w *worker) {
+	nw, err := p.startWorker()
+	if err != nil {
+		p.mu.Lock()
+		p.failed[w] = true
+		p.respawnBackoff *= 2
+		if p.respawnBackoff < minRespawnBackoff {
+			p.respawnBackoff = minRespawnBackoff
+		}
+		if p.respawnBackoff > maxRespawnBackoff {
+			p.respawnBackoff = maxRespawnBackoff
+		}
+		backoff := p.respawnBackoff
+		p.respawnAt = time.Now().Add(backoff)
+		p.mu.Unlock()
+		p.logger.Error(
+			"failed replacing lambda runtime",
+			zap.String("lambda_name", p.handler.lambdaName),
+			zap.Uint("worker_id", w.ID),
+			zap.Duration("respawn_backoff", backoff),
+			zap.Error(err),
+		)
+		return
+	}
+
+	p.mu.Lock()
+	for i, pw := range p.workers {
+		if pw == w {
+			p.workers[i] = nw
+			break
+		}
+	}
+	delete(p.failed, w)
+	p.respawnBackoff = 0
+	p.mu.Unlock()
+	p.released.Broadcast()
+	p.logger.Info(
+		"replaced lambda runtime",
+		zap.String("lambda_name", p.handler.lambdaName),
+		zap.Uint("worker_id", w.ID),
+		zap.Uint("new_worker_id", nw.ID),
+		zap.Int("new_worker_pid", nw.Pid),
+	)
+}
+
+// reap replaces the idle workers older than their max age every interval,
+// until done is closed, so that the workers are recycled without waiting
+// for a request.
+func (p *workerPool) reap(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		var expired []*worker
+		p.mu.Lock()
+		for _, w := range p.workers {
+			if !w.InUse && !w.Terminated && w.isExpired() {
+				w.InUse = true
+				expired = append(expired, w)
+			}
+		}
+		p.mu.Unlock()
+		for _, w := range expired {
+			p.replace(w)
+			p.release(w)
+		}
+	}
+}
+
+// restart replaces the workers one at a time, e.g. to reload the handler
+// code. Each worker is replaced once it completes the request in flight,
+// so the other workers keep serving requests.
+func (p *workerPool) restart() {
+	for _, w := range p.getWorkers() {
+		p.mu.Lock()
+		for w.InUse && !w.Terminated {
+			p.released.Wait()
+		}
+		if w.Terminated {
+			p.mu.Unlock()
+			continue
+		}
+		w.InUse = true
+		p.mu.Unlock()
+		p.replace(w)
+		p.release(w)
+	}
+}
@@ -0,0 +1,920 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap/zapcore"
+)
+
+func newTestFunctionExecutor(t testing.TB, config string) *FunctionExecutor {
+	fex := &FunctionExecutor{}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)); err != nil {
+		t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+	}
+	if err := fex.Provision(caddy.Context{Context: context.Background()}); err != nil {
+		fex.Cleanup()
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	return fex
+}
+
+// newTestServer returns a test server invoking the function executor
+// configured with the config. Both are cleaned up with the test.
+func newTestServer(t testing.TB, config string) (*FunctionExecutor, *httptest.Server) {
+	fex := newTestFunctionExecutor(t, config)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fex.invoke(w, r)
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		fex.Cleanup()
+	})
+	return fex, srv
+}
+
+func TestWorkerPoolBrokenPipe(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers 1
+	}`)
+	defer fex.Cleanup()
+
+	for i := 0; i < 2; i++ {
+		w := fex.workers.getWorkers()[0]
+		if i > 0 {
+			// Kill the process after the import was written to the worker.
+			w.Cmd.Process.Kill()
+			<-w.exited
+		}
+
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+			t.Fatalf("unexpected invoke() error: %v", err)
+		}
+		if resp.statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusOK)
+		}
+
+		replaced := fex.workers.getWorkers()[0] != w
+		if wantReplaced := i > 0; replaced != wantReplaced {
+			t.Fatalf("unexpected worker replacement: got %t, want %t", replaced, wantReplaced)
+		}
+		t.Logf("PASS: Test %d", i)
+	}
+}
+
+func TestWorkerPoolRetry(t *testing.T) {
+	for i, tc := range []struct {
+		name           string
+		function       string
+		method         string
+		options        string
+		wantStatusCode int
+	}{
+		{
+			name:           "test retry succeeds after worker crash",
+			function:       "crash_once_handler",
+			method:         "GET",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "test retries exhausted",
+			function:       "crash_handler",
+			method:         "GET",
+			options:        "max_retries 2",
+			wantStatusCode: http.StatusBadGateway,
+		},
+		{
+			name:           "test retries disabled",
+			function:       "crash_once_handler",
+			method:         "GET",
+			options:        "max_retries 0",
+			wantStatusCode: http.StatusBadGateway,
+		},
+		{
+			name:           "test unsafe method is not retried",
+			function:       "crash_once_handler",
+			method:         "POST",
+			wantStatusCode: http.StatusBadGateway,
+		},
+		{
+			name:           "test unsafe method is retried with force_retry",
+			function:       "crash_once_handler",
+			method:         "POST",
+			options:        "force_retry",
+			wantStatusCode: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name crash
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/crash/app/index.py
+				function `+tc.function+`
+				workers 2
+				`+tc.options+`
+			}`)
+			defer fex.Cleanup()
+
+			flag := filepath.Join(t.TempDir(), "crashed")
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, tc.method, "/?flag="+url.QueryEscape(flag))); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.wantStatusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.wantStatusCode)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerPoolDispatchLatency(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name slow
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/slow/app/index.py
+		function handler
+		workers 1
+	}`)
+	defer fex.Cleanup()
+
+	done := make(chan time.Time, 2)
+	invoke := func(uri string) {
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "GET", uri)); err != nil {
+			t.Errorf("unexpected invoke() error: %v", err)
+		}
+		if resp.statusCode != http.StatusOK {
+			t.Errorf("unexpected status code: %d", resp.statusCode)
+		}
+		done <- time.Now()
+	}
+
+	go invoke("/?sleep=0.3")
+	time.Sleep(50 * time.Millisecond)
+	// The request is queued until the only worker completes the first one.
+	go invoke("/?sleep=0")
+	first, second := <-done, <-done
+	if d := second.Sub(first); d > 50*time.Millisecond {
+		t.Fatalf("queued request served %s after the worker was released", d)
+	}
+}
+
+func TestWorkerPoolDispatchTimeout(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name slow
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/slow/app/index.py
+		function handler
+		workers 1
+		dispatch_timeout 100ms
+	}`)
+	defer fex.Cleanup()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fex.invoke(newResponseWriter(fex.logger), newRequest(t, "GET", "/?sleep=0.5"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/?sleep=0")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusServiceUnavailable)
+	}
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Fatalf("unexpected dispatch wait: %s", d)
+	}
+	wg.Wait()
+}
+
+func TestWorkerPoolTruncatedOutput(t *testing.T) {
+	fex := &FunctionExecutor{}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`
+	lambda {
+		name truncated
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/truncated/app/index.py
+		function handler
+	}`)); err != nil {
+		t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+	}
+	fex.WorkerTimeout = 1
+	if err := fex.Provision(caddy.Context{Context: context.Background()}); err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer fex.Cleanup()
+
+	w := fex.workers.getWorkers()[0]
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusBadGateway)
+	}
+	if fex.workers.getWorkers()[0] == w {
+		t.Fatalf("worker with truncated output was not replaced")
+	}
+}
+
+func TestWorkerPoolPartialOnTimeout(t *testing.T) {
+	fex := &FunctionExecutor{}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`
+	lambda {
+		name truncated
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/truncated/app/index.py
+		function handler
+		partial_on_timeout
+	}`)); err != nil {
+		t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+	}
+	fex.WorkerTimeout = 1
+	if err := fex.Provision(caddy.Context{Context: context.Background()}); err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer fex.Cleanup()
+
+	w := fex.workers.getWorkers()[0]
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusPartialContent {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusPartialContent)
+	}
+	if string(resp.body) != "partial" {
+		t.Fatalf("unexpected body: got %q, want %q", resp.body, "partial")
+	}
+	if got := resp.Header().Get("X-Lambda-Timeout"); got != "true" {
+		t.Fatalf("unexpected X-Lambda-Timeout header: %q", got)
+	}
+	if fex.workers.getWorkers()[0] == w {
+		t.Fatalf("worker which timed out was not replaced")
+	}
+}
+
+func TestWorkerPoolIsolation(t *testing.T) {
+	for i, tc := range []struct {
+		name      string
+		isolation string
+		want      []string
+	}{
+		{
+			name: "test shared worker keeps global state",
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name:      "test per request worker does not leak global state",
+			isolation: "per_request",
+			want:      []string{"1", "1", "1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name state
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/state/app/index.py
+				function handler
+				workers 1`
+			if tc.isolation != "" {
+				config += `
+				isolation ` + tc.isolation
+			}
+			config += `
+			}`
+			fex := newTestFunctionExecutor(t, config)
+			defer fex.Cleanup()
+
+			var got []string
+			for range tc.want {
+				resp := newResponseWriter(fex.logger)
+				if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+					t.Fatalf("unexpected invoke() error: %v", err)
+				}
+				if resp.statusCode != http.StatusOK {
+					t.Fatalf("unexpected status code: %d", resp.statusCode)
+				}
+				got = append(got, string(resp.body))
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected invocation counts mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerPoolIsolationRespawn(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name state
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/state/app/index.py
+		function handler
+		workers 1
+		isolation per_request
+	}`)
+	defer fex.Cleanup()
+
+	// The replacement of the worker does not start until unblocked.
+	p := fex.workers
+	unblock := make(chan struct{})
+	p.startWorker = func() (*worker, error) {
+		<-unblock
+		return fex.startWorker()
+	}
+
+	exec := func(requestID string) (*workerResponse, error) {
+		req := newRequest(t, "GET", "/")
+		return p.exec(requestID, "", fex.newEnvelope(fex.buildRequestData(req, requestID)), retryPolicy{})
+	}
+
+	w := p.getWorkers()[0]
+	done := make(chan error, 1)
+	go func() {
+		_, err := exec("test-request-id-0")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected exec() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		close(unblock)
+		t.Fatalf("request waits for the replacement of the worker")
+	}
+	if !w.Terminated {
+		t.Fatalf("worker %d is not terminated after the request", w.ID)
+	}
+
+	// The next request waits for the replacement.
+	close(unblock)
+	r, err := exec("test-request-id-1")
+	if err != nil {
+		t.Fatalf("unexpected exec() error: %v", err)
+	}
+	if string(r.Body) != "1" {
+		t.Fatalf("unexpected invocation count: %s", r.Body)
+	}
+	if r.WorkerID == w.ID {
+		t.Fatalf("request served by the terminated worker %d", w.ID)
+	}
+}
+
+func TestWorkerPoolIsolationTmpDir(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name tmpdir
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/tmpdir/app/index.py
+		function handler
+		workers 1
+		isolation per_request
+	}`)
+	defer fex.Cleanup()
+
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+			t.Fatalf("unexpected invoke() error: %v", err)
+		}
+		if resp.statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", resp.statusCode)
+		}
+		var got struct {
+			TmpDir  string `json:"tmpdir"`
+			Exists  bool   `json:"exists"`
+			Env     string `json:"env"`
+			FileDir string `json:"file_dir"`
+		}
+		if err := json.Unmarshal(resp.body, &got); err != nil {
+			t.Fatalf("unexpected body %q: %v", resp.body, err)
+		}
+		if got.TmpDir == "" || !got.Exists {
+			t.Fatalf("unexpected tmpdir during the call: %+v", got)
+		}
+		if got.Env != got.TmpDir || got.FileDir != got.TmpDir {
+			t.Fatalf("unexpected temporary directory of the process: %+v", got)
+		}
+		// The worker is replaced, and its directory removed, before the
+		// response is returned.
+		if _, err := os.Stat(got.TmpDir); !os.IsNotExist(err) {
+			t.Fatalf("tmpdir %s was not removed after the call: %v", got.TmpDir, err)
+		}
+		dirs = append(dirs, got.TmpDir)
+	}
+	if dirs[0] == dirs[1] {
+		t.Fatalf("unexpected tmpdir reused across requests: %s", dirs[0])
+	}
+}
+
+func TestWorkerPoolQueueDepth(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name queue_depth
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/slow/app/index.py
+		function handler
+		workers 1
+	}`)
+	defer fex.Cleanup()
+
+	lambdaMetrics.init.Do(initLambdaMetrics)
+	getQueueDepth := func() float64 {
+		return testutil.ToFloat64(lambdaMetrics.queueDepth.WithLabelValues("queue_depth"))
+	}
+
+	var wg sync.WaitGroup
+	invoke := func(uri string) {
+		defer wg.Done()
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "GET", uri)); err != nil {
+			t.Errorf("unexpected invoke() error: %v", err)
+		}
+	}
+	wg.Add(3)
+	go invoke("/?sleep=0.5")
+	time.Sleep(50 * time.Millisecond)
+	// Both requests wait for the only worker.
+	go invoke("/?sleep=0")
+	go invoke("/?sleep=0")
+
+	var depth float64
+	for start := time.Now(); time.Since(start) < 300*time.Millisecond; time.Sleep(10 * time.Millisecond) {
+		if depth = getQueueDepth(); depth == 2 {
+			break
+		}
+	}
+	if depth != 2 {
+		t.Fatalf("unexpected queue depth of saturated pool: got %v, want 2", depth)
+	}
+	wg.Wait()
+	if depth := getQueueDepth(); depth != 0 {
+		t.Fatalf("unexpected queue depth of idle pool: got %v, want 0", depth)
+	}
+}
+
+func TestWorkerPoolImportTimeout(t *testing.T) {
+	for i, tc := range []struct {
+		name           string
+		importTimeout  string
+		wantStatusCode int
+	}{
+		{
+			name:           "test slow import within import timeout",
+			importTimeout:  "5s",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "test slow import exceeding import timeout",
+			importTimeout:  "500ms",
+			wantStatusCode: http.StatusRequestTimeout,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := &FunctionExecutor{}
+			fex.logger = initLogger(zapcore.DebugLevel)
+			if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`
+			lambda {
+				name slow_import
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/slow_import/app/index.py
+				function handler
+				import_timeout ` + tc.importTimeout + `
+			}`)); err != nil {
+				t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+			}
+			// The import takes longer than the request timeout.
+			fex.WorkerTimeout = 1
+			if err := fex.Provision(caddy.Context{Context: context.Background()}); err != nil {
+				t.Fatalf("unexpected Provision() error: %v", err)
+			}
+			defer fex.Cleanup()
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.wantStatusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.wantStatusCode)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerPoolMaxQueue(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name slow
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/slow/app/index.py
+		function handler
+		workers 1
+		max_queue 1
+	}`)
+	defer fex.Cleanup()
+
+	var wg sync.WaitGroup
+	invoke := func(uri string) {
+		defer wg.Done()
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "GET", uri)); err != nil {
+			t.Errorf("unexpected invoke() error: %v", err)
+		}
+		if resp.statusCode != http.StatusOK {
+			t.Errorf("unexpected status code: %d", resp.statusCode)
+		}
+	}
+	wg.Add(2)
+	go invoke("/?sleep=0.5")
+	time.Sleep(50 * time.Millisecond)
+	// The request waits for the only worker and fills up the queue.
+	go invoke("/?sleep=0")
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/?sleep=0")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusServiceUnavailable)
+	}
+	if got := resp.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("unexpected Retry-After header: %q", got)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("request over the queue limit was not rejected immediately: %s", d)
+	}
+	wg.Wait()
+}
+
+func TestWorkerPoolStart(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers 12
+	}`)
+	defer fex.Cleanup()
+
+	workers := fex.workers.getWorkers()
+	if len(workers) != 12 {
+		t.Fatalf("unexpected number of workers: got %d, want %d", len(workers), 12)
+	}
+	pids := make(map[int]bool)
+	for i, w := range workers {
+		if w.ID != uint(i) {
+			t.Fatalf("unexpected worker id: got %d, want %d", w.ID, i)
+		}
+		pids[w.Pid] = true
+	}
+	if len(pids) != 12 {
+		t.Fatalf("unexpected number of worker processes: got %d, want %d", len(pids), 12)
+	}
+
+	var mu sync.Mutex
+	var started []*worker
+	var calls int32
+	p := newWorkerPool(fex.workers.handler, func() (*worker, error) {
+		if atomic.AddInt32(&calls, 1) == 5 {
+			return nil, errors.New("failed starting worker")
+		}
+		w, err := fex.startWorker()
+		if err == nil {
+			mu.Lock()
+			started = append(started, w)
+			mu.Unlock()
+		}
+		return w, err
+	}, fex.logger)
+	if err := p.start(10); err == nil || !strings.Contains(err.Error(), "failed starting worker") {
+		t.Fatalf("unexpected start() error: %v", err)
+	}
+	if n := len(p.getWorkers()); n != 0 {
+		t.Fatalf("unexpected number of workers after failed start: %d", n)
+	}
+	if len(started) != 9 {
+		t.Fatalf("unexpected number of started workers: got %d, want %d", len(started), 9)
+	}
+	for _, w := range started {
+		if !w.Terminated || w.Cmd.ProcessState == nil {
+			t.Fatalf("unexpected worker %d running after failed start", w.ID)
+		}
+	}
+}
+
+func TestWorkerPoolRespawn(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers 1
+	}`)
+	defer fex.Cleanup()
+
+	p := fex.workers
+	var calls int32
+	p.startWorker = func() (*worker, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, errors.New("failed starting worker")
+		}
+		return fex.startWorker()
+	}
+
+	// The replacement of the worker fails to start.
+	p.mu.Lock()
+	w, err := p.acquire("")
+	p.mu.Unlock()
+	if err != nil {
+		t.Fatalf("unexpected acquire() error: %v", err)
+	}
+	p.replace(w)
+	p.release(w)
+	if total, _ := p.getCounts(); total != 0 {
+		t.Fatalf("unexpected number of live workers after failed replacement: %d", total)
+	}
+
+	for i, tc := range []struct {
+		name    string
+		wait    time.Duration
+		wantErr error
+	}{
+		{
+			name:    "test request fails fast during respawn backoff",
+			wantErr: errWorkersUnavailable,
+		},
+		{
+			name: "test request is served by respawned worker",
+			wait: minRespawnBackoff,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			time.Sleep(tc.wait)
+			req := newRequest(t, "GET", "/")
+			r, err := p.exec("test-request-id", "", fex.newEnvelope(fex.buildRequestData(req, "test-request-id")), retryPolicy{})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("unexpected exec() error: got %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if r.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status code: got %d, want %d", r.StatusCode, http.StatusOK)
+			}
+			if total, _ := p.getCounts(); total != 1 {
+				t.Fatalf("unexpected number of live workers after respawn: %d", total)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerPoolShouldReplace(t *testing.T) {
+	for i, tc := range []struct {
+		name             string
+		recycle          bool
+		recycleOnTimeout bool
+		expired          bool
+		err              error
+		want             bool
+	}{
+		{
+			name: "test worker is kept after success",
+		},
+		{
+			name: "test exited worker is replaced",
+			err:  errWorkerExited,
+			want: true,
+		},
+		{
+			name: "test timed out worker is kept",
+			err:  errWorkerTimeout,
+		},
+		{
+			name:             "test timed out worker is replaced with recycle on timeout",
+			recycleOnTimeout: true,
+			err:              errWorkerTimeout,
+			want:             true,
+		},
+		{
+			name:    "test worker is replaced with per request isolation",
+			recycle: true,
+			want:    true,
+		},
+		{
+			name:    "test expired worker is replaced",
+			expired: true,
+			want:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &workerPool{recycle: tc.recycle, recycleOnTimeout: tc.recycleOnTimeout}
+			w := &worker{startedAt: time.Now()}
+			if tc.expired {
+				w.maxAge = time.Nanosecond
+				w.startedAt = time.Now().Add(-time.Second)
+			}
+			if got := p.shouldReplace(w, tc.err); got != tc.want {
+				t.Fatalf("unexpected shouldReplace(): got %t, want %t", got, tc.want)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerPoolStickyHeader(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers 4
+		sticky_header X-Session-Id
+	}`)
+	defer fex.Cleanup()
+
+	pinned := make(map[string]uint)
+	for i := 0; i < 3; i++ {
+		for _, token := range []string{"alice", "bob", "carol", "dave"} {
+			req := newRequest(t, "GET", "/")
+			req.Header.Set("X-Session-Id", token)
+			r, err := fex.execWorker(req.Method, fex.getStickyKey(req), "", fex.newEnvelope(fex.buildRequestData(req, "test-request-id")))
+			if err != nil {
+				t.Fatalf("unexpected execWorker() error: %v", err)
+			}
+			if id, found := pinned[token]; found && id != r.WorkerID {
+				t.Fatalf("unexpected worker for session %s: got %d, want %d", token, r.WorkerID, id)
+			}
+			pinned[token] = r.WorkerID
+		}
+	}
+	workerIDs := make(map[uint]bool)
+	for _, id := range pinned {
+		workerIDs[id] = true
+	}
+	if len(workerIDs) < 2 {
+		t.Fatalf("unexpected sessions pinned to a single worker: %v", pinned)
+	}
+
+	// When the pinned worker is busy, another worker is selected.
+	p := fex.workers
+	p.mu.Lock()
+	w1, err := p.acquire("alice")
+	if err != nil {
+		p.mu.Unlock()
+		t.Fatalf("unexpected acquire() error: %v", err)
+	}
+	w2, err := p.acquire("alice")
+	p.mu.Unlock()
+	if err != nil {
+		t.Fatalf("unexpected acquire() error: %v", err)
+	}
+	p.release(w1)
+	p.release(w2)
+	if w1.ID != pinned["alice"] || w2 == w1 {
+		t.Fatalf("unexpected workers: got %d and %d, want %d and another worker", w1.ID, w2.ID, pinned["alice"])
+	}
+}
+
+func TestWorkerPoolMaxWorkerAge(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name state
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/state/app/index.py
+		function handler
+		workers 1
+		max_worker_age 500ms
+	}`)
+	defer fex.Cleanup()
+
+	invoke := func() string {
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+			t.Fatalf("unexpected invoke() error: %v", err)
+		}
+		if resp.statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", resp.statusCode)
+		}
+		return string(resp.body)
+	}
+
+	w := fex.workers.getWorkers()[0]
+	var got []string
+	got = append(got, invoke(), invoke())
+	if workers := fex.workers.getWorkers(); workers[0] != w {
+		t.Fatalf("worker %d is recycled before its max age", w.ID)
+	}
+
+	// The idle worker is replaced once it is aged, without a request.
+	deadline := w.startedAt.Add(w.maxAge + time.Second)
+	for fex.workers.getWorkers()[0] == w {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle worker %d is not recycled after its max age %v", w.ID, w.maxAge)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	got = append(got, invoke())
+
+	// The aged worker does not serve another request.
+	w = fex.workers.getWorkers()[0]
+	fex.workers.mu.Lock()
+	w.maxAge = time.Nanosecond
+	fex.workers.mu.Unlock()
+	got = append(got, invoke())
+	if workers := fex.workers.getWorkers(); workers[0] == w {
+		t.Fatalf("worker %d is not recycled after its max age %v", w.ID, w.maxAge)
+	}
+
+	if diff := cmp.Diff([]string{"1", "2", "1", "1"}, got); diff != "" {
+		t.Fatalf("unexpected invocation counts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetWorkerMaxAge(t *testing.T) {
+	if got := getWorkerMaxAge(0); got != 0 {
+		t.Fatalf("unexpected max age without max_worker_age: %v", got)
+	}
+	maxAge := time.Hour
+	for i := 0; i < 100; i++ {
+		got := getWorkerMaxAge(maxAge)
+		if got < maxAge || got > maxAge+maxAge/10 {
+			t.Fatalf("max age %v is out of the jitter range of %v", got, maxAge)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// getProcessNice returns the nice value of the process.
+func getProcessNice(t *testing.T, pid int) int {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		t.Fatalf("failed reading process stat: %v", err)
+	}
+	// The fields follow the command name in parentheses, which may hold
+	// spaces. The nice value is the 19th field, counting from the pid.
+	s := string(b)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(fields) < 17 {
+		t.Fatalf("unexpected process stat: %q", s)
+	}
+	n, err := strconv.Atoi(fields[16])
+	if err != nil {
+		t.Fatalf("unexpected process stat: %q", s)
+	}
+	return n
+}
+
+func TestWorkerProcessPriority(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process_priority is supported on linux only")
+	}
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers 2
+		process_priority 10
+	}`)
+	defer fex.Cleanup()
+
+	for _, w := range fex.workers.getWorkers() {
+		if got := getProcessNice(t, w.Pid); got != 10 {
+			t.Fatalf("unexpected worker %d nice value: got %d, want %d", w.ID, got, 10)
+		}
+	}
+	r, err := fex.execRequest(newRequest(t, "GET", "/"), "test-request-id")
+	if err != nil {
+		t.Fatalf("unexpected execRequest() error: %v", err)
+	}
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", r.StatusCode)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// pythonVersionScript prints the major and minor version of the interpreter.
+// It is valid in both Python 2 and 3.
+const pythonVersionScript = `import sys; sys.stdout.write("%d.%d" % sys.version_info[:2])`
+
+// defaultIOEncoding is the default encoding of the stdio of the python
+// executable.
+const defaultIOEncoding = "utf-8"
+
+// pythonVersionTimeout is the max time the interpreter has to report its version.
+const pythonVersionTimeout = 10 * time.Second
+
+// getPythonVersion returns the major and minor version of the python
+// executable.
+func getPythonVersion(binPath string) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pythonVersionTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, binPath, "-c", pythonVersionScript).Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get python version of %s: %v", binPath, err)
+	}
+	s := strings.TrimSpace(string(output))
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("failed to parse python version of %s: %q", binPath, s)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse python version of %s: %q", binPath, s)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse python version of %s: %q", binPath, s)
+	}
+	return major, minor, nil
+}
+
+// resolveExecutable returns the path to the first executable found among
+// the candidates. The candidates may contain placeholders, e.g. {env.HOME}.
+func resolveExecutable(candidates []string) (string, error) {
+	repl := caddy.NewReplacer()
+	for _, candidate := range candidates {
+		s := repl.ReplaceAll(candidate, "")
+		if s == "" {
+			continue
+		}
+		fp, err := exec.LookPath(s)
+		if err != nil {
+			continue
+		}
+		return fp, nil
+	}
+	return "", fmt.Errorf("none of the executables %q found", candidates)
+}
+
+// checkPythonVersion returns an error when the python executable is not
+// Python 3. The worker protocol is not supported by older interpreters.
+func checkPythonVersion(binPath string) error {
+	major, minor, err := getPythonVersion(binPath)
+	if err != nil {
+		return err
+	}
+	if major < 3 {
+		return fmt.Errorf("python executable %s is version %d.%d, but python 3 is required", binPath, major, minor)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap/zapcore"
+)
+
+// newFakePython returns the path to a script which mimics the python
+// executable reporting the version.
+func newFakePython(t *testing.T, version string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake python executable requires a shell")
+	}
+	fp := filepath.Join(t.TempDir(), "python")
+	script := "#!/bin/sh\nprintf '" + version + "'\n"
+	if err := os.WriteFile(fp, []byte(script), 0755); err != nil {
+		t.Fatalf("failed writing fake python executable: %v", err)
+	}
+	return fp
+}
+
+func TestCheckPythonVersion(t *testing.T) {
+	for i, tc := range []struct {
+		name    string
+		version string
+		err     string
+	}{
+		{
+			name:    "test python 3 is accepted",
+			version: "3.11",
+		},
+		{
+			name:    "test python 2 is rejected",
+			version: "2.7",
+			err:     "is version 2.7, but python 3 is required",
+		},
+		{
+			name:    "test malformed version is rejected",
+			version: "foo",
+			err:     "failed to parse python version",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkPythonVersion(newFakePython(t, tc.version))
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected checkPythonVersion() error: %v", err)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("unexpected checkPythonVersion() error: got %v, want %q", err, tc.err)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorPython2(t *testing.T) {
+	config := `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable ` + newFakePython(t, "2.7") + `
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+	}`
+	fex := &FunctionExecutor{}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)); err != nil {
+		t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+	}
+	err := fex.Provision(caddy.Context{Context: context.Background()})
+	if err == nil {
+		fex.Cleanup()
+		t.Fatalf("unexpected Provision() success")
+	}
+	if !strings.Contains(err.Error(), "python 3 is required") {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	if fex.workers != nil {
+		t.Fatalf("unexpected workers started with python 2 interpreter")
+	}
+}
+
+func TestResolveExecutable(t *testing.T) {
+	fp := newFakePython(t, "3.11")
+	t.Setenv("LAMBDA_TEST_PYTHON_DIR", filepath.Dir(fp))
+
+	for i, tc := range []struct {
+		name       string
+		candidates []string
+		want       string
+		shouldErr  bool
+	}{
+		{
+			name:       "test first existing candidate is selected",
+			candidates: []string{"/nonexistent/bin/python", fp, "python"},
+			want:       fp,
+		},
+		{
+			name:       "test candidate with env placeholder",
+			candidates: []string{"/nonexistent/bin/python", "{env.LAMBDA_TEST_PYTHON_DIR}/python"},
+			want:       fp,
+		},
+		{
+			name:       "test candidate with unset env placeholder is skipped",
+			candidates: []string{"{env.LAMBDA_TEST_UNSET}", fp},
+			want:       fp,
+		},
+		{
+			name:       "test no candidate is found",
+			candidates: []string{"/nonexistent/bin/python", "nonexistent-python"},
+			shouldErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveExecutable(tc.candidates)
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("unexpected resolveExecutable() error: %v", err)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected resolveExecutable() success: %s", got)
+			}
+			if got != tc.want {
+				t.Fatalf("unexpected executable: got %q, want %q", got, tc.want)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseRange returns the first and the last byte positions of the single
+// byte range of the Range header, e.g. bytes=0-99, bytes=100-, or
+// bytes=-100, within the body of the size.
+func parseRange(s string, size int) (int, int, error) {
+	spec, found := strings.CutPrefix(s, "bytes=")
+	if !found {
+		return 0, 0, fmt.Errorf("%w: unsupported unit: %s", errRangeMalformed, s)
+	}
+	if strings.Contains(spec, ",") {
+		// The multiple ranges are not supported, so the full body is sent.
+		return 0, 0, fmt.Errorf("%w: multiple ranges: %s", errRangeMalformed, s)
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, fmt.Errorf("%w: %s", errRangeMalformed, s)
+	}
+
+	if first == "" {
+		// The suffix range holds the last bytes of the body.
+		n, err := strconv.Atoi(last)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("%w: %s", errRangeMalformed, s)
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("%w: %s", errRangeMalformed, s)
+	}
+	end := size - 1
+	if last != "" {
+		n, err := strconv.Atoi(last)
+		if err != nil || n < start {
+			return 0, 0, fmt.Errorf("%w: %s", errRangeMalformed, s)
+		}
+		if n < end {
+			end = n
+		}
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end, nil
+}
+
+// isIfRangeMatch returns true when the If-Range header of the request is
+// absent or it matches the response, i.e. the strong ETag or the exact
+// Last-Modified date. Otherwise, the range is ignored and the full body is
+// sent.
+func isIfRangeMatch(req *http.Request, header http.Header) bool {
+	ir := req.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		// If-Range requires the strong comparison of the ETags.
+		etag := header.Get("ETag")
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ir == etag
+	}
+	lm := header.Get("Last-Modified")
+	if lm == "" {
+		return false
+	}
+	irTime, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
+	lmTime, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+	return irTime.Equal(lmTime)
+}
+
+// writeRange writes the part of the body requested by the Range header of
+// the GET request with the 206 Partial Content status code, or 416 Range
+// Not Satisfiable when the range is outside of the body, and returns true.
+// It returns false when the range does not apply, i.e. the full body is
+// written by the caller.
+func writeRange(resp http.ResponseWriter, req *http.Request, body []byte) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	s := req.Header.Get("Range")
+	h := resp.Header()
+	if s == "" || h.Get("Content-Range") != "" || h.Get("Accept-Ranges") == "none" {
+		return false
+	}
+	if !isIfRangeMatch(req, h) {
+		return false
+	}
+	start, end, err := parseRange(s, len(body))
+	switch {
+	case errors.Is(err, errRangeNotSatisfiable):
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+		h.Set("Content-Length", "0")
+		resp.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	case err != nil:
+		// The malformed range is ignored.
+		return false
+	}
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+	h.Set("Content-Length", strconv.Itoa(end-start+1))
+	resp.WriteHeader(http.StatusPartialContent)
+	resp.Write(body[start : end+1])
+	return true
+}
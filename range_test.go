@@ -0,0 +1,170 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRange(t *testing.T) {
+	for i, tc := range []struct {
+		name      string
+		spec      string
+		wantStart int
+		wantEnd   int
+		wantErr   error
+	}{
+		{name: "test bounded range", spec: "bytes=0-9", wantStart: 0, wantEnd: 9},
+		{name: "test range past the end", spec: "bytes=90-200", wantStart: 90, wantEnd: 99},
+		{name: "test open range", spec: "bytes=50-", wantStart: 50, wantEnd: 99},
+		{name: "test suffix range", spec: "bytes=-10", wantStart: 90, wantEnd: 99},
+		{name: "test suffix range longer than body", spec: "bytes=-200", wantStart: 0, wantEnd: 99},
+		{name: "test range after the end", spec: "bytes=100-", wantErr: errRangeNotSatisfiable},
+		{name: "test empty suffix range", spec: "bytes=-0", wantErr: errRangeNotSatisfiable},
+		{name: "test multiple ranges", spec: "bytes=0-9,20-29", wantErr: errRangeMalformed},
+		{name: "test unsupported unit", spec: "items=0-9", wantErr: errRangeMalformed},
+		{name: "test reversed range", spec: "bytes=9-0", wantErr: errRangeMalformed},
+		{name: "test non-numeric range", spec: "bytes=a-b", wantErr: errRangeMalformed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, err := parseRange(tc.spec, 100)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("unexpected parseRange() error: got %v, want %v", err, tc.wantErr)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected parseRange() error: %v", err)
+			}
+			if start != tc.wantStart || end != tc.wantEnd {
+				t.Fatalf("unexpected range: got %d-%d, want %d-%d", start, end, tc.wantStart, tc.wantEnd)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorRange(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name range
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/range/app/index.py
+		function handler
+	}`)
+	defer fex.Cleanup()
+
+	body := "0123456789abcdefghij"
+	for i, tc := range []struct {
+		name             string
+		method           string
+		headers          map[string]string
+		wantStatusCode   int
+		wantBody         string
+		wantContentRange string
+	}{
+		{
+			name:             "test satisfiable range",
+			headers:          map[string]string{"Range": "bytes=10-14"},
+			wantStatusCode:   http.StatusPartialContent,
+			wantBody:         "abcde",
+			wantContentRange: "bytes 10-14/20",
+		},
+		{
+			name:             "test suffix range",
+			headers:          map[string]string{"Range": "bytes=-3"},
+			wantStatusCode:   http.StatusPartialContent,
+			wantBody:         "hij",
+			wantContentRange: "bytes 17-19/20",
+		},
+		{
+			name:             "test unsatisfiable range",
+			headers:          map[string]string{"Range": "bytes=20-"},
+			wantStatusCode:   http.StatusRequestedRangeNotSatisfiable,
+			wantContentRange: "bytes */20",
+		},
+		{
+			name:             "test if-range matching etag",
+			headers:          map[string]string{"Range": "bytes=0-0", "If-Range": `"v1"`},
+			wantStatusCode:   http.StatusPartialContent,
+			wantBody:         "0",
+			wantContentRange: "bytes 0-0/20",
+		},
+		{
+			name:             "test if-range matching last-modified",
+			headers:          map[string]string{"Range": "bytes=0-0", "If-Range": "Mon, 02 Jan 2006 15:04:05 GMT"},
+			wantStatusCode:   http.StatusPartialContent,
+			wantBody:         "0",
+			wantContentRange: "bytes 0-0/20",
+		},
+		{
+			name:           "test if-range not matching etag",
+			headers:        map[string]string{"Range": "bytes=0-0", "If-Range": `"v0"`},
+			wantStatusCode: http.StatusOK,
+			wantBody:       body,
+		},
+		{
+			name:           "test if-range with weak etag",
+			headers:        map[string]string{"Range": "bytes=0-0", "If-Range": `W/"v1"`},
+			wantStatusCode: http.StatusOK,
+			wantBody:       body,
+		},
+		{
+			name:           "test multiple ranges",
+			headers:        map[string]string{"Range": "bytes=0-0,2-3"},
+			wantStatusCode: http.StatusOK,
+			wantBody:       body,
+		},
+		{
+			name:           "test range of post request",
+			method:         "POST",
+			headers:        map[string]string{"Range": "bytes=0-0"},
+			wantStatusCode: http.StatusOK,
+			wantBody:       body,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := newRequest(t, method, "/")
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, req); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.wantStatusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.wantStatusCode)
+			}
+			if diff := cmp.Diff(tc.wantBody, string(resp.body)); diff != "" {
+				t.Fatalf("unexpected body mismatch (-want +got):\n%s", diff)
+			}
+			if got := resp.Header().Get("Content-Range"); got != tc.wantContentRange {
+				t.Fatalf("unexpected Content-Range: got %q, want %q", got, tc.wantContentRange)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
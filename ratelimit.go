@@ -0,0 +1,171 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// RateLimit holds the config of the rate limit of the function. The
+// requests exceeding the number of requests per window are rejected with
+// 429 and the Retry-After and RateLimit-* headers.
+type RateLimit struct {
+	Requests uint           `json:"requests,omitempty"`
+	Window   caddy.Duration `json:"window,omitempty"`
+}
+
+// rateLimiter counts the requests of the function in fixed windows.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  uint
+	window time.Duration
+	// start is the start of the current window.
+	start time.Time
+	count uint
+	now   func() time.Time
+}
+
+func newRateLimiter(cfg *RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:  cfg.Requests,
+		window: time.Duration(cfg.Window),
+		now:    time.Now,
+	}
+}
+
+// rateLimitStatus is the state of the rate limit after a request.
+type rateLimitStatus struct {
+	limit     uint
+	remaining uint
+	window    time.Duration
+	// reset is the time until the current window ends.
+	reset time.Duration
+}
+
+// allow returns true when the request is within the rate limit, and the
+// state of the limit.
+func (rl *rateLimiter) allow() (bool, *rateLimitStatus) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	if now.Sub(rl.start) >= rl.window {
+		rl.start = now
+		rl.count = 0
+	}
+	status := &rateLimitStatus{
+		limit:  rl.limit,
+		window: rl.window,
+		reset:  rl.window - now.Sub(rl.start),
+	}
+	if rl.count >= rl.limit {
+		return false, status
+	}
+	rl.count++
+	status.remaining = rl.limit - rl.count
+	return true, status
+}
+
+// writeHeaders writes the Retry-After header and the RateLimit-* headers
+// of draft-ietf-httpapi-ratelimit-headers. The times are in seconds,
+// rounded up.
+func (status *rateLimitStatus) writeHeaders(resp http.ResponseWriter) {
+	reset := strconv.Itoa(int(math.Ceil(status.reset.Seconds())))
+	h := resp.Header()
+	h.Set("Retry-After", reset)
+	h.Set("RateLimit-Limit", strconv.FormatUint(uint64(status.limit), 10))
+	h.Set("RateLimit-Remaining", strconv.FormatUint(uint64(status.remaining), 10))
+	h.Set("RateLimit-Reset", reset)
+	h.Set("RateLimit-Policy", strconv.FormatUint(uint64(status.limit), 10)+";w="+strconv.Itoa(int(math.Ceil(status.window.Seconds()))))
+}
+
+// rateLimitedWriter is the response writer of a stream, which limits the
+// rate of the bytes written to the client, so that a slow client reading
+// a large stream does not hog the shared egress.
+type rateLimitedWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+	// rate is the max number of bytes written per second.
+	rate int64
+	// chunkSize is the max number of bytes written at once.
+	chunkSize int
+	// next is the time the next chunk may be written at.
+	next time.Time
+}
+
+func newRateLimitedWriter(ctx context.Context, resp http.ResponseWriter, rate int64) *rateLimitedWriter {
+	chunkSize := int(rate / 10)
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	return &rateLimitedWriter{ResponseWriter: resp, ctx: ctx, rate: rate, chunkSize: chunkSize}
+}
+
+// Write writes the bytes in chunks, each sent once the previous chunks fit
+// in the rate. The time the stream is idle, e.g. when the handler computes
+// the next record, does not accumulate into a burst.
+func (lw *rateLimitedWriter) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		n := lw.chunkSize
+		if n > len(b) {
+			n = len(b)
+		}
+		if err := lw.wait(); err != nil {
+			return written, err
+		}
+		m, err := lw.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		lw.next = lw.next.Add(time.Duration(int64(n) * int64(time.Second) / lw.rate))
+		if len(b) > n {
+			// The chunk is sent before waiting for the next one.
+			http.NewResponseController(lw.ResponseWriter).Flush()
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// wait blocks until the next chunk may be written, or the client goes away.
+func (lw *rateLimitedWriter) wait() error {
+	now := time.Now()
+	if lw.next.Before(now) {
+		lw.next = now
+		return nil
+	}
+	timer := time.NewTimer(lw.next.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-lw.ctx.Done():
+		return lw.ctx.Err()
+	}
+}
+
+// Unwrap returns the underlying response writer, so that the stream is
+// flushed via http.ResponseController.
+func (lw *rateLimitedWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rl := newRateLimiter(&RateLimit{Requests: 2, Window: caddy.Duration(10 * time.Second)})
+	rl.now = func() time.Time { return now }
+
+	for i, tc := range []struct {
+		name      string
+		advance   time.Duration
+		allowed   bool
+		remaining uint
+		reset     time.Duration
+	}{
+		{name: "test first request", allowed: true, remaining: 1, reset: 10 * time.Second},
+		{name: "test second request", advance: 2 * time.Second, allowed: true, remaining: 0, reset: 8 * time.Second},
+		{name: "test request over limit", advance: 3 * time.Second, reset: 5 * time.Second},
+		{name: "test request in next window", advance: 5 * time.Second, allowed: true, remaining: 1, reset: 10 * time.Second},
+	} {
+		now = now.Add(tc.advance)
+		allowed, status := rl.allow()
+		if allowed != tc.allowed {
+			t.Fatalf("test %d (%s): unexpected allow(): got %t, want %t", i, tc.name, allowed, tc.allowed)
+		}
+		if status.remaining != tc.remaining || status.reset != tc.reset {
+			t.Fatalf("test %d (%s): unexpected status: remaining %d, reset %s", i, tc.name, status.remaining, status.reset)
+		}
+		t.Logf("PASS: Test %d", i)
+	}
+}
+
+func TestFunctionExecutorRateLimit(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		rate_limit 2 1m
+	}`)
+	defer fex.Cleanup()
+
+	for i := 0; i < 2; i++ {
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+			t.Fatalf("unexpected invoke() error: %v", err)
+		}
+		if resp.statusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusOK)
+		}
+	}
+
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusTooManyRequests)
+	}
+	reset, err := strconv.Atoi(resp.Header().Get("RateLimit-Reset"))
+	if err != nil || reset < 1 || reset > 60 {
+		t.Fatalf("unexpected RateLimit-Reset header: %q", resp.Header().Get("RateLimit-Reset"))
+	}
+	want := map[string]string{
+		"Retry-After":         strconv.Itoa(reset),
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Policy":    "2;w=60",
+	}
+	got := make(map[string]string)
+	for k := range want {
+		got[k] = resp.Header().Get(k)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected headers mismatch (-want +got):\n%s", diff)
+	}
+}
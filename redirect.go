@@ -0,0 +1,95 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// defaultSignedRedirectTTL is the time a signed URL is valid for when the
+// handler does not set the ttl of redirect_signed.
+const defaultSignedRedirectTTL = 5 * time.Minute
+
+// The query parameters appended to the signed URL.
+const (
+	signedURLExpiresParam   = "expires"
+	signedURLSignatureParam = "signature"
+)
+
+// signedRedirect is the redirect returned by a handler via redirect_signed.
+// The ttl is the number of seconds the signed URL is valid for.
+type signedRedirect struct {
+	URL string `json:"url"`
+	TTL int    `json:"ttl"`
+}
+
+// signRedirect sets the location of the response to the signed URL of the
+// redirect returned by the handler via redirect_signed.
+func (fex *FunctionExecutor) signRedirect(r *workerResponse) error {
+	if fex.RedirectSigningKey == "" {
+		r.StatusCode = http.StatusInternalServerError
+		return fmt.Errorf("%w: redirect_signing_key is not set", errSignedRedirect)
+	}
+	ttl := time.Duration(r.RedirectSigned.TTL) * time.Second
+	switch {
+	case ttl == 0:
+		ttl = defaultSignedRedirectTTL
+	case ttl < 0:
+		r.StatusCode = http.StatusInternalServerError
+		return fmt.Errorf("%w: negative ttl %d", errSignedRedirect, r.RedirectSigned.TTL)
+	}
+	location, err := signURL([]byte(fex.RedirectSigningKey), r.RedirectSigned.URL, time.Now().Add(ttl))
+	if err != nil {
+		r.StatusCode = http.StatusInternalServerError
+		return err
+	}
+	r.Location = location
+	r.RedirectSigned = nil
+	return nil
+}
+
+// signURL appends the expires and the signature query parameters to the
+// URL. The expires parameter is the Unix time the URL expires at, and the
+// signature is the unpadded base64url encoded HMAC-SHA256 of the URL
+// preceding the signature parameter, without the fragment, computed with
+// the key.
+func signURL(key []byte, rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errSignedRedirect, err)
+	}
+	q := u.Query()
+	q.Del(signedURLSignatureParam)
+	q.Set(signedURLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	u.RawQuery = q.Encode()
+	// The fragment is not sent to the server, so it is not signed.
+	fragment := u.EscapedFragment()
+	u.Fragment, u.RawFragment = "", ""
+	s := u.String()
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	s += "&" + signedURLSignatureParam + "=" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if fragment != "" {
+		s += "#" + fragment
+	}
+	return s, nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// verifySignedURL returns the time the signed URL expires at, or an error
+// when the signature does not match.
+func verifySignedURL(key, location string) (time.Time, error) {
+	location, _, _ = strings.Cut(location, "#")
+	i := strings.LastIndex(location, "&"+signedURLSignatureParam+"=")
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("signature is missing in %s", location)
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(location[:i]))
+	want := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if got := location[i+len(signedURLSignatureParam)+2:]; got != want {
+		return time.Time{}, fmt.Errorf("signature mismatch: got %s, want %s", got, want)
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expires, err := strconv.ParseInt(u.Query().Get(signedURLExpiresParam), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(expires, 0), nil
+}
+
+func TestSignURL(t *testing.T) {
+	expires := time.Unix(1700000000, 0)
+	for i, tc := range []struct {
+		name string
+		url  string
+		want string
+	}{
+		{
+			name: "test url without query",
+			url:  "https://cdn.example.com/files/report.pdf",
+			want: "https://cdn.example.com/files/report.pdf?expires=1700000000&signature=",
+		},
+		{
+			name: "test url with query",
+			url:  "https://cdn.example.com/files/report.pdf?v=2",
+			want: "https://cdn.example.com/files/report.pdf?expires=1700000000&v=2&signature=",
+		},
+		{
+			name: "test url with previous signature",
+			url:  "https://cdn.example.com/files/report.pdf?expires=1&signature=foo",
+			want: "https://cdn.example.com/files/report.pdf?expires=1700000000&signature=",
+		},
+		{
+			name: "test url with fragment",
+			url:  "/files/report.pdf#page=2",
+			want: "/files/report.pdf?expires=1700000000&signature=",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := signURL([]byte("secret"), tc.url, expires)
+			if err != nil {
+				t.Fatalf("unexpected signURL() error: %v", err)
+			}
+			if !strings.HasPrefix(got, tc.want) {
+				t.Fatalf("unexpected signed URL: got %s, want prefix %s", got, tc.want)
+			}
+			if _, fragment, found := strings.Cut(tc.url, "#"); found && !strings.HasSuffix(got, "#"+fragment) {
+				t.Fatalf("unexpected signed URL: got %s, want fragment %s", got, fragment)
+			}
+			gotExpires, err := verifySignedURL("secret", got)
+			if err != nil {
+				t.Fatalf("unexpected verifySignedURL() error: %v", err)
+			}
+			if !gotExpires.Equal(expires) {
+				t.Fatalf("unexpected expiry: got %s, want %s", gotExpires, expires)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorSignedRedirect(t *testing.T) {
+	for i, tc := range []struct {
+		name           string
+		function       string
+		key            string
+		wantStatusCode int
+		wantTTL        time.Duration
+		wantURL        string
+	}{
+		{
+			name:           "test signed redirect",
+			function:       "handler",
+			key:            "secret",
+			wantStatusCode: http.StatusFound,
+			wantTTL:        300 * time.Second,
+			wantURL:        "https://cdn.example.com/files/report.pdf?expires=",
+		},
+		{
+			name:           "test signed redirect with default ttl and status code",
+			function:       "default_ttl_handler",
+			key:            "secret",
+			wantStatusCode: http.StatusTemporaryRedirect,
+			wantTTL:        defaultSignedRedirectTTL,
+			wantURL:        "https://cdn.example.com/files/report.pdf?expires=",
+		},
+		{
+			name:           "test signed redirect without signing key",
+			function:       "handler",
+			wantStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:           "test malformed signed redirect",
+			function:       "malformed_handler",
+			key:            "secret",
+			wantStatusCode: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := fmt.Sprintf(`
+			lambda {
+				name redirect_signed
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/redirect_signed/app/index.py
+				function %s
+			`, tc.function)
+			if tc.key != "" {
+				cfg += "redirect_signing_key " + tc.key + "\n"
+			}
+			fex := newTestFunctionExecutor(t, cfg+"}")
+			defer fex.Cleanup()
+
+			start := time.Now()
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.wantStatusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.wantStatusCode)
+			}
+			location := resp.Header().Get("Location")
+			if tc.wantURL == "" {
+				if diff := cmp.Diff("", location); diff != "" {
+					t.Fatalf("unexpected Location header mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if !strings.HasPrefix(location, tc.wantURL) {
+				t.Fatalf("unexpected Location header: got %s, want prefix %s", location, tc.wantURL)
+			}
+			expires, err := verifySignedURL(tc.key, location)
+			if err != nil {
+				t.Fatalf("unexpected verifySignedURL() error: %v", err)
+			}
+			if d := expires.Sub(start); d < tc.wantTTL-time.Second || d > tc.wantTTL+time.Second {
+				t.Fatalf("unexpected expiry: got %s, want %s after the request", d, tc.wantTTL)
+			}
+			if _, err := verifySignedURL("other", location); err == nil {
+				t.Fatalf("unexpected signature match with another key: %s", location)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// executorRegistry tracks the live function executors of the server.
+type executorRegistry struct {
+	mu sync.Mutex
+	// executors maps the executors to the context of the config they
+	// were provisioned with. When the config is reloaded, the executors
+	// of the old and the new config are live at the same time.
+	executors map[*FunctionExecutor]context.Context
+}
+
+// registry is the registry of the function executors of the server.
+var registry = &executorRegistry{
+	executors: make(map[*FunctionExecutor]context.Context),
+}
+
+// executorStats holds the worker counts of a function executor.
+type executorStats struct {
+	Name             string `json:"name"`
+	Runtime          string `json:"runtime"`
+	PythonExecutable string `json:"python_executable"`
+	Workers          int    `json:"workers"`
+	BusyWorkers      int    `json:"busy_workers"`
+	// CircuitBreaker is the state of the circuit breaker, if enabled.
+	CircuitBreaker string `json:"circuit_breaker,omitempty"`
+}
+
+// registryStats holds the worker counts of all function executors.
+type registryStats struct {
+	Executors    []*executorStats `json:"executors"`
+	TotalWorkers int              `json:"total_workers"`
+}
+
+// executorInfo holds the configuration of a function executor.
+type executorInfo struct {
+	Name             string `json:"name"`
+	Runtime          string `json:"runtime"`
+	PythonExecutable string `json:"python_executable"`
+	Entrypoint       string `json:"entrypoint"`
+	Handler          string `json:"handler"`
+	// Workers is the configured max number of workers.
+	Workers   uint   `json:"workers"`
+	URIFilter string `json:"uri_filter,omitempty"`
+}
+
+// register adds the executor to the registry. It returns an error when the
+// number of workers of the executors of the same config would exceed
+// the max_total_workers limit set by any of them.
+func (r *executorRegistry) register(ctx context.Context, fex *FunctionExecutor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limit := fex.MaxTotalWorkers
+	total := fex.getRequiredWorkersCount()
+	for e, c := range r.executors {
+		if c != ctx {
+			continue
+		}
+		total += e.getRequiredWorkersCount()
+		if e.MaxTotalWorkers > 0 && (limit == 0 || e.MaxTotalWorkers < limit) {
+			limit = e.MaxTotalWorkers
+		}
+	}
+	if limit > 0 && total > limit {
+		return fmt.Errorf("total number of lambda workers %d exceeds max_total_workers %d", total, limit)
+	}
+	r.executors[fex] = ctx
+	return nil
+}
+
+// unregister removes the executor from the registry.
+func (r *executorRegistry) unregister(fex *FunctionExecutor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.executors, fex)
+}
+
+// getExecutors returns the registered executors with the name. When the
+// config is being reloaded, the executors of both configs are returned.
+func (r *executorRegistry) getExecutors(name string) []*FunctionExecutor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var executors []*FunctionExecutor
+	for fex := range r.executors {
+		if fex.Name == name {
+			executors = append(executors, fex)
+		}
+	}
+	return executors
+}
+
+// getStats returns the worker counts of the registered executors.
+func (r *executorRegistry) getStats() *registryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := &registryStats{
+		Executors: []*executorStats{},
+	}
+	for fex := range r.executors {
+		entry := &executorStats{
+			Name:             fex.Name,
+			Runtime:          fex.Runtime,
+			PythonExecutable: fex.PythonExecutable,
+		}
+		for _, p := range fex.getWorkerPools() {
+			total, busy := p.getCounts()
+			entry.Workers += total
+			entry.BusyWorkers += busy
+		}
+		if fex.breaker != nil {
+			entry.CircuitBreaker = fex.breaker.getState().String()
+		}
+		stats.TotalWorkers += entry.Workers
+		stats.Executors = append(stats.Executors, entry)
+	}
+	sort.Slice(stats.Executors, func(i, j int) bool {
+		return stats.Executors[i].Name < stats.Executors[j].Name
+	})
+	return stats
+}
+
+// getFunctions returns the configuration of the registered executors.
+func (r *executorRegistry) getFunctions() []*executorInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	functions := []*executorInfo{}
+	for fex := range r.executors {
+		functions = append(functions, &executorInfo{
+			Name:             fex.Name,
+			Runtime:          fex.Runtime,
+			PythonExecutable: fex.PythonExecutable,
+			Entrypoint:       fex.EntrypointPath,
+			Handler:          fex.EntrypointHandler,
+			Workers:          fex.MaxWorkersCount,
+			URIFilter:        fex.URIFilter,
+		})
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Name != functions[j].Name {
+			return functions[i].Name < functions[j].Name
+		}
+		return functions[i].Entrypoint < functions[j].Entrypoint
+	})
+	return functions
+}
@@ -0,0 +1,325 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zapcore"
+)
+
+func provisionRegistryTestExecutor(ctx context.Context, name, workers, maxTotal string) (*FunctionExecutor, error) {
+	config := `
+	lambda {
+		name ` + name + `
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers ` + workers
+	if maxTotal != "" {
+		config += `
+		max_total_workers ` + maxTotal
+	}
+	config += `
+	}`
+	fex := &FunctionExecutor{}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)); err != nil {
+		return nil, err
+	}
+	if err := fex.Provision(caddy.Context{Context: ctx}); err != nil {
+		fex.Cleanup()
+		return nil, err
+	}
+	return fex, nil
+}
+
+func getRegistryTestStats(t *testing.T, prefix string) []*executorStats {
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/lambda/stats", nil)
+	if err := (AdminAPI{}).handleStats(resp, req); err != nil {
+		t.Fatalf("unexpected handleStats() error: %v", err)
+	}
+	stats := &registryStats{}
+	if err := json.Unmarshal(resp.Body.Bytes(), stats); err != nil {
+		t.Fatalf("unexpected stats %q: %v", resp.Body.String(), err)
+	}
+	var entries []*executorStats
+	for _, entry := range stats.Executors {
+		if strings.HasPrefix(entry.Name, prefix) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestExecutorRegistry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	foo, err := provisionRegistryTestExecutor(ctx, "registry_foo", "2", "4")
+	if err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer foo.Cleanup()
+	bar, err := provisionRegistryTestExecutor(ctx, "registry_bar", "1", "")
+	if err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer bar.Cleanup()
+
+	want := []*executorStats{
+		{Name: "registry_bar", Runtime: "python", PythonExecutable: "python", Workers: 1},
+		{Name: "registry_foo", Runtime: "python", PythonExecutable: "python", Workers: 2},
+	}
+	if diff := cmp.Diff(want, getRegistryTestStats(t, "registry_")); diff != "" {
+		t.Fatalf("unexpected stats mismatch (-want +got):\n%s", diff)
+	}
+
+	// The executor would exceed the limit of 4 workers set by registry_foo.
+	if _, err := provisionRegistryTestExecutor(ctx, "registry_baz", "2", ""); err == nil {
+		t.Fatalf("unexpected Provision() success")
+	} else if !strings.Contains(err.Error(), "total number of lambda workers 5 exceeds max_total_workers 4") {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+
+	// The executors of another config, e.g. after reload, are not counted.
+	otherCtx, otherCancel := context.WithCancel(context.Background())
+	defer otherCancel()
+	baz, err := provisionRegistryTestExecutor(otherCtx, "registry_baz", "2", "")
+	if err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer baz.Cleanup()
+
+	bar.Cleanup()
+	want = []*executorStats{
+		{Name: "registry_baz", Runtime: "python", PythonExecutable: "python", Workers: 2},
+		{Name: "registry_foo", Runtime: "python", PythonExecutable: "python", Workers: 2},
+	}
+	if diff := cmp.Diff(want, getRegistryTestStats(t, "registry_")); diff != "" {
+		t.Fatalf("unexpected stats mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAdminFunctions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	foo, err := provisionRegistryTestExecutor(ctx, "functions_foo", "2", "")
+	if err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer foo.Cleanup()
+	bar, err := provisionRegistryTestExecutor(ctx, "functions_bar", "1", "")
+	if err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer bar.Cleanup()
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/lambda/functions", nil)
+	if err := (AdminAPI{}).handleFunctions(resp, req); err != nil {
+		t.Fatalf("unexpected handleFunctions() error: %v", err)
+	}
+	var functions []*executorInfo
+	if err := json.Unmarshal(resp.Body.Bytes(), &functions); err != nil {
+		t.Fatalf("unexpected functions %q: %v", resp.Body.String(), err)
+	}
+	var got []*executorInfo
+	for _, entry := range functions {
+		if strings.HasPrefix(entry.Name, "functions_") {
+			got = append(got, entry)
+		}
+	}
+
+	var want []*executorInfo
+	for _, fex := range []*FunctionExecutor{bar, foo} {
+		want = append(want, &executorInfo{
+			Name:             fex.Name,
+			Runtime:          "python",
+			PythonExecutable: "python",
+			Entrypoint:       "assets/scripts/api/hello_world/app/index.py",
+			Handler:          "handler",
+			Workers:          fex.MaxWorkersCount,
+		})
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected functions mismatch (-want +got):\n%s", diff)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/lambda/functions", nil)
+	err = (AdminAPI{}).handleFunctions(httptest.NewRecorder(), req)
+	var apiErr caddy.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatus != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected handleFunctions() error: %v", err)
+	}
+}
+
+func TestAdminRestart(t *testing.T) {
+	fex, err := provisionRegistryTestExecutor(context.Background(), "registry_restart", "2", "")
+	if err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer fex.Cleanup()
+	workers := fex.workers.getWorkers()
+
+	for i, tc := range []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{name: "test unknown function", method: http.MethodPost, path: "/lambda/registry_unknown/restart", want: http.StatusNotFound},
+		{name: "test unknown resource", method: http.MethodPost, path: "/lambda/registry_restart/reload", want: http.StatusNotFound},
+		{name: "test get method", method: http.MethodGet, path: "/lambda/registry_restart/restart", want: http.StatusMethodNotAllowed},
+		{name: "test restart", method: http.MethodPost, path: "/lambda/registry_restart/restart", want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			err := (AdminAPI{}).handleFunction(resp, httptest.NewRequest(tc.method, tc.path, nil))
+			got := resp.Code
+			var apiErr caddy.APIError
+			if errors.As(err, &apiErr) {
+				got = apiErr.HTTPStatus
+			}
+			if got != tc.want {
+				t.Fatalf("unexpected status code: got %d, want %d (error: %v)", got, tc.want, err)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+
+	restarted := fex.workers.getWorkers()
+	if len(restarted) != len(workers) {
+		t.Fatalf("unexpected number of workers: got %d, want %d", len(restarted), len(workers))
+	}
+	for i, w := range restarted {
+		if w == workers[i] || !workers[i].Terminated {
+			t.Fatalf("worker %d was not replaced", workers[i].ID)
+		}
+	}
+
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code after restart: %d", resp.statusCode)
+	}
+}
+
+func TestAdminHealth(t *testing.T) {
+	flag := filepath.Join(t.TempDir(), "down")
+	t.Setenv("HEALTH_DOWN_FLAG", flag)
+
+	getHealth := func(t *testing.T, name string) (int, []*healthStatus) {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/lambda/"+name+"/health", nil)
+		if err := (AdminAPI{}).handleFunction(resp, req); err != nil {
+			t.Fatalf("unexpected handleFunction() error: %v", err)
+		}
+		var statuses []*healthStatus
+		if err := json.Unmarshal(resp.Body.Bytes(), &statuses); err != nil {
+			t.Fatalf("unexpected health %q: %v", resp.Body.String(), err)
+		}
+		return resp.Code, statuses
+	}
+
+	for i, tc := range []struct {
+		name       string
+		config     string
+		down       bool
+		wantCode   int
+		wantStatus *healthStatus
+	}{
+		{
+			name:       "test ready without health function",
+			wantCode:   http.StatusOK,
+			wantStatus: &healthStatus{Name: "registry_health", Ready: true, Workers: 1},
+		},
+		{
+			name:       "test ready",
+			config:     "health_function health",
+			wantCode:   http.StatusOK,
+			wantStatus: &healthStatus{Name: "registry_health", Ready: true, Workers: 1, StatusCode: http.StatusOK},
+		},
+		{
+			name:     "test not ready",
+			config:   "health_function health",
+			down:     true,
+			wantCode: http.StatusServiceUnavailable,
+			wantStatus: &healthStatus{
+				Name:       "registry_health",
+				Workers:    1,
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       "database is down",
+				Error:      "health function returned status code 503",
+			},
+		},
+		{
+			name:     "test health function timeout",
+			config:   "health_function slow_health\n health_timeout 500ms",
+			wantCode: http.StatusServiceUnavailable,
+			wantStatus: &healthStatus{
+				Name:       "registry_health",
+				Workers:    1,
+				StatusCode: http.StatusRequestTimeout,
+				Error:      errWorkerTimeout.Error(),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name registry_health
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/health/app/index.py
+				function handler
+				workers 1
+				`+tc.config+`
+			}`)
+			defer fex.Cleanup()
+
+			if tc.down {
+				if err := os.WriteFile(flag, nil, 0600); err != nil {
+					t.Fatalf("unexpected error creating flag: %v", err)
+				}
+				defer os.Remove(flag)
+			}
+			code, statuses := getHealth(t, "registry_health")
+			if code != tc.wantCode {
+				t.Fatalf("unexpected status code: got %d, want %d", code, tc.wantCode)
+			}
+			if diff := cmp.Diff([]*healthStatus{tc.wantStatus}, statuses); diff != "" {
+				t.Fatalf("unexpected health mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
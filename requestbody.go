@@ -0,0 +1,181 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// bodyTransport values control how the request body is passed to a handler.
+const (
+	// bodyTransportJSON passes the body in the request data, base64 encoded.
+	bodyTransportJSON = "json"
+	// bodyTransportFD passes the raw body on file descriptor 3 of the
+	// worker. The bootstrap reads it before invoking the handler.
+	bodyTransportFD = "fd"
+)
+
+// bodyType values control the type of the body received by a handler.
+const (
+	// bodyTypeBytes passes the body as bytes.
+	bodyTypeBytes = "bytes"
+	// bodyTypeStr passes the body as str decoded from UTF-8.
+	bodyTypeStr = "str"
+	// bodyTypeAuto passes the body as str when the content type of the
+	// request is text, and as bytes otherwise.
+	bodyTypeAuto = "auto"
+)
+
+// defaultMaxBodySize is the default max size of the request body.
+const defaultMaxBodySize = 10 << 20
+
+// readRequestBody reads the request body, up to max_body_size bytes.
+func (fex *FunctionExecutor) readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return []byte{}, nil
+	}
+	b, err := io.ReadAll(io.LimitReader(req.Body, fex.MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading request body: %v", err)
+	}
+	if int64(len(b)) > fex.MaxBodySize {
+		return nil, errRequestBodyTooLarge
+	}
+	return b, nil
+}
+
+// decompressRequestBody replaces the gzip or deflate encoded request body
+// with the decompressed one, and removes the Content-Encoding and
+// Content-Length headers, so that the handler receives the decoded body. The
+// bodies of other encodings are left intact. The decompressed size is
+// limited by max_body_size when the body is read.
+func decompressRequestBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	var r io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(req.Body)
+	case "deflate":
+		r, err = zlib.NewReader(req.Body)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRequestBodyEncoding, err)
+	}
+	req.Body = &decompressedBody{Reader: r, body: req.Body}
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	return nil
+}
+
+// decompressedBody is the decompressed request body. Closing it closes the
+// original body.
+type decompressedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *decompressedBody) Close() error {
+	return b.body.Close()
+}
+
+// splitRequestBody returns the raw request body and a copy of the request
+// data without it. The body passed as a string is left in the data.
+func splitRequestBody(data map[string]interface{}) ([]byte, map[string]interface{}) {
+	body, ok := data["body"].([]byte)
+	if !ok {
+		return nil, data
+	}
+	m := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if k == "body" {
+			continue
+		}
+		m[k] = v
+	}
+	return body, m
+}
+
+// encodeRequestBody returns the body and the is_base64_encoded value of the
+// request data. The body passed as a string is received by the handler as
+// str. Otherwise, the raw bytes are passed base64 encoded, or on file
+// descriptor 3, and the bootstrap decodes them to bytes when body_type is
+// set.
+func (fex *FunctionExecutor) encodeRequestBody(contentType string, body []byte) (interface{}, bool) {
+	bodyType := fex.BodyType
+	if bodyType == bodyTypeAuto {
+		bodyType = bodyTypeBytes
+		if isTextContentType(contentType) {
+			bodyType = bodyTypeStr
+		}
+	}
+	if bodyType == bodyTypeStr {
+		return strings.ToValidUTF8(string(body), "\uFFFD"), false
+	}
+	return body, fex.BodyTransport != bodyTransportFD
+}
+
+// isTextContentType returns true when the content type is text, e.g.
+// text/plain, application/json, or the one with the charset parameter.
+func isTextContentType(s string) bool {
+	mediaType, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return false
+	}
+	if _, found := params["charset"]; found {
+		return true
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// isContentTypeAccepted returns true when the request has no body, or the
+// content type of the request matches accept_content_types, if any. The
+// types ending with /*, e.g. text/*, match all subtypes.
+func (fex *FunctionExecutor) isContentTypeAccepted(req *http.Request) bool {
+	if len(fex.AcceptContentTypes) == 0 || req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, s := range fex.AcceptContentTypes {
+		if s == mediaType || s == "*/*" {
+			return true
+		}
+		if prefix, found := strings.CutSuffix(s, "/*"); found && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,450 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newBodyTestFunctionExecutor(t testing.TB, transport string) *FunctionExecutor {
+	return newTestFunctionExecutor(t, `
+	lambda {
+		name body
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/body/app/index.py
+		function handler
+		body_transport `+transport+`
+		max_body_size 1MiB
+	}`)
+}
+
+func TestFunctionExecutorRequestBody(t *testing.T) {
+	binary := []byte{0x00, 0xff, 0x0a, 0x0d, 'h', 'i', 0x80}
+	large := make([]byte, 256<<10)
+	rand.Read(large)
+
+	for i, tc := range []struct {
+		name       string
+		transport  string
+		body       []byte
+		statusCode int
+	}{
+		{
+			name:       "test binary body in json transport",
+			transport:  "json",
+			body:       binary,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test binary body in fd transport",
+			transport:  "fd",
+			body:       binary,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test empty body in fd transport",
+			transport:  "fd",
+			body:       []byte{},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test body larger than pipe buffer in fd transport",
+			transport:  "fd",
+			body:       large,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test body larger than max_body_size",
+			transport:  "json",
+			body:       make([]byte, 1<<20+1),
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newBodyTestFunctionExecutor(t, tc.transport)
+			defer fex.Cleanup()
+
+			// The second request ensures no body bytes are left in the pipe.
+			for j := 0; j < 2; j++ {
+				req := newRequest(t, "POST", "/")
+				req.Body = io.NopCloser(bytes.NewReader(tc.body))
+				resp := newResponseWriter(fex.logger)
+				if err := fex.invoke(resp, req); err != nil {
+					t.Fatalf("unexpected invoke() error: %v", err)
+				}
+				if resp.statusCode != tc.statusCode {
+					t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+				}
+				if tc.statusCode != http.StatusOK {
+					break
+				}
+
+				var got map[string]interface{}
+				if err := json.Unmarshal(resp.body, &got); err != nil {
+					t.Fatalf("unexpected body %q: %v", resp.body, err)
+				}
+				sum := sha256.Sum256(tc.body)
+				want := map[string]interface{}{
+					"size":   float64(len(tc.body)),
+					"sha256": hex.EncodeToString(sum[:]),
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Fatalf("unexpected body mismatch (-want +got):\n%s", diff)
+				}
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorAcceptContentTypes(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name body
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/body/app/index.py
+		function handler
+		accept_content_types application/json text/*
+	}`)
+	defer fex.Cleanup()
+
+	for i, tc := range []struct {
+		name        string
+		contentType string
+		body        []byte
+		statusCode  int
+	}{
+		{
+			name:        "test accepted content type",
+			contentType: "application/json",
+			body:        []byte(`{"foo": "bar"}`),
+			statusCode:  http.StatusOK,
+		},
+		{
+			name:        "test accepted content type with parameters",
+			contentType: "Application/JSON; charset=utf-8",
+			body:        []byte(`{"foo": "bar"}`),
+			statusCode:  http.StatusOK,
+		},
+		{
+			name:        "test accepted content subtype",
+			contentType: "text/csv",
+			body:        []byte("foo,bar"),
+			statusCode:  http.StatusOK,
+		},
+		{
+			name:       "test request without body",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:        "test rejected content type",
+			contentType: "application/xml",
+			body:        []byte("<foo/>"),
+			statusCode:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "test body without content type",
+			body:       []byte("foo"),
+			statusCode: http.StatusUnsupportedMediaType,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newRequest(t, "POST", "/")
+			if tc.body != nil {
+				req.Body = io.NopCloser(bytes.NewReader(tc.body))
+			}
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, req); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorDecompressRequest(t *testing.T) {
+	text := []byte(`{"foo": "bar"}`)
+	compress := func(encoding string, b []byte) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser = gzip.NewWriter(&buf)
+		if encoding == "deflate" {
+			w = zlib.NewWriter(&buf)
+		}
+		w.Write(b)
+		w.Close()
+		return buf.Bytes()
+	}
+
+	for i, tc := range []struct {
+		name       string
+		disabled   bool
+		encoding   string
+		body       []byte
+		want       []byte
+		statusCode int
+	}{
+		{
+			name:       "test gzip body",
+			encoding:   "gzip",
+			body:       compress("gzip", text),
+			want:       text,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test deflate body",
+			encoding:   "deflate",
+			body:       compress("deflate", text),
+			want:       text,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test identity body",
+			body:       text,
+			want:       text,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test gzip body with decompression disabled",
+			disabled:   true,
+			encoding:   "gzip",
+			body:       compress("gzip", text),
+			want:       compress("gzip", text),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "test gzip body larger than max_body_size once decompressed",
+			encoding:   "gzip",
+			body:       compress("gzip", make([]byte, 64<<20)),
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "test malformed gzip body",
+			encoding:   "gzip",
+			body:       text,
+			statusCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			directive := "decompress_request"
+			if tc.disabled {
+				directive = ""
+			}
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name body
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/body/app/index.py
+				function handler
+				max_body_size 1MiB
+				`+directive+`
+			}`)
+			defer fex.Cleanup()
+
+			req := newRequest(t, "POST", "/")
+			req.Body = io.NopCloser(bytes.NewReader(tc.body))
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, req); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			if tc.statusCode != http.StatusOK {
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(resp.body, &got); err != nil {
+				t.Fatalf("unexpected body %q: %v", resp.body, err)
+			}
+			sum := sha256.Sum256(tc.want)
+			want := map[string]interface{}{
+				"size":   float64(len(tc.want)),
+				"sha256": hex.EncodeToString(sum[:]),
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("unexpected body mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func BenchmarkRequestBodyTransport(b *testing.B) {
+	body := make([]byte, 512<<10)
+	rand.Read(body)
+
+	for _, transport := range []string{"json", "fd"} {
+		b.Run(transport, func(b *testing.B) {
+			fex := newBodyTestFunctionExecutor(b, transport)
+			defer fex.Cleanup()
+
+			b.SetBytes(int64(len(body)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req, _ := http.NewRequest("POST", "/", bytes.NewReader(body))
+				req.RequestURI = "/"
+				resp := newResponseWriter(fex.logger)
+				if err := fex.invoke(resp, req); err != nil {
+					b.Fatalf("unexpected invoke() error: %v", err)
+				}
+				if resp.statusCode != http.StatusOK {
+					b.Fatalf("unexpected status code: %d", resp.statusCode)
+				}
+			}
+		})
+	}
+}
+
+func TestFunctionExecutorRequestBodyType(t *testing.T) {
+	text := []byte("héllo, world\n")
+	binary := []byte{0x00, 0xff, 0x0a, 0x0d, 'h', 'i', 0x80}
+	sha := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	for i, tc := range []struct {
+		name        string
+		bodyType    string
+		transport   string
+		contentType string
+		body        []byte
+		want        map[string]interface{}
+	}{
+		{
+			name:        "test text body as bytes",
+			bodyType:    "bytes",
+			transport:   "json",
+			contentType: "text/plain",
+			body:        text,
+			want:        map[string]interface{}{"type": "bytes", "is_base64_encoded": false, "sha256": sha(text)},
+		},
+		{
+			name:        "test binary body as bytes",
+			bodyType:    "bytes",
+			transport:   "json",
+			contentType: "application/octet-stream",
+			body:        binary,
+			want:        map[string]interface{}{"type": "bytes", "is_base64_encoded": false, "sha256": sha(binary)},
+		},
+		{
+			name:        "test text body as str",
+			bodyType:    "str",
+			transport:   "json",
+			contentType: "text/plain",
+			body:        text,
+			want:        map[string]interface{}{"type": "str", "is_base64_encoded": false, "sha256": sha(text)},
+		},
+		{
+			name:        "test binary body as str",
+			bodyType:    "str",
+			transport:   "json",
+			contentType: "application/octet-stream",
+			body:        binary,
+			want:        map[string]interface{}{"type": "str", "is_base64_encoded": false, "sha256": sha([]byte("\x00�\n\rhi�"))},
+		},
+		{
+			name:        "test text body as auto",
+			bodyType:    "auto",
+			transport:   "json",
+			contentType: "application/json; charset=utf-8",
+			body:        text,
+			want:        map[string]interface{}{"type": "str", "is_base64_encoded": false, "sha256": sha(text)},
+		},
+		{
+			name:        "test binary body as auto",
+			bodyType:    "auto",
+			transport:   "json",
+			contentType: "image/png",
+			body:        binary,
+			want:        map[string]interface{}{"type": "bytes", "is_base64_encoded": false, "sha256": sha(binary)},
+		},
+		{
+			name:        "test text body as str in fd transport",
+			bodyType:    "str",
+			transport:   "fd",
+			contentType: "text/plain",
+			body:        text,
+			want:        map[string]interface{}{"type": "str", "is_base64_encoded": false, "sha256": sha(text)},
+		},
+		{
+			name:        "test binary body as auto in fd transport",
+			bodyType:    "auto",
+			transport:   "fd",
+			contentType: "application/octet-stream",
+			body:        binary,
+			want:        map[string]interface{}{"type": "bytes", "is_base64_encoded": false, "sha256": sha(binary)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name body_type
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/body_type/app/index.py
+				function handler
+				body_transport `+tc.transport+`
+				body_type `+tc.bodyType+`
+			}`)
+			defer fex.Cleanup()
+
+			req := newRequest(t, "POST", "/")
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Body = io.NopCloser(bytes.NewReader(tc.body))
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, req); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != http.StatusOK {
+				t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusOK)
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(resp.body, &got); err != nil {
+				t.Fatalf("unexpected body %q: %v", resp.body, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected body mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// requestIDFormat values control the format of the generated request ids.
+const (
+	// requestIDFormatUUID is the canonical UUID, e.g.
+	// 5b3a1e8c-9d0f-4c1e-8a7b-2f6d4e9c0a1b.
+	requestIDFormatUUID = "uuid"
+	// requestIDFormatHex is the 128-bit id as 32 hex digits, e.g.
+	// 5b3a1e8c9d0f4c1e8a7b2f6d4e9c0a1b, as in the W3C trace ids.
+	requestIDFormatHex = "hex"
+	// requestIDFormatULID is the lexicographically sortable ULID, e.g.
+	// 01ARYZ6S41TSV4RRFFQ69G5FAV.
+	requestIDFormatULID = "ulid"
+)
+
+var supportedRequestIDFormats = []string{requestIDFormatUUID, requestIDFormatHex, requestIDFormatULID}
+
+// newRequestID returns a new request id in the format.
+func newRequestID(format string) string {
+	switch format {
+	case requestIDFormatHex:
+		id := uuid.New()
+		return hex.EncodeToString(id[:])
+	case requestIDFormatULID:
+		return newULID(time.Now())
+	}
+	return uuid.New().String()
+}
+
+// formatCaddyRequestID returns Caddy's request uuid in the format, so that
+// the id still matches the one of Caddy's logs, or an empty string when the
+// format cannot be derived from the uuid.
+func formatCaddyRequestID(id, format string) string {
+	switch format {
+	case requestIDFormatHex:
+		return strings.ReplaceAll(id, "-", "")
+	case requestIDFormatULID:
+		// The ULID carries the time of the request, which the uuid has not.
+		return ""
+	}
+	return id
+}
+
+// crockfordAlphabet is the base32 alphabet of the ULID.
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns the ULID of the 48-bit millisecond timestamp of the time
+// followed by 80 random bits, encoded in 26 characters.
+func newULID(t time.Time) string {
+	var b [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(t.UnixMilli()))
+	copy(b[:6], ts[2:])
+	rand.Read(b[6:])
+	return encodeULID(b)
+}
+
+func encodeULID(b [16]byte) string {
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+var requestIDPatterns = map[string]*regexp.Regexp{
+	requestIDFormatUUID: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+	requestIDFormatHex:  regexp.MustCompile(`^[0-9a-f]{32}$`),
+	requestIDFormatULID: regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+}
+
+func TestNewULID(t *testing.T) {
+	// The timestamp of the example of the ULID spec.
+	got := newULID(time.UnixMilli(1469918176385))
+	if got[:10] != "01ARYZ6S41" {
+		t.Fatalf("unexpected ULID timestamp: got %q, want %q", got[:10], "01ARYZ6S41")
+	}
+	if !requestIDPatterns[requestIDFormatULID].MatchString(got) {
+		t.Fatalf("malformed ULID: %q", got)
+	}
+	if encodeULID([16]byte{}) != "00000000000000000000000000" {
+		t.Fatalf("unexpected zero ULID: %q", encodeULID([16]byte{}))
+	}
+	var max [16]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	if got := encodeULID(max); got != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Fatalf("unexpected max ULID: %q", got)
+	}
+}
+
+func TestGetRequestIDFormat(t *testing.T) {
+	for i, tc := range []struct {
+		name     string
+		format   string
+		replacer bool
+	}{
+		{name: "test uuid request id is generated", format: requestIDFormatUUID},
+		{name: "test hex request id is generated", format: requestIDFormatHex},
+		{name: "test ulid request id is generated", format: requestIDFormatULID},
+		{name: "test hex request id is derived from caddy request uuid", format: requestIDFormatHex, replacer: true},
+		{name: "test ulid request id ignores caddy request uuid", format: requestIDFormatULID, replacer: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newRequest(t, "GET", "/")
+			ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{})
+			req = req.WithContext(ctx)
+			var caddyID string
+			if tc.replacer {
+				repl := caddyhttp.NewTestReplacer(req)
+				caddyID, _ = repl.GetString("http.request.uuid")
+			}
+
+			got := getRequestID(req, tc.format)
+			if !requestIDPatterns[tc.format].MatchString(got) {
+				t.Fatalf("request id %q does not match %s format", got, tc.format)
+			}
+			if tc.replacer && tc.format == requestIDFormatHex && got != formatCaddyRequestID(caddyID, tc.format) {
+				t.Fatalf("unexpected request id: got %q, want %q derived from %q", got, formatCaddyRequestID(caddyID, tc.format), caddyID)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorRequestIDFormat(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name signature
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/signature/app/index.py
+		function handler
+		request_id_format ulid
+	}`)
+	defer fex.Cleanup()
+
+	req := newRequest(t, "GET", "/")
+	req = req.WithContext(context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]interface{}{}))
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, req); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.statusCode)
+	}
+	var body struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(resp.body, &body); err != nil {
+		t.Fatalf("failed parsing response body %q: %v", resp.body, err)
+	}
+	if !requestIDPatterns[requestIDFormatULID].MatchString(body.RequestID) {
+		t.Fatalf("request id %q does not match ulid format", body.RequestID)
+	}
+}
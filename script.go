@@ -0,0 +1,74 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// execScript runs the entrypoint as a fresh process for the request, when
+// capture_stdout is set, e.g. for CGI-like scripts which print their output
+// instead of returning a response. The request data is written to the
+// standard input of the script as JSON. The standard output of the script
+// is the body of the response. The status code is 200 when the script
+// exits with 0, and 500 otherwise.
+func (fex *FunctionExecutor) execScript(data map[string]interface{}) (*workerResponse, error) {
+	requestID := data["request_id"].(string)
+	input, err := marshalJSON(fex.formatRequestData(data), fex.EscapeHTML)
+	if err != nil {
+		return &workerResponse{StatusCode: http.StatusBadRequest}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(fex.WorkerTimeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, fex.PythonExecutable, "-u", fex.EntrypointPath)
+	cmd.Env = append(fex.getWorkerEnv(), "LAMBDA_REQUEST_ID="+requestID)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// The output of the child processes of the script, which keep the pipes
+	// open, is not waited for after the script exits.
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	if stderr.Len() > 0 {
+		fex.logger.Debug(
+			"lambda script error output",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.String("stderr", strings.TrimSpace(stderr.String())),
+		)
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return &workerResponse{StatusCode: http.StatusOK, Body: stdout.Bytes()}, nil
+	case ctx.Err() != nil:
+		return &workerResponse{StatusCode: http.StatusRequestTimeout}, errWorkerTimeout
+	case errors.As(err, &exitErr):
+		return &workerResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("%w: script exited with code %d", errHandlerFailed, exitErr.ExitCode())
+	}
+	return &workerResponse{StatusCode: http.StatusBadGateway}, fmt.Errorf("failed running lambda script: %v", err)
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFunctionExecutorCaptureStdout(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		entrypoint string
+		statusCode int
+		body       string
+		err        error
+	}{
+		{
+			name:       "test script exits with zero",
+			entrypoint: "assets/scripts/api/capture_stdout/app/index.py",
+			statusCode: http.StatusOK,
+			body:       "hello /foo\nmethod GET\n",
+		},
+		{
+			name:       "test script exits with nonzero",
+			entrypoint: "assets/scripts/api/capture_stdout/app/fail.py",
+			statusCode: http.StatusInternalServerError,
+			err:        errHandlerFailed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name capture_stdout
+				runtime python
+				python_executable python
+				entrypoint `+tc.entrypoint+`
+				capture_stdout
+			}`)
+			defer fex.Cleanup()
+
+			if n := len(fex.getAllWorkers()); n != 0 {
+				t.Fatalf("unexpected workers: %d", n)
+			}
+			r, err := fex.execRequest(newRequest(t, "GET", "/foo"), "test-request-id")
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected execRequest() error: got %v, want %v", err, tc.err)
+			}
+			if r.StatusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", r.StatusCode, tc.statusCode)
+			}
+			if diff := cmp.Diff(tc.body, string(r.Body)); diff != "" {
+				t.Fatalf("unexpected body mismatch (-want +got):\n%s", diff)
+			}
+
+			resp := newResponseWriter(fex.logger)
+			if err := fex.invoke(resp, newRequest(t, "GET", "/foo")); err != nil {
+				t.Fatalf("unexpected invoke() error: %v", err)
+			}
+			if resp.statusCode != tc.statusCode {
+				t.Fatalf("unexpected invoke() status code: got %d, want %d", resp.statusCode, tc.statusCode)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
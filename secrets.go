@@ -0,0 +1,92 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultSecretsTTL is the default time the secrets are cached for.
+const defaultSecretsTTL = time.Minute
+
+// secretLoader loads values by key, e.g. Caddy's storage.
+type secretLoader interface {
+	Load(ctx context.Context, key string) ([]byte, error)
+}
+
+type secretEntry struct {
+	value   string
+	expires time.Time
+}
+
+// secretCache caches the values loaded from the storage for the TTL.
+type secretCache struct {
+	mu      sync.Mutex
+	loader  secretLoader
+	ttl     time.Duration
+	entries map[string]secretEntry
+}
+
+func newSecretCache(loader secretLoader, ttl time.Duration) *secretCache {
+	return &secretCache{
+		loader:  loader,
+		ttl:     ttl,
+		entries: make(map[string]secretEntry),
+	}
+}
+
+// get returns the value of the key, loading it from the storage when the
+// cached value is missing or expired.
+func (c *secretCache) get(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, found := c.entries[key]; found && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+	b, err := c.loader.Load(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	c.entries[key] = secretEntry{
+		value:   string(b),
+		expires: time.Now().Add(c.ttl),
+	}
+	return string(b), nil
+}
+
+// getSecrets returns the values of the configured secrets. The secrets
+// which fail to load are omitted.
+func (fex *FunctionExecutor) getSecrets(ctx context.Context, requestID string) map[string]string {
+	secrets := make(map[string]string)
+	for _, key := range fex.Secrets {
+		value, err := fex.secrets.get(ctx, key)
+		if err != nil {
+			fex.logger.Warn(
+				"failed loading lambda secret",
+				zap.String("lambda_name", fex.Name),
+				zap.String("request_id", requestID),
+				zap.String("key", key),
+				zap.Error(err),
+			)
+			continue
+		}
+		secrets[key] = value
+	}
+	return secrets
+}
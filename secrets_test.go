@@ -0,0 +1,92 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zapcore"
+)
+
+// mockStorage is a storage backend holding the values in memory.
+type mockStorage struct {
+	values map[string]string
+	loads  int
+}
+
+func (s *mockStorage) Load(_ context.Context, key string) ([]byte, error) {
+	s.loads++
+	value, found := s.values[key]
+	if !found {
+		return nil, fs.ErrNotExist
+	}
+	return []byte(value), nil
+}
+
+func TestBuildRequestDataSecrets(t *testing.T) {
+	storage := &mockStorage{
+		values: map[string]string{
+			"lambda/db_password": "foo",
+			"lambda/api_token":   "bar",
+		},
+	}
+	fex := FunctionExecutor{
+		Secrets: []string{"lambda/db_password", "lambda/api_token", "lambda/missing"},
+	}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	fex.secrets = newSecretCache(storage, 100*time.Millisecond)
+
+	want := map[string]string{
+		"lambda/db_password": "foo",
+		"lambda/api_token":   "bar",
+	}
+	for i, tc := range []struct {
+		name      string
+		wait      time.Duration
+		wantLoads int
+	}{
+		{
+			name:      "test secrets are loaded from storage",
+			wantLoads: 3,
+		},
+		{
+			name:      "test secrets are served from cache",
+			wantLoads: 4,
+		},
+		{
+			name:      "test expired secrets are reloaded",
+			wait:      150 * time.Millisecond,
+			wantLoads: 7,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			time.Sleep(tc.wait)
+			req := newRequest(t, "GET", "/")
+			data := fex.buildRequestData(req, "test-request-id")
+			if diff := cmp.Diff(want, data["secrets"]); diff != "" {
+				t.Fatalf("unexpected secrets mismatch (-want +got):\n%s", diff)
+			}
+			// The missing secret is not cached and is loaded on every request.
+			if storage.loads != tc.wantLoads {
+				t.Fatalf("unexpected storage loads: got %d, want %d", storage.loads, tc.wantLoads)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
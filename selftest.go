@@ -0,0 +1,108 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// selftestTimeout is the max time the self-test takes.
+const selftestTimeout = 10 * time.Second
+
+// selftestImportPath is the module path of the built-in handler of the
+// self-test. The module is registered by pythonSelftest, not imported.
+const selftestImportPath = "__lambda_selftest"
+
+// selftestBody is the request body echoed by the built-in handler. It
+// spans multiple lines and holds non-ASCII characters.
+const selftestBody = "lambda selftest ✓\nline 2\t\"quoted\" <tag>"
+
+// pythonSelftest registers the built-in handler of the self-test. The
+// handler decodes the base64 encoded body and echoes it, along with the
+// X-Selftest header, with the 201 status code.
+const pythonSelftest = `import types as __lambda_types
+def __lambda_selftest_handler(event):
+    body = event["body"]
+    if event.get("is_base64_encoded"):
+        body = __lambda_base64.b64decode(body)
+    if isinstance(body, bytes):
+        body = body.decode("utf-8")
+    return {
+        "status_code": 201,
+        "headers": {"X-Selftest": event["headers"]["X-Selftest"]},
+        "body": body,
+    }
+__lambda_modules["__lambda_selftest"] = __lambda_types.ModuleType("__lambda_selftest")
+__lambda_modules["__lambda_selftest"].handler = __lambda_selftest_handler
+`
+
+// selftest invokes the built-in handler in a new worker and returns an
+// error when the response does not round-trip through the worker protocol,
+// e.g. the interpreter is incompatible with the bootstrap or mangles the
+// output.
+func (fex *FunctionExecutor) selftest() error {
+	w, err := fex.startWorker()
+	if err != nil {
+		return err
+	}
+	defer w.terminate()
+	w.timeout = selftestTimeout
+
+	if err := w.write("exec(" + pythonString(pythonBootstrap) + ")"); err != nil {
+		return fmt.Errorf("selftest failed: %v", err)
+	}
+	w.bootstrapped = true
+	if err := w.write("exec(" + pythonString(pythonSelftest) + ")"); err != nil {
+		return fmt.Errorf("selftest failed: %v", err)
+	}
+	w.imports[selftestImportPath] = true
+
+	token := uuid.New().String()
+	requestID := "selftest-" + token
+	data := map[string]interface{}{
+		"request_id":        requestID,
+		"headers":           map[string]interface{}{"X-Selftest": token},
+		"body":              []byte(selftestBody),
+		"is_base64_encoded": fex.BodyTransport != bodyTransportFD,
+	}
+	r, err := w.handle(&handlerSpec{
+		lambdaName:   fex.Name,
+		importedPath: selftestImportPath,
+		handlerName:  "handler",
+	}, requestID, newRequestEnvelope(data))
+	if err != nil {
+		return fmt.Errorf("selftest failed: %v", err)
+	}
+	if r.StatusCode != http.StatusCreated {
+		return fmt.Errorf("selftest failed: unexpected status code %d, want %d", r.StatusCode, http.StatusCreated)
+	}
+	if got := r.Headers.Get("X-Selftest"); got != token {
+		return fmt.Errorf("selftest failed: unexpected header %q, want %q", got, token)
+	}
+	if got := string(r.Body); got != selftestBody {
+		return fmt.Errorf("selftest failed: unexpected body %q, want %q", got, selftestBody)
+	}
+	fex.logger.Info(
+		"passed lambda selftest",
+		zap.String("lambda_name", fex.Name),
+		zap.String("python_executable", fex.PythonExecutable),
+	)
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap/zapcore"
+)
+
+// newBrokenPython returns the path to a script which mimics the python
+// executable by running the shell script.
+func newBrokenPython(t *testing.T, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("broken python executable requires a shell")
+	}
+	fp := filepath.Join(t.TempDir(), "python")
+	if err := os.WriteFile(fp, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("failed writing broken python executable: %v", err)
+	}
+	return fp
+}
+
+func TestFunctionExecutorSelftest(t *testing.T) {
+	for i, tc := range []struct {
+		name      string
+		python    func(t *testing.T) string
+		transport string
+		err       string
+	}{
+		{
+			name:   "test python passes selftest",
+			python: func(t *testing.T) string { return "python" },
+		},
+		{
+			name:      "test python passes selftest with fd body transport",
+			python:    func(t *testing.T) string { return "python" },
+			transport: "fd",
+		},
+		{
+			name: "test interpreter mangling output fails selftest",
+			python: func(t *testing.T) string {
+				return newBrokenPython(t, `PYTHONIOENCODING=ascii:replace exec python "$@"`)
+			},
+			err: "selftest failed: unexpected body",
+		},
+		{
+			name: "test interpreter exiting in interactive mode fails selftest",
+			python: func(t *testing.T) string {
+				return newBrokenPython(t, `[ "$1" = "-c" ] && exec python "$@"; exit 1`)
+			},
+			err: "selftest failed: lambda worker",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name hello_world
+				runtime python
+				python_executable ` + tc.python(t) + `
+				entrypoint assets/scripts/api/hello_world/app/index.py
+				function handler
+				selftest
+			`
+			if tc.transport != "" {
+				config += "body_transport " + tc.transport + "\n"
+			}
+			fex := &FunctionExecutor{}
+			fex.logger = initLogger(zapcore.DebugLevel)
+			if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config + "}")); err != nil {
+				t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+			}
+			err := fex.Provision(caddy.Context{Context: context.Background()})
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected Provision() error: %v", err)
+				}
+				fex.Cleanup()
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if err == nil {
+				fex.Cleanup()
+				t.Fatalf("unexpected Provision() success")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("unexpected Provision() error: got %v, want %q", err, tc.err)
+			}
+			if fex.workers != nil {
+				t.Fatalf("unexpected workers started after failed selftest")
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
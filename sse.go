@@ -0,0 +1,309 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// sseKeepAliveInterval is the interval of the comments sent to the client
+// when the handler yields no events, so that proxies keep the stream open.
+const sseKeepAliveInterval = 15 * time.Second
+
+// sseEvent is a server-sent event yielded by the handler.
+type sseEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  string `json:"data"`
+	Retry int    `json:"retry"`
+}
+
+// isEventStreamRequest returns true when the client accepts server-sent
+// events.
+func isEventStreamRequest(req *http.Request) bool {
+	for _, v := range req.Header.Values("Accept") {
+		if strings.Contains(v, "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
+
+// streamWriter writes the records of a stream yielded by a handler to the
+// client.
+type streamWriter interface {
+	// mode returns the stream mode passed to the handler invocation.
+	mode() string
+	// startMarker returns the marker of the start of the stream.
+	startMarker() string
+	// recordMarker returns the marker of the records yielded by the handler.
+	recordMarker() string
+	// isStarted returns true when the response headers are written.
+	isStarted() bool
+	// start writes the headers of the stream. The argument is the value of
+	// the start marker.
+	start(s string) error
+	writeRecord(s string) error
+	writeKeepAlive() error
+	// end writes the end of the completed stream.
+	end() error
+}
+
+// sseWriter writes server-sent events to the client.
+type sseWriter struct {
+	resp    http.ResponseWriter
+	started bool
+}
+
+func newSSEWriter(resp http.ResponseWriter) *sseWriter {
+	return &sseWriter{resp: resp}
+}
+
+func (sw *sseWriter) mode() string         { return "sse" }
+func (sw *sseWriter) startMarker() string  { return "CMD_SSE_START=" }
+func (sw *sseWriter) recordMarker() string { return "CMD_SSE_EVENT=" }
+func (sw *sseWriter) isStarted() bool      { return sw.started }
+
+// start writes the headers of the event stream.
+func (sw *sseWriter) start(string) error {
+	h := sw.resp.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Del("Content-Length")
+	sw.resp.WriteHeader(http.StatusOK)
+	sw.started = true
+	return sw.flush()
+}
+
+// writeRecord writes the JSON encoded event yielded by the handler.
+func (sw *sseWriter) writeRecord(s string) error {
+	ev := &sseEvent{}
+	if err := json.Unmarshal([]byte(s), ev); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedStreamRecord, err)
+	}
+	return sw.writeEvent(ev)
+}
+
+// writeEvent writes the event with the data split into data lines.
+func (sw *sseWriter) writeEvent(ev *sseEvent) error {
+	var b strings.Builder
+	if ev.ID != "" {
+		b.WriteString("id: " + sanitizeEventField(ev.ID) + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString("event: " + sanitizeEventField(ev.Event) + "\n")
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: " + strconv.Itoa(ev.Retry) + "\n")
+	}
+	for _, line := range strings.Split(ev.Data, "\n") {
+		b.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+	if _, err := sw.resp.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	return sw.flush()
+}
+
+// writeKeepAlive writes a comment, which is ignored by the client.
+func (sw *sseWriter) writeKeepAlive() error {
+	if _, err := sw.resp.Write([]byte(": keep-alive\n\n")); err != nil {
+		return err
+	}
+	return sw.flush()
+}
+
+// end does nothing, because the event stream ends with the response.
+func (sw *sseWriter) end() error {
+	return nil
+}
+
+func (sw *sseWriter) flush() error {
+	return http.NewResponseController(sw.resp).Flush()
+}
+
+// sanitizeEventField removes the line breaks, which would end the field.
+func sanitizeEventField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// execStream executes the function for the request of a stream, i.e. an
+// event stream or a multipart stream. If the handler returns an iterator,
+// the records are written to the client as they are yielded. Otherwise, the
+// response of the handler is returned.
+func (fex *FunctionExecutor) execStream(req *http.Request, requestID string, sw streamWriter) (*workerResponse, error) {
+	fex.logger.Debug(
+		"invoked lambda function stream",
+		zap.String("lambda_name", fex.Name),
+		zap.String("request_id", requestID),
+		zap.String("request_uri", req.RequestURI),
+		zap.String("stream_mode", sw.mode()),
+	)
+
+	if fex.concurrency != nil {
+		if err := fex.acquireConcurrency(req.Context()); err != nil {
+			return &workerResponse{StatusCode: http.StatusServiceUnavailable}, err
+		}
+		defer fex.concurrency.Release(1)
+	}
+
+	data := fex.buildRequestData(req, requestID)
+	if sw.mode() == "body" {
+		// Unlike the event streams, the ordinary requests carry a body.
+		if r, err := fex.addRequestBody(req, requestID, data); err != nil {
+			return r, err
+		}
+	}
+	r, err := fex.workers.stream(req.Context(), requestID, fex.getStickyKey(req), fex.newEnvelope(data), sw)
+	switch {
+	case err == nil:
+		if sw.isStarted() {
+			sw.end()
+		}
+	case errors.Is(err, errStreamCanceled):
+		fex.logger.Debug(
+			"lambda function stream closed by client",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+		)
+	default:
+		fex.logger.Warn(
+			"failed executing lambda function stream",
+			zap.String("lambda_name", fex.Name),
+			zap.String("request_id", requestID),
+			zap.Error(err),
+		)
+	}
+	return r, err
+}
+
+// stream dispatches the request of a stream to an available worker. The
+// worker is replaced when the stream is not completed.
+func (p *workerPool) stream(ctx context.Context, requestID, stickyKey string, env *requestEnvelope, sw streamWriter) (*workerResponse, error) {
+	p.mu.Lock()
+	w, err := p.acquire(stickyKey)
+	p.mu.Unlock()
+	if err != nil {
+		return &workerResponse{StatusCode: http.StatusServiceUnavailable}, err
+	}
+	r, err := w.stream(ctx, p.handler, requestID, env, sw)
+	if p.crashLoop != nil && isWorkerCrash(err) {
+		p.crashLoop.recordRestart(err)
+	}
+	p.finish(w, err)
+	return r, err
+}
+
+// stream invokes the handler and writes the records it yields to the
+// client. The handler must yield a record within the worker timeout. If the
+// handler returns a response instead, the response is returned. When the
+// client goes away, the handler keeps running, so the worker must be
+// replaced.
+func (w *worker) stream(ctx context.Context, handler *handlerSpec, requestID string, env *requestEnvelope, sw streamWriter) (*workerResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cold := !w.imports[handler.importedPath]
+	if r, err := w.send(handler, requestID, env, sw.mode()); r != nil {
+		return r, err
+	}
+	var lines []string
+	if cold {
+		importLines, err := w.waitImport()
+		if err != nil {
+			return w.parseOutput(handler, requestID, importLines, err)
+		}
+		lines = importLines
+	}
+
+	endMarker := "CMD_OUTPUT_END=" + requestID + ";"
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	deadline := time.Now().Add(w.timeout)
+
+	var handlerErr error
+	for {
+		select {
+		case <-ctx.Done():
+			return &workerResponse{StatusCode: http.StatusOK, WorkerID: w.ID}, errStreamCanceled
+		case <-keepAlive.C:
+			if !sw.isStarted() {
+				continue
+			}
+			if err := sw.writeKeepAlive(); err != nil {
+				return &workerResponse{StatusCode: http.StatusOK, WorkerID: w.ID}, fmt.Errorf("%w: %v", errStreamCanceled, err)
+			}
+		case <-time.After(time.Until(deadline)):
+			if sw.isStarted() {
+				return &workerResponse{StatusCode: http.StatusOK, WorkerID: w.ID}, errWorkerTruncated
+			}
+			return w.parseOutput(handler, requestID, lines, errWorkerTimeout)
+		case line, ok := <-w.stdoutLines:
+			if !ok {
+				if sw.isStarted() {
+					return &workerResponse{StatusCode: http.StatusOK, WorkerID: w.ID}, errWorkerExited
+				}
+				return w.parseOutput(handler, requestID, lines, errWorkerExited)
+			}
+			deadline = time.Now().Add(w.timeout)
+
+			if !sw.isStarted() {
+				if strings.HasPrefix(line, sw.startMarker()) {
+					if err := sw.start(strings.TrimPrefix(line, sw.startMarker())); err != nil {
+						return &workerResponse{StatusCode: http.StatusOK, WorkerID: w.ID}, fmt.Errorf("%w: %v", errStreamCanceled, err)
+					}
+					continue
+				}
+				lines = append(lines, line)
+				if strings.Contains(line, endMarker) {
+					return w.parseOutput(handler, requestID, lines, nil)
+				}
+				continue
+			}
+
+			switch {
+			case strings.HasPrefix(line, "CMD_LOG="):
+				w.logHandlerRecord(handler, requestID, line)
+			case strings.HasPrefix(line, sw.recordMarker()):
+				err := sw.writeRecord(strings.TrimPrefix(line, sw.recordMarker()))
+				if errors.Is(err, errMalformedStreamRecord) {
+					w.logger.Warn(
+						"encountered error",
+						zap.String("request_id", requestID),
+						zap.Error(fmt.Errorf("failed to parse stream record from input string: %s", line)),
+					)
+					continue
+				}
+				if err != nil {
+					return &workerResponse{StatusCode: http.StatusOK, WorkerID: w.ID}, fmt.Errorf("%w: %v", errStreamCanceled, err)
+				}
+			case strings.HasPrefix(line, "CMD_ERROR="):
+				handlerErr = parseHandlerError(line)
+			case strings.HasPrefix(line, endMarker):
+				return &workerResponse{StatusCode: http.StatusOK, WorkerID: w.ID}, handlerErr
+			}
+		}
+	}
+}
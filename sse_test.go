@@ -0,0 +1,166 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func getEventStream(t *testing.T, url string) *http.Response {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	return resp
+}
+
+func TestFunctionExecutorSSE(t *testing.T) {
+	_, srv := newTestServer(t, `
+	lambda {
+		name sse
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/sse/app/index.py
+		function handler
+		sse
+	}`)
+
+	resp := getEventStream(t, srv.URL)
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("unexpected content type: %q", got)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	want := "data: hello\n\n" +
+		"id: 2\nevent: update\ndata: {\"n\": 2}\n\n" +
+		"data: line1\ndata: line2\n\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("unexpected event stream mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFunctionExecutorSSEPlainResponse(t *testing.T) {
+	_, srv := newTestServer(t, `
+	lambda {
+		name sse
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/sse/app/index.py
+		function plain_handler
+		sse
+	}`)
+
+	resp := getEventStream(t, srv.URL)
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(b) != `{"message": "not a stream"}` {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode, b)
+	}
+}
+
+func TestFunctionExecutorSSEClientDisconnect(t *testing.T) {
+	fex, srv := newTestServer(t, `
+	lambda {
+		name sse
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/sse/app/index.py
+		function infinite_handler
+		sse
+	}`)
+	w := fex.workers.getWorkers()[0]
+
+	resp := getEventStream(t, srv.URL)
+	reader := bufio.NewReader(resp.Body)
+	var events int
+	for events < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		if strings.HasPrefix(line, "data: tick") {
+			events++
+		}
+	}
+	resp.Body.Close()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		if fex.workers.getWorkers()[0] != w {
+			return
+		}
+	}
+	t.Fatalf("worker streaming to the disconnected client was not replaced")
+}
+
+func TestFunctionExecutorSSEResponseRateLimit(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name sse
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/sse/app/index.py
+		function large_handler
+		sse
+		response_rate_limit 4KiB
+	}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fex.invoke(w, r)
+	}))
+	defer func() {
+		srv.Close()
+		fex.Cleanup()
+	}()
+
+	// Warm up the worker, so that the import does not count.
+	resp := getEventStream(t, srv.URL)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	start := time.Now()
+	resp = getEventStream(t, srv.URL)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	elapsed := time.Since(start)
+	if len(b) < 4000 {
+		t.Fatalf("unexpected event stream size: %d", len(b))
+	}
+	// The first chunk of 409 bytes is written at once, and the rest at
+	// 4096 bytes per second.
+	if want := time.Duration(len(b)-409) * time.Second / 4096; elapsed < want-50*time.Millisecond {
+		t.Fatalf("unexpected stream duration: got %s, want at least %s", elapsed, want)
+	}
+	if elapsed > 5*time.Second {
+		t.Fatalf("unexpected stream duration: %s", elapsed)
+	}
+}
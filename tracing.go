@@ -0,0 +1,66 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/greenpau/caddy-lambda"
+
+// startSpan starts a span covering the function execution. The span is
+// a child of the trace context found in the request, if any.
+func (fex *FunctionExecutor) startSpan(req *http.Request) trace.Span {
+	ctx := propagation.TraceContext{}.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	_, span := otel.Tracer(tracerName).Start(
+		ctx, "lambda "+fex.Name,
+		trace.WithAttributes(attribute.String("lambda.name", fex.Name)),
+	)
+	return span
+}
+
+// addTraceData adds trace and span ids to the request data so that
+// the function handler can continue the trace.
+func addTraceData(span trace.Span, data map[string]interface{}) {
+	sc := span.SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	data["trace_id"] = sc.TraceID().String()
+	data["span_id"] = sc.SpanID().String()
+}
+
+// endSpan records the outcome of the function execution and ends the span.
+func endSpan(span trace.Span, r *workerResponse, err error) {
+	span.SetAttributes(
+		attribute.Int("http.status_code", r.StatusCode),
+		attribute.Bool("lambda.timed_out", errors.Is(err, errWorkerTimeout)),
+	)
+	if !errors.Is(err, errWorkersUnavailable) {
+		span.SetAttributes(attribute.Int("lambda.worker_id", int(r.WorkerID)))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFunctionExecutorTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(prevProvider)
+
+	config := `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+	}`
+
+	fex := &FunctionExecutor{}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)); err != nil {
+		t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+	}
+	if err := fex.Provision(caddy.Context{Context: context.Background()}); err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer fex.Cleanup()
+
+	parentTraceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := newRequest(t, "GET", "/")
+	req.Header.Set("Traceparent", "00-"+parentTraceID+"-00f067aa0ba902b7-01")
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, req); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("unexpected span count: got %d, want 1", len(spans))
+	}
+	span := spans[0]
+	if got := span.SpanContext().TraceID().String(); got != parentTraceID {
+		t.Fatalf("unexpected trace id: got %s, want %s", got, parentTraceID)
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	for k, want := range map[attribute.Key]interface{}{
+		"lambda.name":      "hello_world",
+		"http.status_code": int64(200),
+		"lambda.worker_id": int64(0),
+		"lambda.timed_out": false,
+	} {
+		got, found := attrs[k]
+		if !found {
+			t.Fatalf("span attribute %s not found", k)
+		}
+		if got.AsInterface() != want {
+			t.Fatalf("unexpected span attribute %s: got %v, want %v", k, got.AsInterface(), want)
+		}
+	}
+
+	if !strings.Contains(string(resp.body), span.SpanContext().SpanID().String()) {
+		t.Fatalf("span id not passed to handler: %s", resp.body)
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RequestTransform holds the name and the arguments of a built-in
+// transform applied to the request data before it is passed to the
+// function handler.
+type RequestTransform struct {
+	Name string   `json:"name,omitempty"`
+	Args []string `json:"args,omitempty"`
+}
+
+// requestTransformFunc modifies the request data of the request.
+type requestTransformFunc func(req *http.Request, data map[string]interface{})
+
+// requestTransforms are the built-in request transforms, keyed by name.
+// Each constructor validates the arguments and returns the transform.
+var requestTransforms = map[string]func(args []string) (requestTransformFunc, error){
+	"host_to_tenant": newHostToTenantTransform,
+	"strip_prefix":   newStripPrefixTransform,
+	"set_field":      newSetFieldTransform,
+}
+
+// getRequestTransformNames returns the sorted names of the built-in
+// request transforms.
+func getRequestTransformNames() []string {
+	var names []string
+	for name := range requestTransforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newRequestTransform returns the built-in request transform.
+func newRequestTransform(t *RequestTransform) (requestTransformFunc, error) {
+	fn, found := requestTransforms[t.Name]
+	if !found {
+		return nil, fmt.Errorf("unsupported request transform %q, supported transforms: %s",
+			t.Name, strings.Join(getRequestTransformNames(), ", "))
+	}
+	transform, err := fn(t.Args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request transform %q: %w", t.Name, err)
+	}
+	return transform, nil
+}
+
+// newHostToTenantTransform returns the transform setting the tenant field
+// to the first label of the host, e.g. acme for acme.example.com. The
+// optional argument is the name of the field. The field is not set for
+// the hosts without a subdomain and for IP addresses.
+func newHostToTenantTransform(args []string) (requestTransformFunc, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("expected at most 1 argument, got %d", len(args))
+	}
+	key := "tenant"
+	if len(args) == 1 {
+		key = args[0]
+	}
+	return func(req *http.Request, data map[string]interface{}) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) != nil {
+			return
+		}
+		labels := strings.Split(host, ".")
+		if len(labels) < 3 || labels[0] == "" {
+			return
+		}
+		data[key] = strings.ToLower(labels[0])
+	}, nil
+}
+
+// newStripPrefixTransform returns the transform removing the prefix from
+// the path and the request_uri fields, e.g. /api for the functions mounted
+// under /api.
+func newStripPrefixTransform(args []string) (requestTransformFunc, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 argument, got %d", len(args))
+	}
+	prefix := strings.TrimSuffix(args[0], "/")
+	if prefix == "" {
+		return nil, fmt.Errorf("empty prefix")
+	}
+	return func(req *http.Request, data map[string]interface{}) {
+		for _, key := range []string{"path", "request_uri"} {
+			s, ok := data[key].(string)
+			if !ok || !strings.HasPrefix(s, prefix) {
+				continue
+			}
+			s = s[len(prefix):]
+			switch {
+			case s == "":
+				s = "/"
+			case s[0] == '/':
+			case s[0] == '?':
+				s = "/" + s
+			default:
+				// The prefix must end at a path segment boundary.
+				continue
+			}
+			data[key] = s
+		}
+	}, nil
+}
+
+// newSetFieldTransform returns the transform setting the field to the
+// value.
+func newSetFieldTransform(args []string) (requestTransformFunc, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("expected 2 arguments, got %d", len(args))
+	}
+	key, value := args[0], args[1]
+	if key == "request_id" {
+		return nil, fmt.Errorf("field %q is reserved", key)
+	}
+	return func(req *http.Request, data map[string]interface{}) {
+		data[key] = value
+	}, nil
+}
+
+// provisionRequestTransforms instantiates the configured request
+// transforms.
+func (fex *FunctionExecutor) provisionRequestTransforms() error {
+	fex.requestTransforms = nil
+	for _, t := range fex.RequestTransforms {
+		transform, err := newRequestTransform(t)
+		if err != nil {
+			return err
+		}
+		fex.requestTransforms = append(fex.requestTransforms, transform)
+	}
+	return nil
+}
+
+// applyRequestTransforms applies the configured request transforms to the
+// request data in the configured order.
+func (fex *FunctionExecutor) applyRequestTransforms(req *http.Request, data map[string]interface{}) {
+	for _, transform := range fex.requestTransforms {
+		transform(req, data)
+	}
+}
+
+// ResponseTransform holds the name and the arguments of a built-in
+// transform applied to the response of the function handler before it is
+// written.
+type ResponseTransform struct {
+	Name string   `json:"name,omitempty"`
+	Args []string `json:"args,omitempty"`
+}
+
+// responseTransformFunc modifies the response of the function. The header
+// holds the response headers written so far, i.e. the ones returned by
+// the handler and set by the plugin.
+type responseTransformFunc func(req *http.Request, r *workerResponse, header http.Header)
+
+// responseTransforms are the built-in response transforms, keyed by name.
+// Each constructor validates the arguments and returns the transform.
+var responseTransforms = map[string]func(args []string) (responseTransformFunc, error){
+	"cors":             newCORSTransform,
+	"server_timing":    newServerTimingTransform,
+	"rewrite_location": newRewriteLocationTransform,
+}
+
+// getResponseTransformNames returns the sorted names of the built-in
+// response transforms.
+func getResponseTransformNames() []string {
+	var names []string
+	for name := range responseTransforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newResponseTransform returns the built-in response transform.
+func newResponseTransform(t *ResponseTransform) (responseTransformFunc, error) {
+	fn, found := responseTransforms[t.Name]
+	if !found {
+		return nil, fmt.Errorf("unsupported response transform %q, supported transforms: %s",
+			t.Name, strings.Join(getResponseTransformNames(), ", "))
+	}
+	transform, err := fn(t.Args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response transform %q: %w", t.Name, err)
+	}
+	return transform, nil
+}
+
+// newCORSTransform returns the transform setting the
+// Access-Control-Allow-Origin header. Without arguments, all origins are
+// allowed. Otherwise, the origin of the request is allowed when it is one
+// of the arguments.
+func newCORSTransform(args []string) (responseTransformFunc, error) {
+	if len(args) == 0 {
+		return func(req *http.Request, r *workerResponse, header http.Header) {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}, nil
+	}
+	origins := make(map[string]bool)
+	for _, arg := range args {
+		origins[strings.TrimSuffix(arg, "/")] = true
+	}
+	return func(req *http.Request, r *workerResponse, header http.Header) {
+		// The response depends on the origin, so it is not cached for
+		// other origins.
+		header.Add("Vary", "Origin")
+		origin := req.Header.Get("Origin")
+		if !origins[origin] {
+			return
+		}
+		header.Set("Access-Control-Allow-Origin", origin)
+	}, nil
+}
+
+// newServerTimingTransform returns the transform adding the duration of
+// the function to the Server-Timing header. The optional argument is the
+// name of the metric, lambda by default.
+func newServerTimingTransform(args []string) (responseTransformFunc, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("expected at most 1 argument, got %d", len(args))
+	}
+	name := "lambda"
+	if len(args) == 1 {
+		name = args[0]
+	}
+	if name == "" || strings.ContainsAny(name, " ,;=\"") {
+		return nil, fmt.Errorf("invalid metric name %q", name)
+	}
+	return func(req *http.Request, r *workerResponse, header http.Header) {
+		ms := float64(r.Duration.Microseconds()) / 1000
+		header.Add("Server-Timing", name+";dur="+strconv.FormatFloat(ms, 'f', -1, 64))
+	}, nil
+}
+
+// newRewriteLocationTransform returns the transform replacing the prefix
+// of the Location header, e.g. the internal URL of the function, with the
+// public one.
+func newRewriteLocationTransform(args []string) (responseTransformFunc, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("expected 2 arguments, got %d", len(args))
+	}
+	from, to := args[0], args[1]
+	if from == "" {
+		return nil, fmt.Errorf("empty prefix")
+	}
+	return func(req *http.Request, r *workerResponse, header http.Header) {
+		location := header.Get("Location")
+		if !strings.HasPrefix(location, from) {
+			return
+		}
+		header.Set("Location", to+location[len(from):])
+	}, nil
+}
+
+// provisionResponseTransforms instantiates the configured response
+// transforms.
+func (fex *FunctionExecutor) provisionResponseTransforms() error {
+	fex.responseTransforms = nil
+	for _, t := range fex.ResponseTransforms {
+		transform, err := newResponseTransform(t)
+		if err != nil {
+			return err
+		}
+		fex.responseTransforms = append(fex.responseTransforms, transform)
+	}
+	return nil
+}
+
+// applyResponseTransforms applies the configured response transforms to
+// the response in the configured order.
+func (fex *FunctionExecutor) applyResponseTransforms(req *http.Request, r *workerResponse, header http.Header) {
+	for _, transform := range fex.responseTransforms {
+		transform(req, r, header)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRequestTransforms(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		transforms []*RequestTransform
+		host       string
+		uri        string
+		want       map[string]interface{}
+	}{
+		{
+			name:       "test host to tenant",
+			transforms: []*RequestTransform{{Name: "host_to_tenant"}},
+			host:       "Acme.example.com:8443",
+			uri:        "/foo",
+			want:       map[string]interface{}{"path": "/foo", "request_uri": "/foo", "tenant": "acme"},
+		},
+		{
+			name:       "test host to tenant with custom field",
+			transforms: []*RequestTransform{{Name: "host_to_tenant", Args: []string{"org"}}},
+			host:       "acme.example.com",
+			uri:        "/foo",
+			want:       map[string]interface{}{"path": "/foo", "request_uri": "/foo", "org": "acme"},
+		},
+		{
+			name:       "test host to tenant without subdomain",
+			transforms: []*RequestTransform{{Name: "host_to_tenant"}},
+			host:       "example.com",
+			uri:        "/foo",
+			want:       map[string]interface{}{"path": "/foo", "request_uri": "/foo"},
+		},
+		{
+			name:       "test host to tenant with ip address",
+			transforms: []*RequestTransform{{Name: "host_to_tenant"}},
+			host:       "127.0.0.1:8080",
+			uri:        "/foo",
+			want:       map[string]interface{}{"path": "/foo", "request_uri": "/foo"},
+		},
+		{
+			name:       "test strip prefix",
+			transforms: []*RequestTransform{{Name: "strip_prefix", Args: []string{"/api/"}}},
+			host:       "example.com",
+			uri:        "/api/foo?bar=baz",
+			want:       map[string]interface{}{"path": "/foo", "request_uri": "/foo?bar=baz"},
+		},
+		{
+			name:       "test strip prefix of entire path",
+			transforms: []*RequestTransform{{Name: "strip_prefix", Args: []string{"/api"}}},
+			host:       "example.com",
+			uri:        "/api?bar=baz",
+			want:       map[string]interface{}{"path": "/", "request_uri": "/?bar=baz"},
+		},
+		{
+			name:       "test strip prefix at segment boundary only",
+			transforms: []*RequestTransform{{Name: "strip_prefix", Args: []string{"/api"}}},
+			host:       "example.com",
+			uri:        "/apix/foo",
+			want:       map[string]interface{}{"path": "/apix/foo", "request_uri": "/apix/foo"},
+		},
+		{
+			name: "test transforms applied in order",
+			transforms: []*RequestTransform{
+				{Name: "set_field", Args: []string{"tenant", "default"}},
+				{Name: "host_to_tenant"},
+			},
+			host: "acme.example.com",
+			uri:  "/foo",
+			want: map[string]interface{}{"path": "/foo", "request_uri": "/foo", "tenant": "acme"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{
+				IncludeFields:     []string{"path", "request_uri"},
+				RequestTransforms: tc.transforms,
+			}
+			if err := fex.provisionRequestTransforms(); err != nil {
+				t.Fatalf("unexpected provisionRequestTransforms() error: %v", err)
+			}
+			req := newRequest(t, "GET", tc.uri)
+			req.Host = tc.host
+			req.RequestURI = tc.uri
+			data := fex.buildRequestData(req, "test-request-id")
+			delete(data, "request_id")
+			if diff := cmp.Diff(tc.want, data); diff != "" {
+				t.Fatalf("unexpected request data mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestResponseTransforms(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		transforms []*ResponseTransform
+		origin     string
+		location   string
+		want       http.Header
+	}{
+		{
+			name:       "test cors for all origins",
+			transforms: []*ResponseTransform{{Name: "cors"}},
+			origin:     "https://example.com",
+			want:       http.Header{"Access-Control-Allow-Origin": {"*"}},
+		},
+		{
+			name:       "test cors for allowed origin",
+			transforms: []*ResponseTransform{{Name: "cors", Args: []string{"https://example.com/", "https://example.org"}}},
+			origin:     "https://example.com",
+			want:       http.Header{"Access-Control-Allow-Origin": {"https://example.com"}, "Vary": {"Origin"}},
+		},
+		{
+			name:       "test cors for disallowed origin",
+			transforms: []*ResponseTransform{{Name: "cors", Args: []string{"https://example.org"}}},
+			origin:     "https://example.com",
+			want:       http.Header{"Vary": {"Origin"}},
+		},
+		{
+			name:       "test server timing",
+			transforms: []*ResponseTransform{{Name: "server_timing"}},
+			want:       http.Header{"Server-Timing": {"lambda;dur=12.5"}},
+		},
+		{
+			name:       "test server timing with custom metric",
+			transforms: []*ResponseTransform{{Name: "server_timing", Args: []string{"fn"}}},
+			want:       http.Header{"Server-Timing": {"fn;dur=12.5"}},
+		},
+		{
+			name:       "test rewrite location",
+			transforms: []*ResponseTransform{{Name: "rewrite_location", Args: []string{"http://localhost:8080/", "https://example.com/api/"}}},
+			location:   "http://localhost:8080/foo?bar=baz",
+			want:       http.Header{"Location": {"https://example.com/api/foo?bar=baz"}},
+		},
+		{
+			name:       "test rewrite location without prefix",
+			transforms: []*ResponseTransform{{Name: "rewrite_location", Args: []string{"http://localhost:8080/", "https://example.com/api/"}}},
+			location:   "/foo",
+			want:       http.Header{"Location": {"/foo"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := FunctionExecutor{ResponseTransforms: tc.transforms}
+			if err := fex.provisionResponseTransforms(); err != nil {
+				t.Fatalf("unexpected provisionResponseTransforms() error: %v", err)
+			}
+			req := newRequest(t, "GET", "/")
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			header := make(http.Header)
+			if tc.location != "" {
+				header.Set("Location", tc.location)
+			}
+			fex.applyResponseTransforms(req, &workerResponse{StatusCode: http.StatusOK, Duration: 12500 * time.Microsecond}, header)
+			if diff := cmp.Diff(tc.want, header); diff != "" {
+				t.Fatalf("unexpected header mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestFunctionExecutorResponseTransform(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		response_transform cors
+		response_transform server_timing
+	}`)
+	defer fex.Cleanup()
+
+	resp := newResponseWriter(fex.logger)
+	if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+		t.Fatalf("unexpected invoke() error: %v", err)
+	}
+	if resp.statusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", resp.statusCode, http.StatusOK)
+	}
+	if got := resp.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("unexpected Access-Control-Allow-Origin header: %q", got)
+	}
+	if got := resp.Header().Get("Server-Timing"); !strings.HasPrefix(got, "lambda;dur=") {
+		t.Fatalf("unexpected Server-Timing header: %q", got)
+	}
+}
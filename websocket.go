@@ -0,0 +1,122 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// isWebSocketRequest returns true when the request asks for a websocket
+// protocol upgrade.
+func isWebSocketRequest(req *http.Request) bool {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkWebSocketOrigin refuses the upgrade when the Origin header of the
+// request names a host other than the requested one, unless
+// websocket_cross_origin is set. The requests without the header, i.e. not
+// sent by a browser, are allowed.
+func (fex *FunctionExecutor) checkWebSocketOrigin(config *websocket.Config, req *http.Request) error {
+	if fex.WebSocketCrossOrigin || req.Header.Get("Origin") == "" {
+		return nil
+	}
+	origin, err := websocket.Origin(config, req)
+	if err != nil {
+		return err
+	}
+	if origin == nil || !strings.EqualFold(origin.Host, req.Host) {
+		return errWebSocketOrigin
+	}
+	config.Origin = origin
+	return nil
+}
+
+// serveWebSocket upgrades the connection and invokes the function handler
+// for each text message received from the client. The handler receives the
+// message in the websocket_message field of the request data. A non-empty
+// body returned by the handler is sent back to the client as a message.
+func (fex *FunctionExecutor) serveWebSocket(resp http.ResponseWriter, req *http.Request, requestID string) error {
+	data := fex.buildRequestData(req, requestID)
+	stickyKey := fex.getStickyKey(req)
+	fairnessKey := fex.getFairnessKey(req)
+
+	srv := websocket.Server{
+		Handshake: fex.checkWebSocketOrigin,
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			for {
+				var msg string
+				if err := websocket.Message.Receive(conn, &msg); err != nil {
+					if !errors.Is(err, io.EOF) {
+						fex.logger.Warn(
+							"failed receiving websocket message",
+							zap.String("lambda_name", fex.Name),
+							zap.String("request_id", requestID),
+							zap.Error(err),
+						)
+					}
+					return
+				}
+
+				msgData := make(map[string]interface{}, len(data)+1)
+				for k, v := range data {
+					msgData[k] = v
+				}
+				msgData["websocket_message"] = msg
+
+				r, err := fex.execWorker(req.Method, stickyKey, fairnessKey, fex.newEnvelope(msgData))
+				if err != nil {
+					fex.logger.Warn(
+						"failed executing lambda function for websocket message",
+						zap.String("lambda_name", fex.Name),
+						zap.String("request_id", requestID),
+						zap.Error(err),
+					)
+					return
+				}
+				if len(r.Body) == 0 {
+					continue
+				}
+				if err := websocket.Message.Send(conn, string(r.Body)); err != nil {
+					fex.logger.Warn(
+						"failed sending websocket message",
+						zap.String("lambda_name", fex.Name),
+						zap.String("request_id", requestID),
+						zap.Error(err),
+					)
+					return
+				}
+			}
+		},
+	}
+	srv.ServeHTTP(resp, req)
+	return nil
+}
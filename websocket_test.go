@@ -0,0 +1,175 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/websocket"
+)
+
+func TestFunctionExecutorWebSocket(t *testing.T) {
+	config := `
+	lambda {
+		name websocket
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/websocket/app/index.py
+		function handler
+		websocket
+	}`
+
+	fex := &FunctionExecutor{}
+	fex.logger = initLogger(zapcore.DebugLevel)
+	if err := fex.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)); err != nil {
+		t.Fatalf("unexpected UnmarshalCaddyfile() error: %v", err)
+	}
+	if err := fex.Provision(caddy.Context{Context: context.Background()}); err != nil {
+		t.Fatalf("unexpected Provision() error: %v", err)
+	}
+	defer fex.Cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fex.invoke(w, r)
+	}))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	conn, err := websocket.Dial(wsURL, "", srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected websocket.Dial() error: %v", err)
+	}
+	defer conn.Close()
+
+	for i, msg := range []string{"foo", "bar"} {
+		if err := websocket.Message.Send(conn, msg); err != nil {
+			t.Fatalf("unexpected websocket send error: %v", err)
+		}
+		var got string
+		if err := websocket.Message.Receive(conn, &got); err != nil {
+			t.Fatalf("unexpected websocket receive error: %v", err)
+		}
+		if want := "echo: " + msg; got != want {
+			t.Fatalf("unexpected websocket message: got %q, want %q", got, want)
+		}
+		t.Logf("PASS: Test %d", i)
+	}
+}
+
+func TestFunctionExecutorWebSocketOrigin(t *testing.T) {
+	for i, tc := range []struct {
+		name        string
+		crossOrigin bool
+		origin      string
+		shouldErr   bool
+	}{
+		{
+			name: "test same origin",
+		},
+		{
+			name:      "test foreign origin",
+			origin:    "http://evil.example.com",
+			shouldErr: true,
+		},
+		{
+			name:        "test foreign origin with websocket_cross_origin",
+			crossOrigin: true,
+			origin:      "http://evil.example.com",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := `
+			lambda {
+				name websocket
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/websocket/app/index.py
+				function handler
+				websocket
+			}`
+			if tc.crossOrigin {
+				config = strings.Replace(config, "websocket\n", "websocket\nwebsocket_cross_origin\n", 1)
+			}
+
+			fex, srv := newTestServer(t, config)
+			if fex.WebSocketCrossOrigin != tc.crossOrigin {
+				t.Fatalf("unexpected websocket_cross_origin: got %t, want %t", fex.WebSocketCrossOrigin, tc.crossOrigin)
+			}
+
+			origin := tc.origin
+			if origin == "" {
+				origin = srv.URL
+			}
+			wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+			conn, err := websocket.Dial(wsURL, "", origin)
+			if tc.shouldErr {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("expected websocket.Dial() error for origin %q", origin)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected websocket.Dial() error: %v", err)
+			}
+			defer conn.Close()
+
+			if err := websocket.Message.Send(conn, "foo"); err != nil {
+				t.Fatalf("unexpected websocket send error: %v", err)
+			}
+			var got string
+			if err := websocket.Message.Receive(conn, &got); err != nil {
+				t.Fatalf("unexpected websocket receive error: %v", err)
+			}
+			if got != "echo: foo" {
+				t.Fatalf("unexpected websocket message: got %q, want %q", got, "echo: foo")
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestIsWebSocketRequest(t *testing.T) {
+	for i, tc := range []struct {
+		headers map[string]string
+		want    bool
+	}{
+		{
+			headers: map[string]string{"Upgrade": "websocket", "Connection": "keep-alive, Upgrade"},
+			want:    true,
+		},
+		{
+			headers: map[string]string{"Upgrade": "websocket"},
+		},
+		{},
+	} {
+		req := newRequest(t, "GET", "/")
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		if got := isWebSocketRequest(req); got != tc.want {
+			t.Fatalf("unexpected isWebSocketRequest() result: got %t, want %t", got, tc.want)
+		}
+		t.Logf("PASS: Test %d", i)
+	}
+}
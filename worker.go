@@ -16,46 +16,544 @@ package lambda
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+// pythonInteractiveSetup is executed before the interactive mode of the
+// worker is entered. It disables the prompts, which would otherwise be
+// written to stderr for every line sent to the worker, and the echo of the
+// values of expressions to stdout, so that the REPL leaves no artifacts.
+const pythonInteractiveSetup = `import sys; sys.ps1 = sys.ps2 = ""; sys.displayhook = lambda value: None`
+
+// pythonBootstrap is executed once per worker. It defines the helpers which
+// import the entrypoints, each into its own module namespace, invoke
+// handlers, and print the response markers read by the worker. A failed
+// import is recorded and reported when a handler of the entrypoint is
+// invoked, so it does not affect the other entrypoints.
+const pythonBootstrap = `import base64 as __lambda_base64
+import importlib as __lambda_importlib
+import inspect as __lambda_inspect
+import json as __lambda_json
+import os as __lambda_os
+import sys as __lambda_sys
+import time as __lambda_time
+import types as __lambda_types
+
+__lambda_modules = {}
+__lambda_import_errors = {}
+__lambda_params_cache = {}
+
+try:
+    import resource as __lambda_resource
+except ImportError:
+    __lambda_resource = None
+
+def __lambda_now_ms():
+    return int(__lambda_time.time() * 1000)
+
+# The names starting with double underscore are mangled in a class body,
+# so the clock is passed to the constructor.
+class __LambdaContext(object):
+    def __init__(self, request_id, function_name, timeout_ms, clock):
+        self.aws_request_id = request_id
+        self.request_id = request_id
+        self.function_name = function_name
+        self.deadline_ms = clock() + timeout_ms
+        self._clock = clock
+
+    def get_remaining_time_in_millis(self):
+        return max(0, self.deadline_ms - self._clock())
+
+    def time_remaining(self):
+        # The remaining time in seconds.
+        return self.get_remaining_time_in_millis() / 1000.0
+
+# The handlers abort with an HTTP error by raising LambdaHTTPError, which
+# is importable from the caddy_lambda module.
+class __LambdaHTTPError(Exception):
+    def __init__(self, status_code, message="", headers=None):
+        Exception.__init__(self, status_code, message)
+        self.status_code = status_code
+        self.message = message
+        self.headers = headers
+
+__LambdaHTTPError.__name__ = __LambdaHTTPError.__qualname__ = "LambdaHTTPError"
+__LambdaHTTPError.__module__ = "caddy_lambda"
+__lambda_sdk = __lambda_types.ModuleType("caddy_lambda")
+__lambda_sdk.LambdaHTTPError = __LambdaHTTPError
+__lambda_sys.modules["caddy_lambda"] = __lambda_sdk
+
+def __lambda_rusage():
+    if __lambda_resource is None:
+        return None
+    return __lambda_resource.getrusage(__lambda_resource.RUSAGE_SELF)
+
+def __lambda_stats(start):
+    end = __lambda_rusage()
+    if start is None or end is None:
+        return None
+    return {
+        "user_cpu_ms": (end.ru_utime - start.ru_utime) * 1000,
+        "system_cpu_ms": (end.ru_stime - start.ru_stime) * 1000,
+        "max_rss_kb": end.ru_maxrss,
+    }
+
+def __lambda_error(request_id, msg):
+    print("CMD_OUTPUT_START=" + request_id + ";")
+    print("CMD_ERROR=" + __lambda_json.dumps(msg))
+    print("CMD_OUTPUT_END=" + request_id + ";")
+
+def __lambda_http_error(request_id, e):
+    try:
+        status_code = int(e.status_code)
+        message = e.message
+        if not isinstance(message, str):
+            message = str(message)
+        headers = e.headers
+        if headers is not None:
+            if not isinstance(headers, dict):
+                raise TypeError("headers must be a dict, got %s" % type(headers).__name__)
+            headers = __lambda_json.dumps(headers)
+    except Exception as err:
+        __lambda_error(request_id, "malformed LambdaHTTPError: %s: %s" % (type(err).__name__, err))
+        return
+    print("CMD_OUTPUT_START=" + request_id + ";")
+    if headers is not None:
+        print("CMD_OUTPUT_HEADERS=" + headers)
+    print("CMD_HTTP_ERROR=" + __lambda_json.dumps({"status_code": status_code, "message": message}))
+    print("CMD_OUTPUT_END=" + request_id + ";")
+
+def __lambda_import(path):
+    try:
+        __lambda_modules[path] = __lambda_importlib.import_module(path)
+    except BaseException as e:
+        __lambda_import_errors[path] = "%s: %s" % (type(e).__name__, e)
+    print("CMD_IMPORTED=" + __lambda_json.dumps(path))
+
+def __lambda_handler(path, name, request_id):
+    if path in __lambda_import_errors:
+        msg = __lambda_import_errors[path]
+        print("CMD_OUTPUT_START=" + request_id + ";")
+        print("CMD_IMPORT_ERROR=" + __lambda_json.dumps(msg))
+        print("CMD_ERROR=" + __lambda_json.dumps("failed importing %s: %s" % (path, msg)))
+        print("CMD_OUTPUT_END=" + request_id + ";")
+        return None
+    fn = getattr(__lambda_modules[path], name, None)
+    if not callable(fn):
+        __lambda_error(request_id, "handler %s not found in %s" % (name, path))
+        return None
+    return fn
+
+def __lambda_read_body(size):
+    chunks = []
+    while size > 0:
+        chunk = __lambda_os.read(3, size)
+        if not chunk:
+            break
+        chunks.append(chunk)
+        size -= len(chunk)
+    return b"".join(chunks)
+
+def __lambda_decode_body(req):
+    for key in ("is_base64_encoded", "isBase64Encoded"):
+        if req.get(key):
+            req["body"] = __lambda_base64.b64decode(req["body"])
+            req[key] = False
+
+def __lambda_params(fn):
+    # Returns the names of the keyword parameters of the handler, or None
+    # when the handler takes any keyword arguments.
+    if fn in __lambda_params_cache:
+        return __lambda_params_cache[fn]
+    try:
+        params = __lambda_inspect.signature(fn).parameters.values()
+    except (TypeError, ValueError):
+        params = None
+    if params is not None:
+        if any(p.kind == p.VAR_KEYWORD for p in params):
+            params = None
+        else:
+            params = set(p.name for p in params if p.kind in (p.POSITIONAL_OR_KEYWORD, p.KEYWORD_ONLY))
+    __lambda_params_cache[fn] = params
+    return params
+
+def __lambda_call_unpacked(fn, req, context):
+    kwargs = dict(req)
+    if context is not None:
+        kwargs["context"] = context
+    params = __lambda_params(fn)
+    if params is not None:
+        kwargs = dict((k, v) for k, v in kwargs.items() if k in params)
+    return fn(**kwargs)
+
+def __lambda_map_result(resp, result_keys):
+    custom = set(result_keys.values())
+    m = dict((k, v) for k, v in resp.items() if k not in custom)
+    for key, custom_key in result_keys.items():
+        if custom_key in resp:
+            m[key] = resp[custom_key]
+    return m
+
+def __lambda_sse(request_id, events):
+    print("CMD_OUTPUT_START=" + request_id + ";")
+    print("CMD_SSE_START=")
+    try:
+        for event in events:
+            if not isinstance(event, dict):
+                event = {"data": event}
+            data = event.get("data", "")
+            if not isinstance(data, str):
+                data = __lambda_json.dumps(data)
+            event = dict(event, data=data)
+            print("CMD_SSE_EVENT=" + __lambda_json.dumps(event, default=str))
+    except Exception as e:
+        print("CMD_ERROR=" + __lambda_json.dumps("%s: %s" % (type(e).__name__, e)))
+    print("CMD_OUTPUT_END=" + request_id + ";")
+
+def __lambda_multipart(request_id, parts):
+    print("CMD_OUTPUT_START=" + request_id + ";")
+    print("CMD_MULTIPART_START=")
+    try:
+        for part in parts:
+            if not isinstance(part, dict):
+                part = {"body": part}
+            body = part.get("body", b"")
+            if isinstance(body, str):
+                body = body.encode("utf-8")
+            elif not isinstance(body, bytes):
+                body = __lambda_json.dumps(body).encode("utf-8")
+            record = {
+                "content_type": part.get("content_type", ""),
+                "headers": part.get("headers") or {},
+                "body": __lambda_base64.b64encode(body).decode("ascii"),
+            }
+            print("CMD_MULTIPART_PART=" + __lambda_json.dumps(record, default=str))
+    except Exception as e:
+        print("CMD_ERROR=" + __lambda_json.dumps("%s: %s" % (type(e).__name__, e)))
+    print("CMD_OUTPUT_END=" + request_id + ";")
+
+def __lambda_body_chunk(chunk):
+    if isinstance(chunk, str):
+        return chunk.encode("utf-8")
+    if isinstance(chunk, bytes):
+        return chunk
+    return __lambda_json.dumps(chunk).encode("utf-8")
+
+def __lambda_body_stream(request_id, resp):
+    # Writes the body in chunks as it is produced. The body is either the
+    # value or an iterator of the chunks. It returns False when the response
+    # is written as a whole, e.g. with the body file.
+    if not isinstance(resp, dict):
+        return False
+    for key in ("body_file", "redirect_signed", "etag", "connection"):
+        if resp.get(key) is not None:
+            return False
+    try:
+        status_code = int(resp["status_code"])
+        headers = resp.get("headers")
+        if headers is not None and not isinstance(headers, dict):
+            raise TypeError("headers must be a dict, got %s" % type(headers).__name__)
+        head = __lambda_json.dumps({"status_code": status_code, "headers": headers or {}}, default=str)
+    except Exception as e:
+        __lambda_error(request_id, "malformed handler response: %s: %s" % (type(e).__name__, e))
+        return True
+    body = resp.get("body", "")
+    print("CMD_OUTPUT_START=" + request_id + ";")
+    print("CMD_BODY_START=" + head)
+    try:
+        chunks = body if hasattr(body, "__next__") else [body]
+        for chunk in chunks:
+            chunk = __lambda_body_chunk(chunk)
+            # The encoded chunks fit in the max line length read from the
+            # worker, i.e. 64KB.
+            for i in range(0, len(chunk), 32768):
+                print("CMD_BODY_CHUNK=" + __lambda_base64.b64encode(chunk[i:i + 32768]).decode("ascii"))
+    except Exception as e:
+        print("CMD_ERROR=" + __lambda_json.dumps("%s: %s" % (type(e).__name__, e)))
+    print("CMD_OUTPUT_END=" + request_id + ";")
+    return True
+
+def __lambda_shutdown(path, name, request_id):
+    fn = __lambda_handler(path, name, request_id)
+    if fn is None:
+        return
+    try:
+        fn()
+    except Exception as e:
+        __lambda_error(request_id, "%s: %s" % (type(e).__name__, e))
+        return
+    print("CMD_OUTPUT_START=" + request_id + ";")
+    print("CMD_OUTPUT_END=" + request_id + ";")
+
+def __lambda_invoke(path, name, request_id, raw, context_raw, body_size=None, stream=None, unpacked=False, decode_body=False, hook=False, result_keys=None, auto_json=False):
+    # The raw body is read first, so that it is consumed even when the
+    # handler is not invoked.
+    body = None
+    if body_size is not None:
+        body = __lambda_read_body(body_size)
+    fn = __lambda_handler(path, name, request_id)
+    if fn is None:
+        return
+    req = __lambda_json.loads(raw)
+    if body is not None:
+        req["body"] = body
+    if decode_body:
+        __lambda_decode_body(req)
+    rusage = __lambda_rusage()
+    try:
+        context = None
+        if context_raw is not None:
+            context = __LambdaContext(request_id, clock=__lambda_now_ms, **__lambda_json.loads(context_raw))
+        if unpacked:
+            resp = __lambda_call_unpacked(fn, req, context)
+        elif context is None:
+            resp = fn(req)
+        else:
+            resp = fn(req, context)
+    except __LambdaHTTPError as e:
+        __lambda_http_error(request_id, e)
+        return
+    except Exception as e:
+        __lambda_error(request_id, "%s: %s" % (type(e).__name__, e))
+        return
+    if result_keys and isinstance(resp, dict):
+        resp = __lambda_map_result(resp, result_keys)
+    if auto_json and isinstance(resp, dict) and "status_code" not in resp:
+        # The dict without the status code is the data, not the envelope.
+        resp = {"status_code": 200, "headers": {"Content-Type": "application/json"}, "body": __lambda_json.dumps(resp)}
+    if hook and resp is None:
+        resp = {"status_code": 200, "body": ""}
+    if stream in ("sse", "multipart") and not isinstance(resp, (dict, str, bytes)) and hasattr(resp, "__iter__"):
+        if stream == "multipart":
+            __lambda_multipart(request_id, resp)
+        else:
+            __lambda_sse(request_id, resp)
+        return
+    if stream == "body" and __lambda_body_stream(request_id, resp):
+        return
+    try:
+        if not isinstance(resp, dict):
+            raise TypeError("handler returned %s, expected dict" % type(resp).__name__)
+        redirect_signed = resp.get("redirect_signed")
+        if redirect_signed is not None:
+            # The plugin signs the url and sets the Location header.
+            if not isinstance(redirect_signed, dict) or not isinstance(redirect_signed.get("url"), str):
+                raise TypeError("redirect_signed must be a dict with the url str")
+            redirect_signed = __lambda_json.dumps({"url": redirect_signed["url"], "ttl": int(redirect_signed.get("ttl", 0))})
+            resp = dict({"status_code": 302, "body": ""}, **resp)
+        status_code = int(resp["status_code"])
+        body_file = resp.get("body_file")
+        if body_file is not None:
+            if not isinstance(body_file, str):
+                raise TypeError("body_file must be a str, got %s" % type(body_file).__name__)
+            body = ""
+        else:
+            body = resp["body"]
+            if not isinstance(body, (str, bytes)):
+                __lambda_json.dumps(body)
+        headers = resp.get("headers")
+        if headers is not None and not isinstance(headers, dict):
+            raise TypeError("headers must be a dict, got %s" % type(headers).__name__)
+        etag = resp.get("etag")
+        if etag is not None:
+            etag = str(etag)
+            if not etag.startswith('"') and not etag.startswith('W/"'):
+                etag = '"%s"' % etag
+            headers = dict(headers or {})
+            headers["ETag"] = etag
+        if headers is not None:
+            headers = __lambda_json.dumps(headers)
+        connection = resp.get("connection")
+        if connection is not None and connection not in ("close", "keep-alive"):
+            raise ValueError("connection must be close or keep-alive, got %r" % (connection,))
+    except Exception as e:
+        __lambda_error(request_id, "malformed handler response: %s: %s" % (type(e).__name__, e))
+        return
+    stats = __lambda_stats(rusage)
+    print("CMD_OUTPUT_START=" + request_id + ";")
+    if stats is not None:
+        print("CMD_STATS=" + __lambda_json.dumps(stats))
+    print("CMD_STATUS_CODE=%s;" % status_code)
+    if headers is not None:
+        print("CMD_OUTPUT_HEADERS=" + headers)
+    if body_file is not None:
+        print("CMD_OUTPUT_BODY_FILE=" + __lambda_json.dumps(body_file))
+    if redirect_signed is not None:
+        print("CMD_OUTPUT_REDIRECT_SIGNED=" + redirect_signed)
+    if connection is not None:
+        print("CMD_OUTPUT_CONNECTION=" + __lambda_json.dumps(connection))
+    print("CMD_OUTPUT_BODY=%s" % body)
+    print("CMD_OUTPUT_END=" + request_id + ";")
+`
+
+// handlerSignature values control the arguments passed to a handler.
+const (
+	// handlerSignatureSingle passes the event only, i.e. handler(event).
+	handlerSignatureSingle = "single"
+	// handlerSignatureEventContext passes the event and the context object,
+	// i.e. handler(event, context), as in AWS Lambda.
+	handlerSignatureEventContext = "event_context"
+)
+
+// callStyle values control how the request data is passed to a handler.
+const (
+	// callStyleDict passes the request data as a dict, i.e. handler(event).
+	callStyleDict = "dict"
+	// callStyleUnpacked passes the request fields as keyword arguments,
+	// e.g. handler(method, path, headers, body).
+	callStyleUnpacked = "unpacked"
+)
+
+// handlerSpec identifies the handler invoked by the workers of a pool.
+type handlerSpec struct {
+	lambdaName   string
+	importedPath string
+	handlerName  string
+	signature    string
+	// unpacked is true when the request fields are passed to the handler
+	// as keyword arguments, i.e. call_style is unpacked.
+	unpacked bool
+	// escapeHTML is true when the <, >, and & characters of the request
+	// data are escaped, i.e. escape_html is set.
+	escapeHTML bool
+	// decodeBody is true when the base64 encoded request body is decoded
+	// before the handler is invoked, i.e. body_type is set.
+	decodeBody bool
+	// hook is true when the handler is not required to return a response,
+	// e.g. the after function.
+	hook bool
+	// partialOnTimeout is true when the output of the handler which timed
+	// out is served as a partial response.
+	partialOnTimeout bool
+	// autoJSON is true when the dict returned by the handler without the
+	// status code is the JSON body of the response, i.e. auto_json is set.
+	autoJSON bool
+	// requireOutput is true when the invocation without the status code in
+	// its output fails, i.e. require_output is set.
+	requireOutput bool
+	// resultKeys maps the keys of the handler response, e.g. status_code,
+	// to the custom keys returned by the handler.
+	resultKeys map[string]string
+}
+
+// workerResponse holds the outcome of a function invocation.
+type workerResponse struct {
+	StatusCode int
+	Body       []byte
+	WorkerID   uint
+	Stats      *workerStats
+	Headers    http.Header
+	// BodyFile is the path to the file holding the response body, when
+	// the handler returns body_file instead of body.
+	BodyFile string
+	// RedirectSigned is the redirect returned by the handler via
+	// redirect_signed, whose url the plugin signs.
+	RedirectSigned *signedRedirect
+	// Connection is the connection hint returned by the handler, i.e.
+	// close or keep-alive, if any.
+	Connection string
+	// Location is the signed URL of RedirectSigned, which the plugin sets
+	// in the Location header.
+	Location string
+	// Cold is true when the invocation imported the entrypoint, i.e. it is
+	// the first invocation of the handler by the worker.
+	Cold bool
+	// Partial is true when the response holds the output of the handler
+	// which timed out before completing it, i.e. partial_on_timeout is set.
+	Partial bool
+	// RetryAfter is the time the client should wait before retrying the
+	// request rejected by the plugin, e.g. when the circuit is open.
+	RetryAfter time.Duration
+	// Duration is the time the function took to serve the request.
+	Duration time.Duration
+}
+
+// workerStats holds the resource usage of a function invocation.
+type workerStats struct {
+	UserCPUMillis   float64 `json:"user_cpu_ms"`
+	SystemCPUMillis float64 `json:"system_cpu_ms"`
+	MaxRSSKilobytes int64   `json:"max_rss_kb"`
+}
+
 type worker struct {
-	mu             sync.RWMutex
-	ID             uint
-	InUse          bool
-	Terminated     bool
-	Cmd            *exec.Cmd
-	Pid            int
-	stdin          io.WriteCloser
-	stdout         io.ReadCloser
-	stderr         io.ReadCloser
-	timeout        time.Duration
-	importComplete bool
-	logger         *zap.Logger
-}
-
-func newWorker(id uint, binPath string, args []string, timeout time.Duration, logger *zap.Logger) (*worker, error) {
+	mu         sync.RWMutex
+	ID         uint
+	InUse      bool
+	Terminated bool
+	Cmd        *exec.Cmd
+	Pid        int
+	// exited is closed when the process exited and was reaped. The exit
+	// error is stored in exitErr.
+	exited      chan struct{}
+	exitErr     error
+	stdin       *os.File
+	stdinWriter *bufio.Writer
+	stdout      io.ReadCloser
+	stdoutLines chan string
+	stderr      io.ReadCloser
+	// bodyPipe is the write end of the pipe passing raw request bodies to
+	// the worker on file descriptor 3, when body_transport is fd.
+	bodyPipe *os.File
+	timeout  time.Duration
+	// maxDuration is the max time the handler runs, even if it keeps
+	// printing within the timeout. If zero, the time is not limited.
+	maxDuration time.Duration
+	// importTimeout is the max time the import of an entrypoint takes. If
+	// zero, the worker timeout applies.
+	importTimeout time.Duration
+	// writeTimeout is the max time writing the invocation to the worker
+	// takes. If zero, the writes are not limited.
+	writeTimeout time.Duration
+	// shutdownHandler is the function invoked before the worker is
+	// terminated, if the worker imported its entrypoint. The invocation is
+	// limited by shutdownTimeout.
+	shutdownHandler *handlerSpec
+	shutdownTimeout time.Duration
+	// tmpDir is the temporary directory of the worker, which is removed
+	// when the worker is terminated, in the per-request isolation mode.
+	tmpDir string
+	// startedAt is the time the worker started. The worker is replaced
+	// once it is older than maxAge, if not zero.
+	startedAt    time.Time
+	maxAge       time.Duration
+	bootstrapped bool
+	imports      map[string]bool
+	logger       *zap.Logger
+}
+
+func newWorker(id uint, binPath string, args, env []string, timeout time.Duration, bodyPipe bool, logger *zap.Logger) (*worker, error) {
 	w := &worker{
-		ID:     id,
-		logger: logger,
+		ID:        id,
+		imports:   make(map[string]bool),
+		logger:    logger,
+		startedAt: time.Now(),
 	}
 
 	cmd := exec.Command(binPath, args...)
+	cmd.Env = env
 
-	cmdStdin, cmdStdinErr := cmd.StdinPipe()
+	// The input is an os.File, unlike the one of StdinPipe, so that the
+	// writes are limited by a deadline.
+	stdinReader, cmdStdin, cmdStdinErr := os.Pipe()
 	if cmdStdinErr != nil {
 		return nil, cmdStdinErr
 	}
+	cmd.Stdin = stdinReader
 	cmdStdout, cmdStdoutErr := cmd.StdoutPipe()
 	if cmdStdoutErr != nil {
 		return nil, cmdStdoutErr
@@ -65,39 +563,113 @@ func newWorker(id uint, binPath string, args []string, timeout time.Duration, lo
 		return nil, cmdStderrErr
 	}
 
+	var bodyReader *os.File
+	if bodyPipe {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		cmd.ExtraFiles = []*os.File{r}
+		bodyReader = r
+		w.bodyPipe = pw
+	}
+
 	if err := cmd.Start(); err != nil {
+		stdinReader.Close()
+		cmdStdin.Close()
+		if bodyReader != nil {
+			bodyReader.Close()
+			w.bodyPipe.Close()
+		}
 		return nil, err
 	}
+	// The read ends are owned by the worker process now.
+	stdinReader.Close()
+	if bodyReader != nil {
+		bodyReader.Close()
+	}
 
 	w.Cmd = cmd
 	w.Pid = cmd.Process.Pid
 	w.stdin = cmdStdin
+	w.stdinWriter = bufio.NewWriter(cmdStdin)
 	w.stdout = cmdStdout
+	w.exited = make(chan struct{})
+	w.stdoutLines = pipeListener(cmdStdout, func() {
+		// The process is reaped once its output is read, whether it was
+		// terminated or it crashed, so that no zombie process remains.
+		w.exitErr = cmd.Wait()
+		close(w.exited)
+	})
 	w.stderr = cmdStderr
 	w.timeout = timeout
 	return w, nil
 }
 
+// isExpired returns true when the worker is older than its max age.
+func (w *worker) isExpired() bool {
+	return w.maxAge > 0 && time.Since(w.startedAt) > w.maxAge
+}
+
 // getProcessPid returns process id of the worker.
 func (w *worker) getProcessPid() int {
 	return w.Pid
 }
 
-// terminate shuts down the worker.
+// defaultShutdownTimeout is the default max time the shutdown function
+// takes.
+const defaultShutdownTimeout = 5 * time.Second
+
+// terminateTimeout is the max time terminate waits for the output pipe of
+// the killed process to close.
+const terminateTimeout = 5 * time.Second
+
+// terminate shuts down the worker and waits until the process is reaped.
+// The shutdown function, if any, is invoked before the process is killed.
 func (w *worker) terminate() error {
 	w.Terminated = true
+	w.shutdown()
+	if w.tmpDir != "" {
+		// The directory is removed once the process is reaped.
+		defer os.RemoveAll(w.tmpDir)
+	}
+	if w.bodyPipe != nil {
+		w.bodyPipe.Close()
+	}
 	if w.Cmd == nil {
 		return nil
 	}
 	if w.Cmd.Process == nil {
 		return nil
 	}
-	err := w.Cmd.Process.Kill()
-	if err != nil {
+	if err := w.Cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
 		return err
 	}
+	if w.exited == nil {
+		// The process was not started by newWorker, so it is not reaped.
+		return nil
+	}
 
-	err = w.Cmd.Wait()
+	lines := w.stdoutLines
+	timeout := time.After(terminateTimeout)
+	for exited := false; !exited; {
+		select {
+		case <-w.exited:
+			exited = true
+		case _, ok := <-lines:
+			// The output not read by a request is discarded, so that the
+			// listener reaches the end of the pipe.
+			if !ok {
+				lines = nil
+			}
+		case <-timeout:
+			// A child process of the worker keeps the output pipe open,
+			// so the pipe is closed to stop reading it.
+			w.stdout.Close()
+			timeout = nil
+		}
+	}
+	err := w.exitErr
 	if err == nil {
 		return nil
 	}
@@ -107,32 +679,100 @@ func (w *worker) terminate() error {
 	return err
 }
 
-func readPipe(ch chan string, stopWord string, timeout time.Duration) ([]string, bool) {
+// shutdown invokes the shutdown function, if the worker imported its
+// entrypoint and is not serving a request, and waits for it for up to the
+// shutdown timeout.
+func (w *worker) shutdown() {
+	handler := w.shutdownHandler
+	if handler == nil || w.stdinWriter == nil || w.stdoutLines == nil {
+		return
+	}
+	select {
+	case <-w.exited:
+		return
+	default:
+	}
+	// The worker serving a request, e.g. the one which timed out, is killed
+	// without waiting for the request.
+	if !w.mu.TryLock() {
+		return
+	}
+	defer w.mu.Unlock()
+	if !w.imports[handler.importedPath] {
+		return
+	}
+
+	requestID := "shutdown-" + strconv.Itoa(int(w.ID))
+	defer w.setWriteDeadline(w.writeTimeout)()
+	err := w.write("__lambda_shutdown(" + pythonString(handler.importedPath) + ", " + pythonString(handler.handlerName) + ", " + pythonString(requestID) + ")")
+	if err == nil {
+		err = w.flush()
+	}
+	if err == nil {
+		var lines []string
+		lines, err = readPipe(w.stdoutLines, "CMD_OUTPUT_END="+requestID+";", w.shutdownTimeout, 0)
+		_, err = w.parseOutput(handler, requestID, lines, err)
+	}
+	if err != nil {
+		w.logger.Warn(
+			"failed executing lambda shutdown function",
+			zap.String("lambda_name", handler.lambdaName),
+			zap.Uint("worker_id", w.ID),
+			zap.Error(err),
+		)
+		return
+	}
+	w.logger.Debug(
+		"completed lambda shutdown function",
+		zap.String("lambda_name", handler.lambdaName),
+		zap.Uint("worker_id", w.ID),
+	)
+}
+
+// readPipe reads the lines from the channel up to the line with the stop
+// word. It fails when no line arrives within the timeout, or, if
+// maxDuration is not zero, when the stop word does not arrive within
+// maxDuration, even though the lines keep arriving.
+func readPipe(ch chan string, stopWord string, timeout, maxDuration time.Duration) ([]string, error) {
+	var maxDurationExceeded <-chan time.Time
+	if maxDuration > 0 {
+		timer := time.NewTimer(maxDuration)
+		defer timer.Stop()
+		maxDurationExceeded = timer.C
+	}
 	var lines []string
 	for {
 		select {
 		case line, ok := <-ch:
 			if !ok {
-				return lines, false
+				return lines, errWorkerExited
 			}
 			lines = append(lines, line)
 			if strings.Contains(line, stopWord) {
-				return lines, false
+				return lines, nil
 			}
 		case <-time.After(timeout):
-			return lines, true
+			return lines, errWorkerTimeout
+		case <-maxDurationExceeded:
+			return lines, errWorkerMaxDuration
 		}
 	}
 }
 
-func pipeListener(pipe io.Reader) chan string {
+// pipeListener sends the lines read from the pipe to the returned channel.
+// When the pipe is closed, the channel is closed and onClose, if any, is
+// called.
+func pipeListener(pipe io.Reader, onClose func()) chan string {
 	ch := make(chan string)
 	go func(ch chan string) {
-		defer close(ch)
 		scanner := bufio.NewScanner(pipe)
 		for scanner.Scan() {
 			ch <- scanner.Text()
 		}
+		close(ch)
+		if onClose != nil {
+			onClose()
+		}
 	}(ch)
 	return ch
 }
@@ -147,50 +787,435 @@ func parseStatusCode(s string) (int, error) {
 	return 0, fmt.Errorf("failed to parse integer from input string: %s", s)
 }
 
-func (w *worker) handle(importedPath, handlerName string, data map[string]interface{}) (int, []byte, error) {
+// pythonString returns the string as a Python string literal.
+func pythonString(s string) string {
+	b, _ := marshalJSON(s, false)
+	return string(b)
+}
+
+// marshalJSON returns the JSON encoding of the value. Unless escapeHTML is
+// true, the <, >, and & characters are written verbatim instead of the
+// \u003c, \u003e, and \u0026 escapes.
+func marshalJSON(v interface{}, escapeHTML bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(escapeHTML)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// logHandlerRecord emits the structured log record printed by the handler,
+// i.e. the CMD_LOG line holding a JSON object with the level, the msg, and
+// the fields of the record.
+func (w *worker) logHandlerRecord(handler *handlerSpec, requestID, line string) {
+	record := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "CMD_LOG=")), &record); err != nil {
+		w.logger.Warn(
+			"encountered error",
+			zap.String("request_id", requestID),
+			zap.Error(fmt.Errorf("failed to parse log record from input string: %s", line)),
+		)
+		return
+	}
+	level, _ := record["level"].(string)
+	msg, _ := record["msg"].(string)
+	if msg == "" {
+		msg = "lambda handler log"
+	}
+	delete(record, "level")
+	delete(record, "msg")
+
+	ce := w.logger.Check(parseHandlerLogLevel(level), msg)
+	if ce == nil {
+		return
+	}
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fields := []zap.Field{
+		zap.String("lambda_name", handler.lambdaName),
+		zap.String("request_id", requestID),
+		zap.Uint("worker_id", w.ID),
+	}
+	for _, k := range keys {
+		fields = append(fields, zap.Any(k, record[k]))
+	}
+	ce.Write(fields...)
+}
+
+// parseHandlerLogLevel returns the level of the handler log record. The
+// levels above error, e.g. critical, are logged as errors, so that the
+// handler cannot stop the server. The unknown levels are logged as info.
+func parseHandlerLogLevel(s string) zapcore.Level {
+	switch strings.ToLower(s) {
+	case "debug":
+		return zapcore.DebugLevel
+	case "warn", "warning":
+		return zapcore.WarnLevel
+	case "error", "critical", "fatal":
+		return zapcore.ErrorLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
+
+func parseStats(s string) (*workerStats, error) {
+	s = strings.TrimPrefix(s, "CMD_STATS=")
+	stats := &workerStats{}
+	if err := json.Unmarshal([]byte(s), stats); err != nil {
+		return nil, fmt.Errorf("failed to parse stats from input string: %s", s)
+	}
+	return stats, nil
+}
+
+// parseHeaders parses response headers returned by the handler. A header
+// value is either a string or a list of strings.
+func parseHeaders(s string) (http.Header, error) {
+	s = strings.TrimPrefix(s, "CMD_OUTPUT_HEADERS=")
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, fmt.Errorf("failed to parse headers from input string: %s", s)
+	}
+	headers := make(http.Header)
+	for k, v := range m {
+		switch values := v.(type) {
+		case []interface{}:
+			for _, value := range values {
+				headers.Add(k, fmt.Sprint(value))
+			}
+		default:
+			headers.Add(k, fmt.Sprint(values))
+		}
+	}
+	return headers, nil
+}
+
+func parseBodyFile(s string) (string, error) {
+	s = strings.TrimPrefix(s, "CMD_OUTPUT_BODY_FILE=")
+	var fp string
+	if err := json.Unmarshal([]byte(s), &fp); err != nil {
+		return "", fmt.Errorf("failed to parse body file from input string: %s", s)
+	}
+	return fp, nil
+}
+
+func parseConnection(s string) (string, error) {
+	s = strings.TrimPrefix(s, "CMD_OUTPUT_CONNECTION=")
+	var connection string
+	if err := json.Unmarshal([]byte(s), &connection); err != nil || !isConnectionHint(connection) {
+		return "", fmt.Errorf("failed to parse connection from input string: %s", s)
+	}
+	return connection, nil
+}
+
+func parseSignedRedirect(s string) (*signedRedirect, error) {
+	s = strings.TrimPrefix(s, "CMD_OUTPUT_REDIRECT_SIGNED=")
+	var redirect signedRedirect
+	if err := json.Unmarshal([]byte(s), &redirect); err != nil {
+		return nil, fmt.Errorf("failed to parse signed redirect from input string: %s", s)
+	}
+	return &redirect, nil
+}
+
+func parseHandlerError(s string) error {
+	s = strings.TrimPrefix(s, "CMD_ERROR=")
+	return fmt.Errorf("%w: %s", errHandlerFailed, parsePythonString(s))
+}
+
+// handlerHTTPError is the HTTP error the handler raised via
+// LambdaHTTPError.
+type handlerHTTPError struct {
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message"`
+}
+
+func parseHTTPError(s string) (*handlerHTTPError, error) {
+	s = strings.TrimPrefix(s, "CMD_HTTP_ERROR=")
+	e := &handlerHTTPError{}
+	if err := json.Unmarshal([]byte(s), e); err != nil {
+		return nil, fmt.Errorf("failed to parse http error from input string: %s", s)
+	}
+	return e, nil
+}
+
+// parsePythonString decodes the JSON string printed by the worker. If the
+// string is malformed, it is returned as is.
+func parsePythonString(s string) string {
+	var msg string
+	if err := json.Unmarshal([]byte(s), &msg); err != nil {
+		return s
+	}
+	return msg
+}
+
+// getHandlerContext returns the Python expression of the data of the
+// context object passed to the handler, or None when the handler takes
+// the event only.
+func (w *worker) getHandlerContext(handler *handlerSpec) string {
+	if handler.signature != handlerSignatureEventContext {
+		return "None"
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"function_name": handler.lambdaName,
+		"timeout_ms":    w.timeout.Milliseconds(),
+	})
+	return pythonString(string(b))
+}
+
+// isClosed returns true when the worker is terminated or its pipes are
+// not set up, e.g. the worker was dropped from the pool without terminate.
+// The worker must not be used then.
+func (w *worker) isClosed() bool {
+	return w.Terminated || w.stdinWriter == nil || w.stdoutLines == nil
+}
+
+// write buffers a line of code for the worker. The line is sent to the
+// worker on flush.
+func (w *worker) write(s string) error {
+	if _, err := w.stdinWriter.WriteString(s + "\n"); err != nil {
+		return wrapWriteError(err)
+	}
+	return nil
+}
+
+// flush sends the buffered lines of code to the worker.
+func (w *worker) flush() error {
+	if err := w.stdinWriter.Flush(); err != nil {
+		return wrapWriteError(err)
+	}
+	return nil
+}
+
+// setWriteDeadline limits the writes to the worker input, e.g. when the
+// worker stopped reading it, to the timeout. It returns the function
+// removing the deadline. If the timeout is zero, the writes are not limited.
+func (w *worker) setWriteDeadline(timeout time.Duration) func() {
+	if timeout <= 0 {
+		return func() {}
+	}
+	w.stdin.SetWriteDeadline(time.Now().Add(timeout))
+	return func() { w.stdin.SetWriteDeadline(time.Time{}) }
+}
+
+// wrapWriteError returns the worker error of the failed write.
+func wrapWriteError(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: %v", errWorkerWriteTimeout, err)
+	}
+	return fmt.Errorf("%w: %v", errWorkerBrokenPipe, err)
+}
+
+// writeBody sends the raw request body to the worker. The worker reads it
+// at the start of the invocation, so the write blocks for up to the timeout,
+// or the worker timeout if zero, only.
+func (w *worker) writeBody(body []byte, timeout time.Duration) error {
+	if len(body) == 0 {
+		return nil
+	}
+	if timeout == 0 {
+		timeout = w.timeout
+	}
+	w.bodyPipe.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := w.bodyPipe.Write(body); err != nil {
+		return wrapWriteError(err)
+	}
+	return nil
+}
+
+// send writes the invocation of the handler to the worker. When stream is
+// set, i.e. sse or multipart, the handler may return an iterator of the
+// records of the stream. With body, the body of the response is written in
+// chunks. On failure, it returns the response to the
+// request. The caller must hold the lock.
+func (w *worker) send(handler *handlerSpec, requestID string, env *requestEnvelope, stream string) (*workerResponse, error) {
+	if w.isClosed() {
+		// The request is not delivered, so it is safe to retry it on
+		// another worker.
+		return &workerResponse{StatusCode: http.StatusBadGateway, WorkerID: w.ID}, errWorkerBrokenPipe
+	}
+
+	var enc *envelopeEncoding
+	if w.tmpDir != "" {
+		// The data holds the directory of the worker, so its encoding is
+		// not reused.
+		enc = encodeRequestData(withTmpDir(env.data, w.tmpDir), handler.escapeHTML, w.bodyPipe != nil)
+	} else {
+		enc = env.encode(handler.escapeHTML, w.bodyPipe != nil)
+	}
+	body := enc.body
+	if enc.err != nil {
+		return &workerResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       []byte(http.StatusText(http.StatusBadRequest)),
+			WorkerID:   w.ID,
+		}, nil
+	}
+
+	writeTimeout := w.writeTimeout
+	if writeTimeout > 0 && !w.imports[handler.importedPath] {
+		// The worker reads the invocation once the import completes.
+		writeTimeout += w.getImportTimeout()
+	}
+	defer w.setWriteDeadline(writeTimeout)()
+
+	if !w.bootstrapped {
+		if err := w.write("exec(" + pythonString(pythonBootstrap) + ")"); err != nil {
+			return &workerResponse{StatusCode: getWriteErrorStatusCode(err), WorkerID: w.ID}, err
+		}
+		w.bootstrapped = true
+	}
+	if !w.imports[handler.importedPath] {
+		if err := w.write("__lambda_import(" + pythonString(handler.importedPath) + ")"); err != nil {
+			return &workerResponse{StatusCode: getWriteErrorStatusCode(err), WorkerID: w.ID}, err
+		}
+		w.imports[handler.importedPath] = true
+	}
+
+	args := []string{
+		pythonString(handler.importedPath),
+		pythonString(handler.handlerName),
+		pythonString(requestID),
+		enc.literal,
+		w.getHandlerContext(handler),
+	}
+	if body != nil {
+		args = append(args, "body_size="+strconv.Itoa(len(body)))
+	}
+	if stream != "" {
+		args = append(args, "stream="+pythonString(stream))
+	}
+	if handler.unpacked {
+		args = append(args, "unpacked=True")
+	}
+	if handler.decodeBody {
+		args = append(args, "decode_body=True")
+	}
+	if handler.hook {
+		args = append(args, "hook=True")
+	}
+	if handler.autoJSON {
+		args = append(args, "auto_json=True")
+	}
+	if len(handler.resultKeys) > 0 {
+		// The JSON object of strings is a valid dict literal.
+		b, _ := json.Marshal(handler.resultKeys)
+		args = append(args, "result_keys="+string(b))
+	}
+	if err := w.write("__lambda_invoke(" + strings.Join(args, ", ") + ")"); err != nil {
+		return &workerResponse{StatusCode: getWriteErrorStatusCode(err), WorkerID: w.ID}, err
+	}
+	// Send the complete invocation block at once.
+	if err := w.flush(); err != nil {
+		return &workerResponse{StatusCode: getWriteErrorStatusCode(err), WorkerID: w.ID}, err
+	}
+	if err := w.writeBody(body, writeTimeout); err != nil {
+		return &workerResponse{StatusCode: getWriteErrorStatusCode(err), WorkerID: w.ID}, err
+	}
+	return nil, nil
+}
+
+// getWriteErrorStatusCode returns the status code of the response to the
+// request which failed to be written to the worker.
+func getWriteErrorStatusCode(err error) int {
+	if errors.Is(err, errWorkerWriteTimeout) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+func (w *worker) handle(handler *handlerSpec, requestID string, env *requestEnvelope) (*workerResponse, error) {
 	w.mu.Lock()
-	w.InUse = true
-	defer func() {
-		w.mu.Unlock()
-		w.InUse = false
-	}()
-
-	if !w.importComplete {
-		io.WriteString(w.stdin, "from "+importedPath+" import *")
-		io.WriteString(w.stdin, "\n")
-		io.WriteString(w.stdin, "import json")
-		io.WriteString(w.stdin, "\n")
-		w.importComplete = true
-	}
-
-	// Marshal the map into a JSON byte slice
-	encodedData, err := json.Marshal(data)
-	if err != nil {
-		return http.StatusBadRequest, []byte(http.StatusText(http.StatusBadRequest)), nil
-	}
-
-	// Convert the byte slice to a JSON string
-	requestID := data["request_id"].(string)
-	stdout := pipeListener(w.stdout)
-	io.WriteString(w.stdin, `resp = handler(` + string(encodedData) + `)`)
-	io.WriteString(w.stdin, "\n")
-	io.WriteString(w.stdin, `print("CMD_OUTPUT_START=`+requestID+`;")`)
-	io.WriteString(w.stdin, "\n")
-	io.WriteString(w.stdin, `print(f"CMD_STATUS_CODE={resp['status_code']};")`)
-	io.WriteString(w.stdin, "\n")
-	io.WriteString(w.stdin, `print(f"CMD_OUTPUT_BODY={resp['body']}")`)
-	io.WriteString(w.stdin, "\n")
-	io.WriteString(w.stdin, `print(f"CMD_OUTPUT_END=`+requestID+`;")`)
-	io.WriteString(w.stdin, "\n")
-	lines, timedOut := readPipe(stdout, "CMD_OUTPUT_END=", w.timeout)
+	defer w.mu.Unlock()
+
+	cold := !w.imports[handler.importedPath]
+	if r, err := w.send(handler, requestID, env, ""); r != nil {
+		r.Cold = cold
+		return r, err
+	}
+	var lines []string
+	if cold {
+		importLines, err := w.waitImport()
+		if err != nil {
+			r, err := w.parseOutput(handler, requestID, importLines, err)
+			r.Cold = cold
+			return r, err
+		}
+		lines = importLines
+	}
+	invokeLines, readErr := readPipe(w.stdoutLines, "CMD_OUTPUT_END="+requestID+";", w.timeout, w.maxDuration)
+	r, err := w.parseOutput(handler, requestID, append(lines, invokeLines...), readErr)
+	r.Cold = cold
+	return r, err
+}
+
+// withTmpDir returns a copy of the request data with the tmpdir field set
+// to the temporary directory of the worker, so that the data dispatched to
+// another worker on retry is not affected.
+func withTmpDir(data map[string]interface{}, dir string) map[string]interface{} {
+	m := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		m[k] = v
+	}
+	m["tmpdir"] = dir
+	return m
+}
+
+// waitImport waits for the worker to import the entrypoint, for up to the
+// import timeout, and returns the lines printed during the import.
+func (w *worker) waitImport() ([]string, error) {
+	return readPipe(w.stdoutLines, "CMD_IMPORTED=", w.getImportTimeout(), 0)
+}
+
+// getImportTimeout returns the max time the import of an entrypoint takes.
+func (w *worker) getImportTimeout() time.Duration {
+	if w.importTimeout == 0 {
+		return w.timeout
+	}
+	return w.importTimeout
+}
+
+// parseOutput returns the response of the handler from the lines printed
+// by the worker.
+func (w *worker) parseOutput(handler *handlerSpec, requestID string, lines []string, readErr error) (*workerResponse, error) {
+	// The raw output shows what the handler returned when the parsed
+	// response looks wrong, e.g. the body is empty.
+	if ce := w.logger.Check(zap.DebugLevel, "received lambda worker output"); ce != nil {
+		ce.Write(
+			zap.String("request_id", requestID),
+			zap.Uint("worker_id", w.ID),
+			zap.Strings("lines", lines),
+		)
+	}
+	var err error
 	recordingOn := false
+	started := false
 	statusCode := 200
+	// statusFound is true when the output holds the status code. The
+	// bootstrap always prints it, but the markers are not found when e.g.
+	// the handler writes to stdout without the trailing newline.
+	statusFound := false
 	stdoutOutput := []string{}
+	var handlerErr error
+	var stats *workerStats
+	var headers http.Header
+	var bodyFile string
+	var redirect *signedRedirect
+	var connection string
+	var httpErr *handlerHTTPError
 	for _, line := range lines {
+		if strings.HasPrefix(line, "CMD_LOG=") {
+			w.logHandlerRecord(handler, requestID, line)
+			continue
+		}
 		if !recordingOn {
 			if strings.HasPrefix(line, "CMD_OUTPUT_START=") {
 				if strings.HasPrefix(line, "CMD_OUTPUT_START="+requestID+";") {
 					recordingOn = true
+					started = true
 				}
 			}
 			continue
@@ -205,6 +1230,82 @@ func (w *worker) handle(importedPath, handlerName string, data map[string]interf
 				)
 			} else {
 				statusCode = code
+				statusFound = true
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "CMD_IMPORT_ERROR=") {
+			w.logger.Error(
+				"failed importing lambda entrypoint",
+				zap.String("request_id", requestID),
+				zap.String("import_path", handler.importedPath),
+				zap.String("error", parsePythonString(strings.TrimPrefix(line, "CMD_IMPORT_ERROR="))),
+			)
+			continue
+		}
+		if strings.HasPrefix(line, "CMD_ERROR=") {
+			handlerErr = parseHandlerError(line)
+			continue
+		}
+		if strings.HasPrefix(line, "CMD_HTTP_ERROR=") {
+			httpErr, err = parseHTTPError(line)
+			if err != nil {
+				handlerErr = fmt.Errorf("%w: %v", errHandlerFailed, err)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "CMD_OUTPUT_HEADERS=") {
+			headers, err = parseHeaders(line)
+			if err != nil {
+				w.logger.Warn(
+					"encountered error",
+					zap.String("request_id", requestID),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "CMD_STATS=") {
+			stats, err = parseStats(line)
+			if err != nil {
+				w.logger.Warn(
+					"encountered error",
+					zap.String("request_id", requestID),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "CMD_OUTPUT_BODY_FILE=") {
+			bodyFile, err = parseBodyFile(line)
+			if err != nil {
+				w.logger.Warn(
+					"encountered error",
+					zap.String("request_id", requestID),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "CMD_OUTPUT_CONNECTION=") {
+			connection, err = parseConnection(line)
+			if err != nil {
+				w.logger.Warn(
+					"encountered error",
+					zap.String("request_id", requestID),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "CMD_OUTPUT_REDIRECT_SIGNED=") {
+			redirect, err = parseSignedRedirect(line)
+			if err != nil {
+				w.logger.Warn(
+					"encountered error",
+					zap.String("request_id", requestID),
+					zap.Error(err),
+				)
 			}
 			continue
 		}
@@ -219,13 +1320,60 @@ func (w *worker) handle(importedPath, handlerName string, data map[string]interf
 				continue
 			}
 		}
-		stdoutOutput = append(stdoutOutput, "Y"+line)
+		// The body spans multiple lines.
+		stdoutOutput = append(stdoutOutput, line)
 	}
 
 	output := strings.Join(stdoutOutput, "\n")
-	if timedOut {
-		return http.StatusRequestTimeout, []byte(http.StatusText(http.StatusRequestTimeout)), nil
+	switch readErr {
+	case errWorkerTimeout, errWorkerMaxDuration:
+		if started {
+			// The handler completed, but the worker never finished the output.
+			w.logger.Warn(
+				"lambda worker output is truncated",
+				zap.String("request_id", requestID),
+				zap.Uint("worker_id", w.ID),
+				zap.Int("line_count", len(lines)),
+			)
+			if handler.partialOnTimeout {
+				// The worker is replaced, but the output is served.
+				return &workerResponse{StatusCode: http.StatusPartialContent, Body: []byte(output), WorkerID: w.ID, Stats: stats, Headers: headers, Partial: true}, errWorkerTruncated
+			}
+			return &workerResponse{StatusCode: http.StatusBadGateway, WorkerID: w.ID}, errWorkerTruncated
+		}
+		return &workerResponse{StatusCode: http.StatusRequestTimeout, WorkerID: w.ID}, readErr
+	case errWorkerExited:
+		return &workerResponse{StatusCode: http.StatusBadGateway, WorkerID: w.ID}, readErr
+	}
+	if handlerErr != nil {
+		return &workerResponse{StatusCode: http.StatusInternalServerError, WorkerID: w.ID}, handlerErr
+	}
+	if httpErr != nil {
+		// The handler aborted with LambdaHTTPError. The message is the body.
+		body := httpErr.Message
+		if body == "" {
+			body = http.StatusText(httpErr.StatusCode)
+		}
+		if headers == nil {
+			headers = make(http.Header)
+		}
+		if headers.Get("Content-Type") == "" {
+			headers.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		return &workerResponse{StatusCode: httpErr.StatusCode, Body: []byte(body), WorkerID: w.ID, Stats: stats, Headers: headers}, nil
+	}
+	if handler.requireOutput && !statusFound {
+		// Otherwise, the missing output is served as an empty 200 response.
+		w.logger.Error(
+			"lambda handler produced no output",
+			zap.String("lambda_name", handler.lambdaName),
+			zap.String("request_id", requestID),
+			zap.Uint("worker_id", w.ID),
+			zap.Bool("started", started),
+			zap.Int("line_count", len(lines)),
+		)
+		return &workerResponse{StatusCode: http.StatusInternalServerError, WorkerID: w.ID, Stats: stats}, errHandlerNoOutput
 	}
 
-	return statusCode, []byte(output), nil
+	return &workerResponse{StatusCode: statusCode, Body: []byte(output), WorkerID: w.ID, Stats: stats, Headers: headers, BodyFile: bodyFile, RedirectSigned: redirect, Connection: connection}, nil
 }
@@ -0,0 +1,833 @@
+// Copyright 2024 Paul Greenberg @greenpau
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestParseStats(t *testing.T) {
+	for i, tc := range []struct {
+		line      string
+		want      *workerStats
+		shouldErr bool
+	}{
+		{
+			line: `CMD_STATS={"user_cpu_ms": 1.5, "system_cpu_ms": 0.25, "max_rss_kb": 10240}`,
+			want: &workerStats{
+				UserCPUMillis:   1.5,
+				SystemCPUMillis: 0.25,
+				MaxRSSKilobytes: 10240,
+			},
+		},
+		{
+			line:      `CMD_STATS=foo`,
+			shouldErr: true,
+		},
+	} {
+		got, err := parseStats(tc.line)
+		if err != nil {
+			if !tc.shouldErr {
+				t.Fatalf("unexpected parseStats() error: %v", err)
+			}
+			t.Logf("PASS: Test %d", i)
+			continue
+		}
+		if tc.shouldErr {
+			t.Fatalf("unexpected parseStats() success")
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Fatalf("unexpected stats mismatch (-want +got):\n%s", diff)
+		}
+		t.Logf("PASS: Test %d", i)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	data := map[string]interface{}{
+		"query_params": map[string]interface{}{"q": "<script>a && b</script>"},
+	}
+	for i, tc := range []struct {
+		name       string
+		escapeHTML bool
+		want       string
+	}{
+		{
+			name: "test html characters written verbatim",
+			want: `{"query_params":{"q":"<script>a && b</script>"}}`,
+		},
+		{
+			name:       "test html characters escaped",
+			escapeHTML: true,
+			want:       `{"query_params":{"q":"\u003cscript\u003ea \u0026\u0026 b\u003c/script\u003e"}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := marshalJSON(data, tc.escapeHTML)
+			if err != nil {
+				t.Fatalf("unexpected marshalJSON() error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, string(b)); diff != "" {
+				t.Fatalf("unexpected encoding mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerStats(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+	}`)
+	defer fex.Cleanup()
+
+	req := newRequest(t, "GET", "/")
+	r, err := fex.execRequest(req, "test-request-id")
+	if err != nil {
+		t.Fatalf("unexpected execRequest() error: %v", err)
+	}
+	if r.Stats == nil {
+		t.Fatalf("expected worker stats, got none")
+	}
+	if r.Stats.MaxRSSKilobytes <= 0 {
+		t.Fatalf("unexpected max_rss_kb: %d", r.Stats.MaxRSSKilobytes)
+	}
+}
+
+func TestWorkerColdStart(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers 1
+	}`)
+	defer fex.Cleanup()
+
+	for i, want := range []bool{true, false, false} {
+		req := newRequest(t, "GET", "/")
+		r, err := fex.execRequest(req, fmt.Sprintf("test-request-id-%d", i))
+		if err != nil {
+			t.Fatalf("unexpected execRequest() error in invocation %d: %v", i, err)
+		}
+		if r.Cold != want {
+			t.Fatalf("unexpected cold start in invocation %d: got %t, want %t", i, r.Cold, want)
+		}
+		t.Logf("PASS: Test %d", i)
+	}
+}
+
+func TestWorkerSequentialInvocations(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers 1
+	}`)
+	defer fex.Cleanup()
+
+	for i := 0; i < 200; i++ {
+		req := newRequest(t, "GET", fmt.Sprintf("/?n=%d", i))
+		r, err := fex.execRequest(req, fmt.Sprintf("test-request-id-%d", i))
+		if err != nil {
+			t.Fatalf("unexpected execRequest() error in invocation %d: %v", i, err)
+		}
+		want := fmt.Sprintf(`"query_params": {"n": "%d"}`, i)
+		if !strings.Contains(string(r.Body), want) {
+			t.Fatalf("unexpected body in invocation %d: %s", i, r.Body)
+		}
+	}
+}
+
+func TestWorkerNonDictResponse(t *testing.T) {
+	for i, tc := range []struct {
+		function string
+		want     string
+	}{
+		{
+			function: "string_handler",
+			want:     "handler returned str, expected dict",
+		},
+		{
+			function: "none_handler",
+			want:     "handler returned NoneType, expected dict",
+		},
+	} {
+		t.Run(tc.function, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name malformed
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/malformed/app/index.py
+				function `+tc.function+`
+			}`)
+			defer fex.Cleanup()
+
+			req := newRequest(t, "GET", "/")
+			r, err := fex.execWorker(req.Method, "", "", fex.newEnvelope(fex.buildRequestData(req, "test-request-id")))
+			if !errors.Is(err, errHandlerFailed) || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("unexpected execWorker() error: got %v, want %q", err, tc.want)
+			}
+			if r.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("unexpected status code: got %d, want %d", r.StatusCode, http.StatusInternalServerError)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerRequireOutput(t *testing.T) {
+	// The handler printing nothing still gets the markers printed by the
+	// bootstrap, so the handler hides them instead.
+	for i, tc := range []struct {
+		name           string
+		option         string
+		wantStatusCode int
+		wantErr        error
+	}{
+		{
+			name:           "test lenient mode responds with empty body",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "test strict mode fails invocation without output",
+			option:         "require_output",
+			wantStatusCode: http.StatusInternalServerError,
+			wantErr:        errHandlerNoOutput,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name malformed
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/malformed/app/index.py
+				function unterminated_handler
+				`+tc.option+`
+			}`)
+			defer fex.Cleanup()
+
+			// The output of the invocation ends with the end marker, so the
+			// next invocation on the worker is not affected.
+			for n := 0; n < 2; n++ {
+				req := newRequest(t, "GET", "/")
+				r, err := fex.execWorker(req.Method, "", "", fex.newEnvelope(fex.buildRequestData(req, fmt.Sprintf("test-request-id-%d", n))))
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("unexpected execWorker() error in invocation %d: got %v, want %v", n, err, tc.wantErr)
+				}
+				if r.StatusCode != tc.wantStatusCode {
+					t.Fatalf("unexpected status code in invocation %d: got %d, want %d", n, r.StatusCode, tc.wantStatusCode)
+				}
+				if len(r.Body) != 0 {
+					t.Fatalf("unexpected body in invocation %d: %s", n, r.Body)
+				}
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerImportIsolation(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+	}`)
+	defer fex.Cleanup()
+
+	// A single interpreter serves handlers from both a broken and a working entrypoint.
+	w := fex.workers.getWorkers()[0]
+	data := map[string]interface{}{"request_id": "test-request-id"}
+	for i, tc := range []struct {
+		importedPath string
+		statusCode   int
+		err          string
+	}{
+		{
+			importedPath: "assets.scripts.api.broken.app.index",
+			statusCode:   http.StatusInternalServerError,
+			err:          "failed importing assets.scripts.api.broken.app.index: SyntaxError",
+		},
+		{
+			importedPath: "assets.scripts.api.hello_world.app.index",
+			statusCode:   http.StatusOK,
+		},
+		{
+			importedPath: "assets.scripts.api.broken.app.index",
+			statusCode:   http.StatusInternalServerError,
+			err:          "failed importing assets.scripts.api.broken.app.index: SyntaxError",
+		},
+	} {
+		handler := &handlerSpec{lambdaName: "hello_world", importedPath: tc.importedPath, handlerName: "handler"}
+		r, err := w.handle(handler, fmt.Sprintf("test-request-id-%d", i), newRequestEnvelope(data))
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("unexpected handle() error: got %v, want %q", err, tc.err)
+			}
+		} else if err != nil {
+			t.Fatalf("unexpected handle() error: %v", err)
+		}
+		if r.StatusCode != tc.statusCode {
+			t.Fatalf("unexpected status code: got %d, want %d", r.StatusCode, tc.statusCode)
+		}
+		t.Logf("PASS: Test %d", i)
+	}
+}
+
+func TestWorkerRawOutputLog(t *testing.T) {
+	for i, tc := range []struct {
+		name  string
+		level zapcore.Level
+		want  []string
+	}{
+		{
+			name:  "test raw output is logged at debug level",
+			level: zapcore.DebugLevel,
+			want: []string{
+				`CMD_IMPORTED="assets.scripts.api.status.app.index"`,
+				"CMD_OUTPUT_START=test-request-id;",
+				"CMD_STATUS_CODE=404;",
+				"CMD_OUTPUT_BODY=page not found: /",
+				"CMD_OUTPUT_END=test-request-id;",
+			},
+		},
+		{
+			name:  "test raw output is not logged at info level",
+			level: zapcore.InfoLevel,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name status
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/status/app/index.py
+				function not_found_handler
+			}`)
+			defer fex.Cleanup()
+
+			core, logs := observer.New(tc.level)
+			fex.workers.getWorkers()[0].logger = zap.New(core)
+			req := newRequest(t, "GET", "/")
+			if _, err := fex.execRequest(req, "test-request-id"); err != nil {
+				t.Fatalf("unexpected execRequest() error: %v", err)
+			}
+
+			var got []string
+			for _, entry := range logs.FilterMessage("received lambda worker output").All() {
+				for _, line := range entry.ContextMap()["lines"].([]interface{}) {
+					// The stats vary between invocations.
+					if !strings.HasPrefix(line.(string), "CMD_STATS=") {
+						got = append(got, line.(string))
+					}
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected raw output mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerHandlerLog(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name log
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/log/app/index.py
+		function handler
+	}`)
+	defer fex.Cleanup()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	fex.workers.getWorkers()[0].logger = zap.New(core)
+	req := newRequest(t, "GET", "/")
+	r, err := fex.execRequest(req, "test-request-id")
+	if err != nil {
+		t.Fatalf("unexpected execRequest() error: %v", err)
+	}
+	// The log records are not a part of the body.
+	if string(r.Body) != "ok" {
+		t.Fatalf("unexpected body: %q", r.Body)
+	}
+
+	type record struct {
+		Level  zapcore.Level
+		Msg    string
+		Fields map[string]interface{}
+	}
+	var got []record
+	for _, entry := range logs.All() {
+		if entry.Message == "received lambda worker output" {
+			continue
+		}
+		got = append(got, record{Level: entry.Level, Msg: entry.Message, Fields: entry.ContextMap()})
+	}
+	want := []record{
+		{
+			Level: zapcore.InfoLevel,
+			Msg:   "created user",
+			Fields: map[string]interface{}{
+				"lambda_name": "log",
+				"request_id":  "test-request-id",
+				"worker_id":   uint64(0),
+				"user_id":     float64(42),
+				"tags":        []interface{}{"new"},
+			},
+		},
+		{
+			Level: zapcore.ErrorLevel,
+			Msg:   "quota exceeded",
+			Fields: map[string]interface{}{
+				"lambda_name": "log",
+				"request_id":  "test-request-id",
+				"worker_id":   uint64(0),
+				"quota":       "disk",
+			},
+		},
+		{
+			Level: zapcore.WarnLevel,
+			Msg:   "encountered error",
+			Fields: map[string]interface{}{
+				"request_id": "test-request-id",
+				"error":      "failed to parse log record from input string: CMD_LOG=not json",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected log records mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWorkerPromptArtifacts(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name multiline
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/multiline/app/index.py
+		function handler
+	}`)
+	defer fex.Cleanup()
+
+	for i := 0; i < 3; i++ {
+		resp := newResponseWriter(fex.logger)
+		if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+			t.Fatalf("unexpected invoke() error: %v", err)
+		}
+		if want := "line 1\n  line 2\n\nline 4"; string(resp.body) != want {
+			t.Fatalf("unexpected body: got %q, want %q", resp.body, want)
+		}
+	}
+
+	// Without the setup, the prompts would be written to stderr, and the
+	// value of the expression to stdout.
+	w := fex.workers.getWorkers()[0]
+	w.stdinWriter.WriteString("1 + 1\n")
+	w.stdinWriter.Flush()
+	w.stdin.Close()
+	b, _ := io.ReadAll(w.stderr)
+	if s := strings.TrimSpace(string(b)); s != "" {
+		t.Fatalf("unexpected worker stderr: %q", s)
+	}
+	select {
+	case line, ok := <-w.stdoutLines:
+		if ok {
+			t.Fatalf("unexpected worker stdout: %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("unexpected worker running after stdin is closed")
+	}
+}
+
+// isZombieProcess returns true when the process exited, but it was not
+// reaped by its parent.
+func isZombieProcess(t *testing.T, pid int) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		// The process was reaped.
+		return false
+	}
+	// The state follows the command name in parentheses, which may hold
+	// spaces, e.g. "1234 (python3) Z ...".
+	s := string(b)
+	i := strings.LastIndex(s, ")")
+	if i < 0 || i+2 >= len(s) {
+		t.Fatalf("unexpected process stat: %q", s)
+	}
+	return s[i+2] == 'Z'
+}
+
+func TestWorkerZombieReaping(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process state is read from /proc")
+	}
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name crash
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/crash/app/index.py
+		function crash_handler
+		workers 2
+	}`)
+	defer fex.Cleanup()
+
+	var pids []int
+	for i := 0; i < 20; i++ {
+		workers := fex.workers.getWorkers()
+		if i%2 == 0 {
+			// The worker crashes while serving the request.
+			pids = append(pids, workers[0].Pid)
+			fex.execWorker("GET", "", "", fex.newEnvelope(fex.buildRequestData(newRequest(t, "GET", "/"), "test-request-id")))
+			continue
+		}
+		// The idle worker crashes, and it is not replaced.
+		w := workers[1]
+		pids = append(pids, w.Pid)
+		w.Cmd.Process.Kill()
+		select {
+		case <-w.exited:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("worker %d process %d was not reaped", w.ID, w.Pid)
+		}
+		fex.workers.replace(w)
+	}
+
+	for _, pid := range pids {
+		if isZombieProcess(t, pid) {
+			t.Fatalf("unexpected zombie process %d", pid)
+		}
+	}
+}
+
+func TestWorkerClosedPipes(t *testing.T) {
+	for i, tc := range []struct {
+		name    string
+		close   func(w *worker)
+		wantErr error
+	}{
+		{
+			name:    "test closed stdin",
+			close:   func(w *worker) { w.stdin.Close() },
+			wantErr: errWorkerBrokenPipe,
+		},
+		{
+			name:    "test closed stdout",
+			close:   func(w *worker) { w.stdout.Close() },
+			wantErr: errWorkerExited,
+		},
+		{
+			name: "test unset pipes",
+			close: func(w *worker) {
+				w.stdinWriter = nil
+				w.stdoutLines = nil
+			},
+			wantErr: errWorkerBrokenPipe,
+		},
+		{
+			name:    "test terminated worker",
+			close:   func(w *worker) { w.terminate() },
+			wantErr: errWorkerBrokenPipe,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name hello_world
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/hello_world/app/index.py
+				function handler
+				workers 1
+			}`)
+			defer fex.Cleanup()
+
+			w := fex.workers.getWorkers()[0]
+			tc.close(w)
+			r, err := w.handle(fex.workers.handler, "test-request-id", fex.newEnvelope(fex.buildRequestData(newRequest(t, "GET", "/"), "test-request-id")))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("unexpected handle() error: got %v, want %v", err, tc.wantErr)
+			}
+			if !isWorkerError(err) {
+				t.Fatalf("unexpected non-worker error: %v", err)
+			}
+			if errors.Is(tc.wantErr, errWorkerBrokenPipe) && !isRetryableError(err) {
+				t.Fatalf("unexpected non-retryable error: %v", err)
+			}
+			if r.StatusCode != http.StatusBadGateway {
+				t.Fatalf("unexpected status code: got %d, want %d", r.StatusCode, http.StatusBadGateway)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerShutdownFunction(t *testing.T) {
+	for i, tc := range []struct {
+		name       string
+		function   string
+		invoke     bool
+		wantMarker bool
+	}{
+		{
+			name:       "test shutdown function runs before exit",
+			function:   "on_shutdown",
+			invoke:     true,
+			wantMarker: true,
+		},
+		{
+			name:     "test shutdown function skipped without import",
+			function: "on_shutdown",
+		},
+		{
+			name:     "test slow shutdown function is bounded by timeout",
+			function: "slow_shutdown",
+			invoke:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("SHUTDOWN_MARKER_DIR", dir)
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name shutdown
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/shutdown/app/index.py
+				function handler
+				shutdown_function `+tc.function+`
+				shutdown_timeout 500ms
+			}`)
+
+			w := fex.workers.getWorkers()[0]
+			if tc.invoke {
+				resp := newResponseWriter(fex.logger)
+				if err := fex.invoke(resp, newRequest(t, "GET", "/")); err != nil {
+					t.Fatalf("unexpected invoke() error: %v", err)
+				}
+			}
+			start := time.Now()
+			fex.Cleanup()
+			if d := time.Since(start); d > 3*time.Second {
+				t.Fatalf("unexpected shutdown duration: %s", d)
+			}
+			select {
+			case <-w.exited:
+			default:
+				t.Fatalf("worker process %d did not exit", w.Pid)
+			}
+
+			b, err := os.ReadFile(filepath.Join(dir, strconv.Itoa(w.Pid)))
+			if !tc.wantMarker {
+				if err == nil {
+					t.Fatalf("unexpected shutdown marker of process %d", w.Pid)
+				}
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if err != nil {
+				t.Fatalf("shutdown function did not run: %v", err)
+			}
+			if diff := cmp.Diff("flushed", string(b)); diff != "" {
+				t.Fatalf("unexpected shutdown marker mismatch (-want +got):\n%s", diff)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}
+
+func TestWorkerWriteTimeout(t *testing.T) {
+	// The worker never reads its input, so the writes block once the pipe
+	// buffer is full.
+	python := newBrokenPython(t, `[ "$1" = "-u" ] && exec sleep 30; exec python "$@"`)
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name hello_world
+		runtime python
+		python_executable `+python+`
+		entrypoint assets/scripts/api/hello_world/app/index.py
+		function handler
+		workers 1
+		import_timeout 200ms
+		write_timeout 200ms
+	}`)
+	defer fex.Cleanup()
+
+	w := fex.workers.getWorkers()[0]
+	data := fex.buildRequestData(newRequest(t, "POST", "/"), "test-request-id")
+	data["body"] = strings.Repeat("a", 256*1024)
+
+	start := time.Now()
+	r, err := w.handle(fex.workers.handler, "test-request-id", fex.newEnvelope(data))
+	if !errors.Is(err, errWorkerWriteTimeout) {
+		t.Fatalf("unexpected handle() error: got %v, want %v", err, errWorkerWriteTimeout)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Fatalf("unexpected write duration: %s", d)
+	}
+	if !isWorkerError(err) || !isRetryableError(err) {
+		t.Fatalf("unexpected non-retryable worker error: %v", err)
+	}
+	if r.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected status code: got %d, want %d", r.StatusCode, http.StatusGatewayTimeout)
+	}
+}
+
+func TestWorkerMaxTotalDuration(t *testing.T) {
+	fex := newTestFunctionExecutor(t, `
+	lambda {
+		name slow
+		runtime python
+		python_executable python
+		entrypoint assets/scripts/api/slow/app/index.py
+		function dribble_handler
+		workers 1
+		max_total_duration 2s
+	}`)
+	defer fex.Cleanup()
+
+	workerID := fex.workers.getWorkers()[0].ID
+	start := time.Now()
+	// The handler prints a line every second, so the worker timeout of 60
+	// seconds is never reached.
+	r, err := fex.execRequest(newRequest(t, "POST", "/"), "test-request-id")
+	if !errors.Is(err, errWorkerMaxDuration) || !errors.Is(err, errWorkerTimeout) {
+		t.Fatalf("unexpected execRequest() error: got %v, want %v", err, errWorkerMaxDuration)
+	}
+	if d := time.Since(start); d < 2*time.Second || d > 5*time.Second {
+		t.Fatalf("unexpected handler duration: %s", d)
+	}
+	if r.StatusCode != http.StatusRequestTimeout {
+		t.Fatalf("unexpected status code: got %d, want %d", r.StatusCode, http.StatusRequestTimeout)
+	}
+	// The worker still running the handler is replaced.
+	if id := fex.workers.getWorkers()[0].ID; id == workerID {
+		t.Fatalf("unexpected worker %d not replaced", id)
+	}
+}
+
+func TestWorkerHTTPError(t *testing.T) {
+	for i, tc := range []struct {
+		name        string
+		function    string
+		apiKey      string
+		statusCode  int
+		body        string
+		contentType string
+		retryAfter  string
+		err         error
+	}{
+		{
+			name:        "test handler aborts with forbidden",
+			function:    "handler",
+			statusCode:  http.StatusForbidden,
+			body:        "forbidden",
+			contentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:       "test handler does not abort",
+			function:   "handler",
+			apiKey:     "secret",
+			statusCode: http.StatusOK,
+			body:       "ok",
+		},
+		{
+			name:        "test handler aborts with headers and default message",
+			function:    "retry_handler",
+			statusCode:  http.StatusServiceUnavailable,
+			body:        "Service Unavailable",
+			contentType: "text/plain; charset=utf-8",
+			retryAfter:  "30",
+		},
+		{
+			name:       "test handler aborts with malformed status code",
+			function:   "malformed_handler",
+			statusCode: http.StatusInternalServerError,
+			err:        errHandlerFailed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fex := newTestFunctionExecutor(t, `
+			lambda {
+				name http_error
+				runtime python
+				python_executable python
+				entrypoint assets/scripts/api/http_error/app/index.py
+				function `+tc.function+`
+			}`)
+			defer fex.Cleanup()
+
+			req := newRequest(t, "GET", "/")
+			if tc.apiKey != "" {
+				req.Header.Set("X-Api-Key", tc.apiKey)
+			}
+			r, err := fex.execRequest(req, "test-request-id")
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected execRequest() error: %v, want: %v", err, tc.err)
+			}
+			if r.StatusCode != tc.statusCode {
+				t.Fatalf("unexpected status code: got %d, want %d", r.StatusCode, tc.statusCode)
+			}
+			if tc.err != nil {
+				t.Logf("PASS: Test %d", i)
+				return
+			}
+			if string(r.Body) != tc.body {
+				t.Fatalf("unexpected body: got %q, want %q", r.Body, tc.body)
+			}
+			if got := r.Headers.Get("Content-Type"); got != tc.contentType {
+				t.Fatalf("unexpected Content-Type header: got %q, want %q", got, tc.contentType)
+			}
+			if got := r.Headers.Get("Retry-After"); got != tc.retryAfter {
+				t.Fatalf("unexpected Retry-After header: got %q, want %q", got, tc.retryAfter)
+			}
+			t.Logf("PASS: Test %d", i)
+		})
+	}
+}